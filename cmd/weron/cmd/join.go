@@ -3,27 +3,28 @@ package cmd
 import (
 	"bufio"
 	"context"
-	"errors"
 	"fmt"
 	"log"
-	"math/rand"
-	"net/http"
+	"math"
+	"net"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/mdlayher/ethernet"
+	"github.com/pion/ice/v2"
 	"github.com/pion/webrtc/v3"
 	"github.com/pojntfx/weron/pkg/adapter"
-	"github.com/pojntfx/weron/pkg/encryption"
-	"github.com/pojntfx/weron/pkg/signaling"
+	"github.com/pojntfx/weron/pkg/agent"
+	"github.com/pojntfx/weron/pkg/config"
+	"github.com/pojntfx/weron/pkg/control"
+	"github.com/pojntfx/weron/pkg/proxy"
 	"github.com/pojntfx/weron/pkg/transport"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"nhooyr.io/websocket"
 )
 
 const (
@@ -38,21 +39,48 @@ const (
 	communityFlag      = "community"
 	deviceNameFlag     = "device-name"
 	canExitFlag        = "can-exit"
+	macTTLFlag         = "mac-ttl"
+	unorderedFlag      = "unordered"
+	maxRetransmitsFlag = "max-retransmits"
+	maxLifetimeFlag    = "max-packet-lifetime"
+	restartTimeoutFlag = "restart-timeout"
+	queueLengthFlag    = "queue-length"
+	receiveLengthFlag  = "receive-queue-length"
+	batchSizeFlag      = "batch-size"
+	batchDelayFlag     = "batch-delay"
+	compressionFlag    = "compression"
+	dropPolicyFlag     = "drop-policy"
+	highWatermarkFlag  = "high-watermark"
+	lowWatermarkFlag   = "low-watermark"
+	queuesFlag         = "queues"
+	offloadFlag        = "offload"
+	controlSocketFlag  = "control-socket"
+	userspaceFlag      = "userspace"
+	tunFlag            = "tun"
+	addressFlag        = "address"
+	routeFlag          = "route"
+	mtuFlag            = "mtu"
+	autoconfigureFlag  = "autoconfigure"
+	bridgeFlag         = "bridge"
+	netNSFlag          = "netns"
+	dhcpFlag           = "dhcp"
+	dhcpServerFlag     = "dhcp-server"
+	dhcpLeaseTimeFlag  = "dhcp-lease-time"
+	socks5Flag         = "socks5"
+	forwardFlag        = "forward"
+	exposeFlag         = "expose"
+	configFlag         = "config"
+	iceLaddrFlag       = "ice-laddr"
+	trunkFlag          = "trunk"
+	vlanFlag           = "vlan"
+
+	communitiesKey = "communities"
 )
 
-var (
-	errInvalidTURNServerAddr  = errors.New("invalid TURN server address")
-	errMissingTURNCredentials = errors.New("missing TURN server credentials")
-)
-
-type candidate struct {
-	mac string
-	i   webrtc.ICECandidate
-}
-
-type session struct {
-	mac string
-	o   webrtc.SessionDescription
+type frontend interface {
+	Open() error
+	Serve() error
+	Close() error
 }
 
 var joinCmd = &cobra.Command{
@@ -64,274 +92,221 @@ var joinCmd = &cobra.Command{
 			return nil
 		}
 
-		if key := viper.GetString(keyFlag); !(key == "" || len(key) == 16 || len(key) == 24 || len(key) == 32) {
-			return errors.New("key is not 16, 24 or 32 characters long")
-		}
+		if configFile := viper.GetString(configFlag); configFile != "" {
+			viper.SetConfigFile(configFile)
 
-		if strings.TrimSpace(viper.GetString(communityFlag)) == "" {
-			return errors.New("invalid community name")
+			if err := viper.ReadInConfig(); err != nil {
+				return err
+			}
 		}
 
-		return nil
-	},
-	RunE: func(cmd *cobra.Command, args []string) error {
-		done := false
-
-		var tap *adapter.TAP
-		deviceName := ""
-		var peers *transport.WebRTCManager
-		var signaler *signaling.SignalingClient
-
-		for {
-			sleep := viper.GetDuration(timeoutFlag) + time.Duration(time.Second*time.Duration(rand.Intn(5)))
-
-			ctx, cancelGlobal := context.WithCancel(context.Background())
-			defer cancelGlobal()
-
-			fatal := make(chan error)
-
-			var cleanup func() []error
-
-			go func() {
-				if tap == nil {
-					tap = adapter.NewTAP(viper.GetString(deviceNameFlag))
+		communities, err := getCommunities()
+		if err != nil {
+			return err
+		}
 
-					var err error
-					deviceName, err = tap.Open()
-					if err != nil {
-						fatal <- err
+		deviceNames := map[string]struct{}{}
+		vlans := map[int]struct{}{}
+		for _, v := range communities {
+			if err := validateCommunity(v); err != nil {
+				return err
+			}
 
-						return
-					}
+			if viper.GetString(trunkFlag) != "" {
+				if v.GetBool(tunFlag) || v.GetBool(userspaceFlag) || v.GetBool(autoconfigureFlag) || v.GetBool(dhcpFlag) || v.GetString(dhcpServerFlag) != "" || v.GetBool(offloadFlag) {
+					return config.ErrConflictingTrunk
 				}
 
-				iceServers := []webrtc.ICEServer{}
-
-				for _, stunServer := range viper.GetStringSlice(stunFlag) {
-					iceServers = append(iceServers, webrtc.ICEServer{
-						URLs: []string{stunServer},
-					})
+				vlan := v.GetInt(vlanFlag)
+				if vlan < 1 || vlan > 4094 {
+					return config.ErrInvalidVLANID
 				}
 
-				for _, turnServer := range viper.GetStringSlice(turnFlag) {
-					addrParts := strings.Split(turnServer, "@")
-					if len(addrParts) < 2 {
-						fatal <- errInvalidTURNServerAddr
-
-						return
-					}
+				if _, ok := vlans[vlan]; ok {
+					return fmt.Errorf("%w: %v", config.ErrVLANInUse, vlan)
+				}
 
-					authParts := strings.Split(addrParts[0], ":")
-					if len(addrParts) < 2 {
-						fatal <- errMissingTURNCredentials
+				vlans[vlan] = struct{}{}
 
-						return
-					}
+				continue
+			} else if v.GetInt(vlanFlag) != 0 {
+				return config.ErrVLANRequiresTrunk
+			}
 
-					iceServers = append(iceServers, webrtc.ICEServer{
-						URLs:           []string{addrParts[1]},
-						Username:       authParts[0],
-						Credential:     authParts[1],
-						CredentialType: webrtc.ICECredentialTypePassword,
-					})
+			// Devices can't be shared between communities, but devices without a name get a random one
+			if deviceName := v.GetString(deviceNameFlag); deviceName != "" && !v.GetBool(userspaceFlag) {
+				if _, ok := deviceNames[deviceName]; ok {
+					return fmt.Errorf("%w: %v", config.ErrDeviceInUse, deviceName)
 				}
 
-				candidates := make(chan candidate)
-				offers := make(chan session)
-				answers := make(chan session)
+				deviceNames[deviceName] = struct{}{}
+			}
+		}
 
-				peers = transport.NewWebRTCManager(
-					iceServers,
-					func(mac string, i webrtc.ICECandidate) {
-						if viper.GetBool(verboseFlag) {
-							log.Println("Handling outgoing candidate for MAC", mac)
-						}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		// Errors of the child command, the front ends and the agents stop all agents
+		fatal := make(chan error, 1)
+		fail := func(err error) {
+			select {
+			case fatal <- err:
+			default:
+			}
 
-						candidates <- candidate{mac, i}
-					},
-					func(mac string, frame []byte) {
-						if viper.GetBool(verboseFlag) {
-							log.Println("Handling outgoing frame for MAC", mac)
-						}
+			cancel()
+		}
 
-						frame, err := encryption.Decrypt(frame, []byte(viper.GetString(keyFlag)))
-						if err != nil {
-							fatal <- err
+		communities, err := getCommunities()
+		if err != nil {
+			return err
+		}
 
-							return
-						}
+		// Host candidates of all communities are gathered on one port if one is given; agents which have more than one community
+		// in common can't tell the communities' connections apart on a shared port, so every peer gets its own port by default
+		var udpMux ice.UDPMux
+		if laddr := viper.GetString(iceLaddrFlag); laddr != "" {
+			conn, err := net.ListenPacket("udp", laddr)
+			if err != nil {
+				return err
+			}
 
-						if _, err := tap.Write(frame); err != nil {
-							fatal <- err
+			udpMux = webrtc.NewICEUDPMux(nil, conn)
+			defer func() {
+				// Closing the mux closes the connection too
+				_ = udpMux.Close()
+			}()
 
-							return
-						}
-					},
-					func(mac string, o webrtc.SessionDescription) {
-						if viper.GetBool(verboseFlag) {
-							log.Println("Handling outgoing offer for MAC", mac)
-						}
+			if viper.GetBool(verboseFlag) {
+				log.Println("Gathering host candidates on", conn.LocalAddr())
+			}
+		}
 
-						offers <- session{mac, o}
-					},
-					func(mac string, o webrtc.SessionDescription) {
-						if viper.GetBool(verboseFlag) {
-							log.Println("Handling outgoing answer for MAC", mac)
-						}
+		frontends := []frontend{}
+		defer func() {
+			for _, f := range frontends {
+				// Ignore as this can be a no-op
+				_ = f.Close()
+			}
+		}()
 
-						answers <- session{mac, o}
-					},
-					func(mac string) {
-						log.Println("Peer with MAC", mac, "connected")
-					},
-					func(mac string) {
-						log.Println("Peer with MAC", mac, "disconnected")
-					},
-				)
+		// The communities share one TAP device and are told apart by the VLAN IDs of their frames
+		var trunk *adapter.Trunk
+		if name := viper.GetString(trunkFlag); name != "" {
+			trunk = adapter.NewTrunk(name, viper.GetString(netNSFlag))
 
-				if err := os.MkdirAll(filepath.Dir(viper.GetString(tlsHostsFlag)), os.ModePerm); err != nil {
-					fatal <- err
+			trunkName, err := trunk.Open()
+			if err != nil {
+				return err
+			}
+			defer func() {
+				// Ignore as this can be a no-op
+				_ = trunk.Close()
+			}()
 
-					return
-				}
+			// The link flags apply to the trunk instead of the devices of the communities
+			link := adapter.LinkConfig{
+				Addresses: viper.GetStringSlice(addressFlag),
+				Routes:    viper.GetStringSlice(routeFlag),
+				MTU:       viper.GetInt(mtuFlag),
+				Bridge:    viper.GetString(bridgeFlag),
+			}
 
-				var conn *websocket.Conn
-				retryWithFingerprint := false
-				for {
-					client := &http.Client{Timeout: sleep}
-					if viper.GetString(tlsFingerprintFlag) != "" || retryWithFingerprint || viper.GetBool(tlsInsecureFlag) {
-						httpTransport := http.DefaultTransport.(*http.Transport).Clone()
-						httpTransport.TLSClientConfig = encryption.GetInteractiveTLSConfig(
-							viper.GetBool(tlsInsecureFlag),
-							viper.GetString(tlsFingerprintFlag),
-							viper.GetString(tlsHostsFlag),
-							viper.GetString(raddrFlag),
-							func(err error) {
-								fatal <- err
-							},
-							cmd.Printf,
-							func(s string, i ...interface{}) (string, error) {
-								fmt.Printf(s, i...)
-
-								scanner := bufio.NewScanner(os.Stdin)
-								scanner.Scan()
-								if err := scanner.Err(); err != nil {
-									return "", err
-								}
-
-								return strings.TrimSuffix(scanner.Text(), "\n"), nil
-							},
-						)
-						client.Transport = httpTransport
-					}
+			if err := adapter.ConfigureLink(trunkName, viper.GetString(netNSFlag), link); err != nil {
+				return err
+			}
+			defer func() {
+				if err := adapter.UnconfigureLink(trunkName, viper.GetString(netNSFlag), link); err != nil {
+					log.Println("could not remove link configuration, continuing:", err)
+				}
+			}()
 
-					ctx, cancel := context.WithTimeout(ctx, sleep)
-					defer cancel()
+			go func() {
+				// Reading fails once the trunk is closed during shutdown
+				if err := trunk.Serve(); err != nil && ctx.Err() == nil {
+					fail(err)
+				}
+			}()
+		}
 
-					log.Println("Agent connecting to signaler", viper.GetString(raddrFlag))
+		agents := []*agent.Agent{}
+		agentConfigs := []agent.AgentConfig{}
+		for _, v := range communities {
+			agentConfig := getAgentConfig(v)
+			agentConfig.UDPMux = udpMux
 
-					var err error
-					conn, _, err = websocket.Dial(ctx, viper.GetString(raddrFlag), &websocket.DialOptions{HTTPClient: client})
-					if err != nil {
-						if strings.Contains(err.Error(), "x509:") {
-							retryWithFingerprint = true
+			if trunk != nil {
+				agentConfig.Adapter = trunk.VLAN(uint16(v.GetInt(vlanFlag)))
+			}
 
-							continue
-						}
+			// Agents which have joined more than one community share one control socket
+			if len(communities) > 1 {
+				agentConfig.ControlSocket = ""
+			}
 
-						log.Println("Agent crashed, restarting in", sleep.String()+":", err)
+			// Prefix log messages with the community so that they can be told apart
+			community := ""
+			if len(communities) > 1 {
+				community = "[" + agentConfig.Community + "] "
+			}
 
-						time.Sleep(sleep)
+			communityFrontends := []frontend{}
+			if v.GetBool(userspaceFlag) {
+				netstack := adapter.NewNetstack(v.GetInt(mtuFlag), v.GetStringSlice(addressFlag))
 
-						continue
-					}
+				agentConfig.Adapter = netstack
 
-					break
+				if laddr := v.GetString(socks5Flag); laddr != "" {
+					communityFrontends = append(communityFrontends, proxy.NewSOCKS5Server(laddr, netstack.DialContext))
 				}
 
-				mac, err := tap.GetMACAddress()
-				if err != nil {
-					fatal <- err
+				// Forwards listen on the host and connect to the overlay
+				for _, forward := range v.GetStringSlice(forwardFlag) {
+					laddr, raddr, _ := parseForward(forward)
 
-					return
+					communityFrontends = append(communityFrontends, proxy.NewForwarder(laddr, raddr, net.Listen, netstack.DialContext))
 				}
 
-				signaler = signaling.NewSignalingClient(
-					conn,
-					mac.String(),
-					viper.GetString(communityFlag),
-
-					ctx,
-					sleep,
+				// Exposes listen on the overlay and connect to the host
+				for _, expose := range v.GetStringSlice(exposeFlag) {
+					laddr, raddr, _ := parseForward(expose)
 
-					func(mac string) {
-						if viper.GetBool(verboseFlag) {
-							log.Println("Handling incoming introduction for MAC", mac)
-						}
-
-						if err := peers.HandleIntroduction(mac); err != nil {
-							fatal <- err
-
-							return
-						}
-					},
-					func(mac string, o webrtc.SessionDescription) {
-						if viper.GetBool(verboseFlag) {
-							log.Println("Handling incoming offer for MAC", mac)
-						}
-
-						if err := peers.HandleOffer(mac, o); err != nil {
-							fatal <- err
-
-							return
-						}
-					},
-					func(mac string, i webrtc.ICECandidateInit) {
-						if viper.GetBool(verboseFlag) {
-							log.Println("Handling incoming candidate for MAC", mac)
-						}
+					communityFrontends = append(communityFrontends, proxy.NewForwarder(laddr, raddr, netstack.Listen, (&net.Dialer{}).DialContext))
+				}
 
-						if err := peers.HandleCandidate(mac, i); err != nil {
-							fatal <- err
+				frontends = append(frontends, communityFrontends...)
+			}
 
-							return
-						}
-					},
-					func(mac string, o webrtc.SessionDescription) {
-						if viper.GetBool(verboseFlag) {
-							log.Println("Handling incoming answer for MAC", mac)
+			agents = append(agents, agent.NewAgent(
+				agentConfig,
+				func(deviceName string) error {
+					// The userspace network stack can only be used once it has been opened
+					for _, f := range communityFrontends {
+						if err := f.Open(); err != nil {
+							return err
 						}
 
-						if err := peers.HandleAnswer(mac, o); err != nil {
-							fatal <- err
+						go func(f frontend) {
+							if err := f.Serve(); err != nil {
+								fail(err)
+							}
+						}(f)
+					}
 
-							return
-						}
-					},
-					func(mac string, blocked bool) {
-						if blocked {
-							log.Println("Blocked connection to peer", mac, "due to wrong encryption key")
-						}
+					if len(args) == 0 {
+						return nil
+					}
 
-						// Ignore as this can be a no-op
-						_ = peers.HandleResignation(mac)
-					},
-					func(data []byte) ([]byte, error) {
-						return encryption.Encrypt(data, []byte(viper.GetString(keyFlag)))
-					},
-					func(data []byte) ([]byte, error) {
-						return encryption.Decrypt(data, []byte(viper.GetString(keyFlag)))
-					},
-				)
-
-				var command *exec.Cmd
-				if len(args) > 0 {
 					extraArgs := []string{}
 					if len(args) > 1 {
 						extraArgs = append(extraArgs, args[1:]...)
 					}
 
-					command = exec.CommandContext(ctx, args[0], extraArgs...)
+					// The child command is started for the device of each community
+					command := exec.CommandContext(ctx, args[0], extraArgs...)
 
 					command.Stdin = os.Stdin
 					command.Stdout = os.Stdout
@@ -340,178 +315,306 @@ var joinCmd = &cobra.Command{
 
 					if err := command.Start(); err != nil {
 						if !viper.GetBool(canExitFlag) {
-							fatal <- err
+							return err
+						}
+
+						return nil
+					}
 
+					go func() {
+						err := command.Wait()
+
+						// The child command is killed once the agent shuts down
+						if viper.GetBool(canExitFlag) || err == nil || ctx.Err() != nil {
 							return
 						}
+
+						fail(err)
+					}()
+
+					return nil
+				},
+				func(raddr string) {
+					log.Println(community+"Agent connected to signaler", raddr)
+				},
+				func(raddr string, err error, reconnectIn time.Duration) {
+					log.Println(community+"Agent disconnected from signaler, reconnecting in", reconnectIn.String()+":", err)
+				},
+				func(mac string) {
+					log.Println(community+"Peer with MAC", mac, "connected")
+				},
+				func(mac string) {
+					log.Println(community+"Peer with MAC", mac, "disconnected")
+				},
+				cmd.Printf,
+				func(s string, i ...interface{}) (string, error) {
+					fmt.Printf(s, i...)
+
+					scanner := bufio.NewScanner(os.Stdin)
+					scanner.Scan()
+					if err := scanner.Err(); err != nil {
+						return "", err
 					}
 
-					go func() {
-						if !viper.GetBool(canExitFlag) {
-							if err := command.Wait(); err != nil {
-								fatal <- err
+					return strings.TrimSuffix(scanner.Text(), "\n"), nil
+				},
+			))
+			agentConfigs = append(agentConfigs, agentConfig)
+		}
 
-								return
-							}
+		if path := viper.GetString(controlSocketFlag); path != "" && len(agents) > 1 {
+			controlServer := control.NewControlServer(
+				path,
+				func() []control.Status {
+					status := []control.Status{}
+					for _, a := range agents {
+						status = append(status, a.Status())
+					}
 
-							if !command.ProcessState.Success() {
-								fatal <- err
+					return status
+				},
+				func() []control.Peers {
+					peers := []control.Peers{}
+					for i, a := range agents {
+						peers = append(peers, control.Peers{Community: agentConfigs[i].Community, Stats: a.Stats()})
+					}
 
-								return
-							}
-						}
-					}()
-				}
+					return peers
+				},
+			)
 
-				frameSize, err := tap.GetFrameSize()
-				if err != nil {
-					fatal <- err
+			if err := controlServer.Open(); err != nil {
+				return err
+			}
+			defer func() {
+				// Ignore as this can be a no-op
+				_ = controlServer.Close()
+			}()
 
-					return
+			go func() {
+				if err := controlServer.Serve(); err != nil {
+					fail(err)
 				}
+			}()
 
-				go func() {
-					for {
-						select {
-						case candidate := <-candidates:
-							if err := signaler.SignalCandidate(candidate.mac, candidate.i); err != nil {
-								fatal <- err
+			if viper.GetBool(verboseFlag) {
+				log.Println("Control socket listening on", path)
+			}
+		}
 
-								return
-							}
-						case offer := <-offers:
-							if err := signaler.SignalOffer(offer.mac, offer.o); err != nil {
-								fatal <- err
+		s := make(chan os.Signal, 1)
+		signal.Notify(s, os.Interrupt)
+		go func() {
+			<-s
 
-								return
-							}
-						case answer := <-answers:
-							if err := signaler.SignalAnswer(answer.mac, answer.o); err != nil {
-								fatal <- err
+			log.Println("Gracefully shutting down agent")
 
-								return
-							}
-						}
-					}
-				}()
+			s := make(chan os.Signal, 1)
+			signal.Notify(s, os.Interrupt)
+			go func() {
+				<-s
 
-				cleanup = func() []error {
-					if done {
-						if err := tap.Close(); err != nil {
-							return []error{err}
-						}
-					}
+				log.Println("Forcing shutdown of agent")
 
-					if err := signaler.Close(); err != nil {
-						return []error{err}
-					}
+				os.Exit(1)
+			}()
 
-					if err := peers.Close(); len(err) > 1 {
-						return err
-					}
+			cancel()
+		}()
 
-					return []error{}
+		var wg sync.WaitGroup
+		for _, a := range agents {
+			wg.Add(1)
+			go func(a *agent.Agent) {
+				defer wg.Done()
+
+				if err := a.Run(ctx); err != nil {
+					fail(err)
 				}
+			}(a)
+		}
+		wg.Wait()
 
-				go func() {
-					if err := signaler.Run(); err != nil {
-						fatal <- err
+		select {
+		case err := <-fatal:
+			return err
+		default:
+			return nil
+		}
+	},
+}
 
-						return
-					}
-				}()
+// getCommunities returns the configuration of each community to join; communities from the config file inherit the flags, which they can override
+func getCommunities() ([]*viper.Viper, error) {
+	entries := []map[string]interface{}{}
+	if err := viper.UnmarshalKey(communitiesKey, &entries); err != nil {
+		return nil, err
+	}
 
-				log.Println("Agent connected to signaler", viper.GetString(raddrFlag))
+	if len(entries) == 0 {
+		return []*viper.Viper{viper.GetViper()}, nil
+	}
 
-				for {
-					frame := make([]byte, frameSize)
-					if _, err := tap.Read(frame); err != nil {
-						fatal <- err
+	communities := []*viper.Viper{}
+	for _, entry := range entries {
+		v := viper.New()
+		for _, key := range viper.AllKeys() {
+			if key != communitiesKey {
+				v.SetDefault(key, viper.Get(key))
+			}
+		}
 
-						return
-					}
+		if err := v.MergeConfigMap(entry); err != nil {
+			return nil, err
+		}
 
-					var parsedFrame ethernet.Frame
-					if err := parsedFrame.UnmarshalBinary(frame); err != nil {
-						log.Println("could not parse frame, continuing:", err)
+		communities = append(communities, v)
+	}
 
-						continue
-					}
+	return communities, nil
+}
+
+func validateCommunity(v *viper.Viper) error {
+	if v.GetInt(maxRetransmitsFlag) > math.MaxUint16 {
+		return config.ErrInvalidMaxRetransmits
+	}
 
-					frame, err = encryption.Encrypt(frame, []byte(viper.GetString(keyFlag)))
-					if err != nil {
-						fatal <- err
+	if v.GetDuration(maxLifetimeFlag) > time.Millisecond*math.MaxUint16 {
+		return config.ErrInvalidMaxPacketLifetime
+	}
 
-						return
-					}
+	if v.GetBool(userspaceFlag) && v.GetBool(tunFlag) {
+		return config.ErrConflictingDeviceTypes
+	}
 
-					if err := peers.Write(parsedFrame.Destination.String(), frame); err != nil {
-						if viper.GetBool(verboseFlag) {
-							log.Println("could not write to peer, continuing:", err)
-						}
+	if !v.GetBool(userspaceFlag) && (v.GetString(socks5Flag) != "" || len(v.GetStringSlice(forwardFlag)) > 0 || len(v.GetStringSlice(exposeFlag)) > 0) {
+		return config.ErrProxyRequiresUserspace
+	}
 
-						continue
-					}
-				}
-			}()
+	if v.GetBool(userspaceFlag) && len(v.GetStringSlice(routeFlag)) > 0 {
+		return config.ErrRoutesRequireDevice
+	}
 
-			s := make(chan os.Signal)
-			signal.Notify(s, os.Interrupt)
-			go func() {
-				<-s
+	if v.GetBool(userspaceFlag) && v.GetBool(autoconfigureFlag) {
+		return config.ErrAutoconfigurationRequiresTAP
+	}
 
-				log.Println("Gracefully shutting down agent")
+	if v.GetBool(userspaceFlag) && v.GetString(bridgeFlag) != "" {
+		return config.ErrBridgeRequiresTAP
+	}
 
-				s := make(chan os.Signal)
-				signal.Notify(s, os.Interrupt)
-				go func() {
-					<-s
+	if v.GetBool(userspaceFlag) && v.GetString(netNSFlag) != "" {
+		return config.ErrNetNSRequiresDevice
+	}
 
-					log.Println("Forcing shutdown of agent")
+	if v.GetBool(userspaceFlag) && v.GetBool(offloadFlag) {
+		return config.ErrOffloadRequiresTAP
+	}
 
-					cancelGlobal()
-				}()
+	for _, forward := range append(v.GetStringSlice(forwardFlag), v.GetStringSlice(exposeFlag)...) {
+		if _, _, err := parseForward(forward); err != nil {
+			return err
+		}
+	}
 
-				done = true
-				fatal <- nil
+	return getAgentConfig(v).Validate()
+}
 
-				if cleanup != nil {
-					// Ignore as this can be a no-op
-					_ = cleanup()
-				}
+func parseForward(forward string) (string, string, error) {
+	parts := strings.Split(forward, "=")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", config.ErrInvalidForward
+	}
 
-				cancelGlobal()
-			}()
+	return parts[0], parts[1], nil
+}
 
-			err := <-fatal
+func getAgentConfig(v *viper.Viper) agent.AgentConfig {
+	return agent.AgentConfig{
+		Raddr:          v.GetString(raddrFlag),
+		Key:            v.GetString(keyFlag),
+		Community:      v.GetString(communityFlag),
+		DeviceName:     v.GetString(deviceNameFlag),
+		NetNS:          v.GetString(netNSFlag),
+		TUN:            v.GetBool(tunFlag),
+		Offload:        v.GetBool(offloadFlag),
+		Autoconfigure:  v.GetBool(autoconfigureFlag),
+		DHCP:           v.GetBool(dhcpFlag),
+		DHCPServer:     v.GetString(dhcpServerFlag),
+		DHCPLeaseTime:  v.GetDuration(dhcpLeaseTimeFlag),
+		STUNServers:    v.GetStringSlice(stunFlag),
+		TURNServers:    v.GetStringSlice(turnFlag),
+		TLSFingerprint: v.GetString(tlsFingerprintFlag),
+		TLSInsecure:    v.GetBool(tlsInsecureFlag),
+		TLSHosts:       v.GetString(tlsHostsFlag),
+		Timeout:        v.GetDuration(timeoutFlag),
+		MACTTL:         v.GetDuration(macTTLFlag),
+		RestartTimeout: v.GetDuration(restartTimeoutFlag),
+		Reliability:    getReliability(v),
+		Queueing:       getQueueing(v),
+		Batching:       getBatching(v),
+		Compressions:   getCompressions(v),
+		ControlSocket:  v.GetString(controlSocketFlag),
+		Verbose:        v.GetBool(verboseFlag),
+		Link: adapter.LinkConfig{
+			Addresses: v.GetStringSlice(addressFlag),
+			Routes:    v.GetStringSlice(routeFlag),
+			MTU:       v.GetInt(mtuFlag),
+			Bridge:    v.GetString(bridgeFlag),
+		},
+	}
+}
 
-			if done {
-				return nil
-			}
+func getReliability(v *viper.Viper) transport.Reliability {
+	reliability := transport.Reliability{
+		Unordered: v.GetBool(unorderedFlag),
+	}
 
-			if err == nil {
-				return nil
-			}
+	if maxRetransmits := v.GetInt(maxRetransmitsFlag); maxRetransmits >= 0 {
+		r := uint16(maxRetransmits)
+
+		reliability.MaxRetransmits = &r
+	}
 
-			log.Println("Agent crashed, restarting in", sleep.String()+":", err)
+	if maxLifetime := v.GetDuration(maxLifetimeFlag); maxLifetime > 0 {
+		l := uint16(maxLifetime.Milliseconds())
 
-			time.Sleep(sleep)
+		reliability.MaxPacketLifeTime = &l
+	}
 
-			if cleanup != nil {
-				// Ignore as this can be a no-op
-				go cleanup()
-			}
-		}
-	},
+	return reliability
+}
+
+func getQueueing(v *viper.Viper) transport.Queueing {
+	return transport.Queueing{
+		Length:        v.GetInt(queueLengthFlag),
+		ReceiveLength: v.GetInt(receiveLengthFlag),
+		Policy:        transport.DropPolicy(v.GetString(dropPolicyFlag)),
+		HighWatermark: v.GetUint64(highWatermarkFlag),
+		LowWatermark:  v.GetUint64(lowWatermarkFlag),
+		Queues:        v.GetInt(queuesFlag),
+	}
+}
+
+func getBatching(v *viper.Viper) transport.Batching {
+	return transport.Batching{
+		MaxSize:  v.GetInt(batchSizeFlag),
+		MaxDelay: v.GetDuration(batchDelayFlag),
+	}
+}
+
+func getCompressions(v *viper.Viper) []transport.Compression {
+	compressions := []transport.Compression{}
+	for _, c := range v.GetStringSlice(compressionFlag) {
+		compressions = append(compressions, transport.Compression(c))
+	}
+
+	return compressions
 }
 
 func init() {
 	// Get default working dir
-	home, err := os.UserHomeDir()
-	if err != nil {
-		panic(err)
-	}
-	workingDirectoryDefault := filepath.Join(home, ".local", "share", "weron", "var", "lib", "weron")
+	workingDirectoryDefault := getWorkingDirectoryDefault()
 
 	joinCmd.PersistentFlags().StringP(raddrFlag, "r", "wss://weron.herokuapp.com/", "Signaler address")
 	joinCmd.PersistentFlags().StringP(keyFlag, "k", "", "Key for community (16, 24 or 32 characters long)")
@@ -523,6 +626,40 @@ func init() {
 	joinCmd.PersistentFlags().StringP(communityFlag, "c", "", "Name of the community to join")
 	joinCmd.PersistentFlags().StringP(deviceNameFlag, "d", "", "Name to give the created network interface (if supported by the OS; if not specified, a random name will be chosen)")
 	joinCmd.PersistentFlags().BoolP(canExitFlag, "e", false, "Whether the child command can exit with a non-zero exit code")
+	joinCmd.PersistentFlags().Duration(macTTLFlag, time.Minute*5, "Time after which MAC addresses learned from frames sent by peers expire")
+	joinCmd.PersistentFlags().Bool(unorderedFlag, false, "Allow frames to be delivered out of order (the mode of the peer which opens the data channel is used by both peers)")
+	joinCmd.PersistentFlags().Int(maxRetransmitsFlag, -1, "Maximum number of retransmits for a frame before it is dropped (-1 retransmits until delivered; can't be combined with --max-packet-lifetime)")
+	joinCmd.PersistentFlags().Duration(maxLifetimeFlag, 0, "Maximum time to retransmit a frame for before it is dropped (0 retransmits until delivered; can't be combined with --max-retransmits)")
+	joinCmd.PersistentFlags().Int(queueLengthFlag, 1024, "Maximum number of frames to queue for a peer before frames are dropped")
+	joinCmd.PersistentFlags().Int(receiveLengthFlag, 1024, "Maximum number of frames received from a peer to queue before reading from the peer is paused")
+	joinCmd.PersistentFlags().String(dropPolicyFlag, string(transport.DropTail), "Frames to drop once a peer's queue is full (tail drops new frames, head drops the oldest queued frames)")
+	joinCmd.PersistentFlags().Uint64(highWatermarkFlag, 1024*1024, "Number of bytes buffered by a peer's data channel after which queued frames are held back")
+	joinCmd.PersistentFlags().Uint64(lowWatermarkFlag, 256*1024, "Number of bytes buffered by a peer's data channel below which held back frames are sent again")
+	joinCmd.PersistentFlags().Bool(offloadFlag, false, "Exchange TCP segments larger than the MTU with the TAP device (IFF_VNET_HDR) and with peers which support it, which are segmented for peers which don't (Linux only)")
+	joinCmd.PersistentFlags().Int(queuesFlag, 1, "Number of TAP/TUN device queues to read from in parallel and of queues to send to each peer from (Linux only for the device; frames of one flow always use the same queue)")
+	joinCmd.PersistentFlags().Int(batchSizeFlag, 0, "Maximum number of bytes to pack into one message to a peer (0 disables batching; the mode of the peer which opens the data channel is used by both peers)")
+	joinCmd.PersistentFlags().Duration(batchDelayFlag, 0, "Time to wait for more frames before sending an incomplete batch (0 only batches frames which are already queued)")
+	joinCmd.PersistentFlags().StringSlice(compressionFlag, []string{}, "Comma-seperated list of compression algorithms to offer to peers in order of preference (zstd or s2; frames are only compressed if both peers support an algorithm)")
+	joinCmd.PersistentFlags().Duration(restartTimeoutFlag, time.Minute, "Time to try restarting ICE for after the connection to a peer has been interrupted before giving up")
+	joinCmd.PersistentFlags().Bool(tunFlag, false, "Use a TUN device (layer 3) instead of a TAP device (layer 2); packets are only sent to the peer which advertises their destination address")
+	joinCmd.PersistentFlags().Bool(userspaceFlag, false, "Use a userspace network stack instead of a TAP device (doesn't require root; use --socks5, --forward or --expose to reach the overlay)")
+	joinCmd.PersistentFlags().StringSlice(addressFlag, []string{}, "Comma-seperated list of addresses in CIDR notation to assign to the device (i.e. 10.0.0.1/24,fd00::1/64; they are removed again on shutdown)")
+	joinCmd.PersistentFlags().StringSlice(routeFlag, []string{}, "Comma-seperated list of routes in CIDR notation to add through the device, optionally with a gateway (i.e. 10.1.0.0/16,10.2.0.0/16=10.0.0.2; they are removed again on shutdown)")
+	joinCmd.PersistentFlags().Int(mtuFlag, 0, "MTU to set on the device (0 keeps the device's default MTU)")
+	joinCmd.PersistentFlags().String(netNSFlag, "", "Network namespace to create and configure the device in, either by name or by path (i.e. tenant1 or /var/run/netns/tenant1; WebRTC connections are still made from the current namespace)")
+	joinCmd.PersistentFlags().String(bridgeFlag, "", "Name of a bridge to attach the TAP device to, which is created if it doesn't exist (addresses and routes are configured on the bridge; i.e. br0)")
+	joinCmd.PersistentFlags().Bool(autoconfigureFlag, false, "Assign an IPv4 link-local (169.254.0.0/16) and an IPv6 address (in a prefix derived from the community) derived from the device's MAC address once no peer uses them")
+	joinCmd.PersistentFlags().Bool(dhcpFlag, false, "Request an IPv4 address from a DHCP server on the overlay")
+	joinCmd.PersistentFlags().String(dhcpServerFlag, "", "Address in CIDR notation to assign to the device and to answer DHCP requests from peers with, leasing the other addresses in its network (i.e. 10.0.0.1/24)")
+	joinCmd.PersistentFlags().Duration(dhcpLeaseTimeFlag, time.Hour, "Time for which addresses are leased by the DHCP server")
+	joinCmd.PersistentFlags().String(socks5Flag, "", "Local address to listen on for SOCKS5 connections to the overlay (i.e. 127.0.0.1:1080; requires --userspace)")
+	joinCmd.PersistentFlags().StringSlice(forwardFlag, []string{}, "Comma-seperated list of local addresses to forward to addresses on the overlay (i.e. 127.0.0.1:8080=10.0.0.2:80; requires --userspace)")
+	joinCmd.PersistentFlags().StringSlice(exposeFlag, []string{}, "Comma-seperated list of addresses on the overlay to forward to local addresses (i.e. 10.0.0.1:80=127.0.0.1:8080; requires --userspace)")
+	joinCmd.PersistentFlags().String(trunkFlag, "", "Name of a TAP device to share between all communities, which tags the frames of each community with its --vlan (802.1Q; --address, --route, --mtu, --bridge and --netns apply to this device)")
+	joinCmd.PersistentFlags().Int(vlanFlag, 0, "VLAN ID (1-4094) of the community on the --trunk device")
+	joinCmd.PersistentFlags().String(iceLaddrFlag, "", "Local address to gather host candidates for all peers of all communities on (i.e. :5000; if not specified, the communities don't share a port and a new port is used for each peer, as agents with more than one community in common can't tell their connections apart on a shared port)")
+	joinCmd.PersistentFlags().String(configFlag, "", "Path to a YAML, JSON or TOML file with values for the flags; a list of flag values under \"communities\" joins each of the communities")
+	joinCmd.PersistentFlags().String(controlSocketFlag, "", "Path to the UNIX socket to expose the agent's status and peers on for weron status and weron peers (disabled by default so that multiple agents can run on one host)")
 
 	viper.AutomaticEnv()
 
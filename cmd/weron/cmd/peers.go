@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/pojntfx/weron/pkg/config"
+	"github.com/pojntfx/weron/pkg/control"
+	"github.com/pojntfx/weron/pkg/transport"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var peersCmd = &cobra.Command{
+	Use:     "peers",
+	Aliases: []string{"pee", "p"},
+	Short:   "List the peers of a running agent",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return viper.BindPFlags(cmd.PersistentFlags())
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if viper.GetString(controlSocketFlag) == "" {
+			return config.ErrMissingControlSocket
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), viper.GetDuration(timeoutFlag))
+		defer cancel()
+
+		communities, err := control.NewControlClient(viper.GetString(controlSocketFlag)).Peers(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, peers := range communities {
+			sort.Slice(peers.Peers, func(i, j int) bool {
+				return peers.Peers[i].MAC < peers.Peers[j].MAC
+			})
+		}
+
+		if viper.GetBool(jsonFlag) {
+			return printJSON(communities)
+		}
+
+		for i, peers := range communities {
+			// Separate the communities of agents which have joined more than one
+			if len(communities) > 1 {
+				if i > 0 {
+					fmt.Println()
+				}
+
+				fmt.Printf("Community: %v\n\n", peers.Community)
+			}
+
+			if err := printPeers(peers.Stats); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	},
+}
+
+func printPeers(peers transport.Stats) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "MAC\tSTATE\tICE\tLOCAL\tREMOTE\tRTT\tSENT\tRECEIVED\tQUEUED\tDROPPED\tCOMPRESSION")
+	for _, p := range peers.Peers {
+		compression := "none"
+		if p.Compression != transport.CompressionNone {
+			compression = fmt.Sprintf("%v (%.2f)", p.Compression, p.CompressionRatio)
+		}
+
+		fmt.Fprintf(
+			w,
+			"%v\t%v\t%v\t%v\t%v\t%v\t%v B / %v\t%v B / %v\t%v\t%v\t%v\n",
+			p.MAC,
+			p.ConnectionState,
+			p.ICEConnectionState,
+			formatCandidate(p.LocalCandidate),
+			formatCandidate(p.RemoteCandidate),
+			p.RTT,
+			p.BytesSent,
+			p.FramesSent,
+			p.BytesReceived,
+			p.FramesReceived,
+			p.QueueDepth,
+			p.DroppedFrames,
+			compression,
+		)
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Printf(
+		"\nDropped frames: %v without peer, %v without data channel, %v with full queue, %v with send error, %v too large to send; %v frames could not be decrypted\n",
+		peers.DroppedNoPeer,
+		peers.DroppedNoDataChannel,
+		peers.DroppedQueueFull,
+		peers.DroppedSendError,
+		peers.DroppedTooLarge,
+		peers.DecryptionFailures,
+	)
+
+	return nil
+}
+
+func formatCandidate(c *transport.CandidateStats) string {
+	if c == nil {
+		return "-"
+	}
+
+	return fmt.Sprintf("%v %v/%v:%v", c.Type, c.Protocol, c.Address, c.Port)
+}
+
+func init() {
+	peersCmd.PersistentFlags().String(controlSocketFlag, "", "Path to the control socket of the agent to query (the agent's --control-socket)")
+	peersCmd.PersistentFlags().Bool(jsonFlag, false, "Print the peers as JSON")
+
+	viper.AutomaticEnv()
+
+	rootCmd.AddCommand(peersCmd)
+}
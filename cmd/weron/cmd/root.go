@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -27,6 +29,15 @@ https://github.com/pojntfx/weron.`,
 	},
 }
 
+func getWorkingDirectoryDefault() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		panic(err)
+	}
+
+	return filepath.Join(home, ".local", "share", "weron", "var", "lib", "weron")
+}
+
 func Execute() error {
 	rootCmd.PersistentFlags().BoolP(verboseFlag, "v", false, "Enable verbose logging")
 	rootCmd.PersistentFlags().DurationP(timeoutFlag, "m", time.Second*5, "Duration between reconnects and pings")
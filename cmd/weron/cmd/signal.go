@@ -12,9 +12,11 @@ import (
 	"os/signal"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	api "github.com/pojntfx/weron/pkg/api/websockets/v1"
+	"github.com/pojntfx/weron/pkg/config"
 	"github.com/pojntfx/weron/pkg/encryption"
 	"github.com/pojntfx/weron/pkg/signaling"
 	"github.com/spf13/cobra"
@@ -24,10 +26,13 @@ import (
 )
 
 const (
-	laddrFlag   = "laddr"
-	tlsFlag     = "tls"
-	tlsKeyFlag  = "tls-key"
-	tlsCertFlag = "tls-cert"
+	laddrFlag    = "laddr"
+	tlsFlag      = "tls"
+	tlsKeyFlag   = "tls-key"
+	tlsCertFlag  = "tls-cert"
+	cidrFlag     = "cidr"
+	leaseTTLFlag = "lease-ttl"
+	leasesFlag   = "leases"
 )
 
 var signalCmd = &cobra.Command{
@@ -35,7 +40,13 @@ var signalCmd = &cobra.Command{
 	Aliases: []string{"sig", "s"},
 	Short:   "Start a signaling server",
 	PreRunE: func(cmd *cobra.Command, args []string) error {
-		return viper.BindPFlags(cmd.PersistentFlags())
+		if err := viper.BindPFlags(cmd.PersistentFlags()); err != nil {
+			return err
+		}
+
+		_, err := getNetworks()
+
+		return err
 	},
 	RunE: func(cmd *cobra.Command, args []string) error {
 		sleep := viper.GetDuration(timeoutFlag) + time.Duration(time.Second*time.Duration(rand.Intn(5)))
@@ -90,7 +101,16 @@ var signalCmd = &cobra.Command{
 			}
 		}
 
+		networks, err := getNetworks()
+		if err != nil {
+			return err
+		}
+
 		communities := signaling.NewCommunitiesManager(
+			networks,
+			viper.GetDuration(leaseTTLFlag),
+			viper.GetString(leasesFlag),
+
 			func(mac string, conn *websocket.Conn) error {
 				if viper.GetBool(verboseFlag) {
 					log.Println("Handling introduction for MAC", mac)
@@ -123,11 +143,15 @@ var signalCmd = &cobra.Command{
 			},
 		)
 
+		if err := communities.Open(); err != nil {
+			return err
+		}
+
 		signaler := signaling.NewSignalingServer(
 			ctx,
 			sleep,
 
-			func(community, mac string, conn *websocket.Conn) error {
+			func(community, mac string, conn *websocket.Conn) (string, error) {
 				if viper.GetBool(verboseFlag) {
 					log.Println("Handling application for community", community, "and MAC", mac)
 				}
@@ -144,22 +168,26 @@ var signalCmd = &cobra.Command{
 
 				return wsjson.Write(ctx, conn, api.NewRejection())
 			},
-			func(community, mac string, conn *websocket.Conn) error {
+			func(community, mac, address string, conn *websocket.Conn) error {
 				if viper.GetBool(verboseFlag) {
 					log.Println("Handling acceptance for community", community, "and MAC", mac)
 				}
 
+				if address != "" {
+					log.Println("Leased address", address, "to MAC", mac, "in community", community)
+				}
+
 				ctx, cancel := context.WithTimeout(ctx, sleep)
 				defer cancel()
 
-				return wsjson.Write(ctx, conn, api.NewAcceptance())
+				return wsjson.Write(ctx, conn, api.NewAcceptance(address))
 			},
-			func(community, mac string, err error) error {
+			func(community, mac string, conn *websocket.Conn, err error) error {
 				if viper.GetBool(verboseFlag) {
 					log.Println("Handling exited for community", community, "and MAC", mac)
 				}
 
-				return communities.HandleExited(community, mac, err)
+				return communities.HandleExited(community, mac, conn, err)
 			},
 			func(community, mac string) error {
 				if viper.GetBool(verboseFlag) {
@@ -259,6 +287,25 @@ var signalCmd = &cobra.Command{
 	},
 }
 
+func getNetworks() (map[string]*net.IPNet, error) {
+	networks := map[string]*net.IPNet{}
+	for _, network := range viper.GetStringSlice(cidrFlag) {
+		parts := strings.Split(network, "=")
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, config.ErrInvalidCommunityNetwork
+		}
+
+		_, cidr, err := net.ParseCIDR(parts[1])
+		if err != nil {
+			return nil, config.ErrInvalidCommunityNetwork
+		}
+
+		networks[parts[0]] = cidr
+	}
+
+	return networks, nil
+}
+
 func init() {
 	// Get default working dir
 	home, err := os.UserHomeDir()
@@ -271,6 +318,9 @@ func init() {
 	signalCmd.PersistentFlags().BoolP(tlsFlag, "t", true, "Enable TLS")
 	signalCmd.PersistentFlags().StringP(tlsKeyFlag, "k", filepath.Join(workingDirectoryDefault, "key.pem"), "Path to the TLS private key (will be generated if it does not exist)")
 	signalCmd.PersistentFlags().StringP(tlsCertFlag, "c", filepath.Join(workingDirectoryDefault, "cert.crt"), "Path to the TLS certificate (will be generated if it does not exist)")
+	signalCmd.PersistentFlags().StringSlice(cidrFlag, []string{}, "Comma-seperated list of communities and the networks to lease addresses to their nodes from (i.e. mycommunity=10.0.0.0/24,othercommunity=fd00::/64)")
+	signalCmd.PersistentFlags().Duration(leaseTTLFlag, time.Hour*24, "Time after which the address leased to a node which has disconnected can be leased to another node")
+	signalCmd.PersistentFlags().String(leasesFlag, filepath.Join(workingDirectoryDefault, "leases.json"), "Path to the file to persist leases in (an empty path disables persistence)")
 
 	viper.AutomaticEnv()
 
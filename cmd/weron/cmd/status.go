@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/pojntfx/weron/pkg/config"
+	"github.com/pojntfx/weron/pkg/control"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	jsonFlag = "json"
+)
+
+var statusCmd = &cobra.Command{
+	Use:     "status",
+	Aliases: []string{"sta"},
+	Short:   "Show the status of a running agent",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return viper.BindPFlags(cmd.PersistentFlags())
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if viper.GetString(controlSocketFlag) == "" {
+			return config.ErrMissingControlSocket
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), viper.GetDuration(timeoutFlag))
+		defer cancel()
+
+		statuses, err := control.NewControlClient(viper.GetString(controlSocketFlag)).Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		if viper.GetBool(jsonFlag) {
+			return printJSON(statuses)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+		for i, status := range statuses {
+			// Separate the communities of agents which have joined more than one
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+
+			printStatus(w, status)
+		}
+
+		return w.Flush()
+	},
+}
+
+func printStatus(w io.Writer, status control.Status) {
+	fmt.Fprintf(w, "MAC:\t%v\n", status.MAC)
+	fmt.Fprintf(w, "Device:\t%v\n", status.DeviceName)
+	fmt.Fprintf(w, "Community:\t%v\n", status.Community)
+
+	signaler := "disconnected"
+	if status.SignalerConnected {
+		signaler = "connected"
+	}
+	fmt.Fprintf(w, "Signaler:\t%v (%v)\n", status.Signaler, signaler)
+
+	if len(status.Addresses) > 0 {
+		fmt.Fprintln(w, "Addresses:")
+		for _, address := range status.Addresses {
+			fmt.Fprintf(w, "  %v\n", address)
+		}
+	}
+
+	if len(status.Routes) > 0 {
+		addresses := []string{}
+		for address := range status.Routes {
+			addresses = append(addresses, address)
+		}
+		sort.Strings(addresses)
+
+		fmt.Fprintln(w, "Routes:")
+		for _, address := range addresses {
+			fmt.Fprintf(w, "  %v\tvia %v\n", address, status.Routes[address])
+		}
+	}
+}
+
+func printJSON(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(v)
+}
+
+func init() {
+	statusCmd.PersistentFlags().String(controlSocketFlag, "", "Path to the control socket of the agent to query (the agent's --control-socket)")
+	statusCmd.PersistentFlags().Bool(jsonFlag, false, "Print the status as JSON")
+
+	viper.AutomaticEnv()
+
+	rootCmd.AddCommand(statusCmd)
+}
@@ -0,0 +1,83 @@
+package adapter
+
+import (
+	"io"
+	"net"
+
+	"github.com/songgao/water"
+)
+
+type Adapter interface {
+	Open() (string, error)
+	Read(p []byte) (n int, err error)
+	Write(p []byte) (n int, err error)
+	Close() error
+	GetFrameSize() (int, error)
+	GetMACAddress() (net.HardwareAddr, error)
+}
+
+var (
+	_ Adapter = (*TAP)(nil)
+	_ Adapter = (*TUN)(nil)
+	_ Adapter = (*Netstack)(nil)
+	_ Adapter = (*VLAN)(nil)
+)
+
+func getInterface(netNS string, name string) (*net.Interface, error) {
+	var iface *net.Interface
+	if err := inNetNS(netNS, func() error {
+		var err error
+		iface, err = net.InterfaceByName(name)
+
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	return iface, nil
+}
+
+// openQueues opens one file descriptor for each of the device's queues, which can be read from and written to in parallel
+func openQueues(deviceType water.DeviceType, name string, queues int) ([]io.ReadWriteCloser, string, error) {
+	if queues < 1 {
+		queues = 1
+	}
+
+	interfaces := []io.ReadWriteCloser{}
+	closeQueues := func() {
+		for _, iface := range interfaces {
+			_ = iface.Close()
+		}
+	}
+
+	for i := 0; i < queues; i++ {
+		c, err := addQueueParameters(
+			addPlatformParameters(
+				water.Config{
+					DeviceType: deviceType,
+				},
+				name,
+			),
+			queues,
+		)
+		if err != nil {
+			closeQueues()
+
+			return nil, "", err
+		}
+
+		iface, err := water.New(c)
+		if err != nil {
+			closeQueues()
+
+			return nil, "", err
+		}
+
+		interfaces = append(interfaces, iface)
+
+		// The other queues are attached to the device created by the first one
+		name = iface.Name()
+	}
+
+	return interfaces, name, nil
+}
@@ -0,0 +1,63 @@
+package adapter
+
+import (
+	"net"
+	"strings"
+
+	"github.com/pojntfx/weron/pkg/config"
+)
+
+type LinkConfig struct {
+	Addresses []string // In CIDR notation, i.e. 10.0.0.1/24
+	Routes    []string // In CIDR notation with an optional gateway, i.e. 10.1.0.0/16 or 10.1.0.0/16=10.0.0.2
+	MTU       int      // 0 keeps the device's MTU
+	Bridge    string   // Bridge to attach the device to, which is created if it doesn't exist; addresses and routes are configured on the bridge
+}
+
+func (c LinkConfig) IsEmpty() bool {
+	return len(c.Addresses) == 0 && len(c.Routes) == 0 && c.MTU == 0 && c.Bridge == ""
+}
+
+func (c LinkConfig) Validate() error {
+	for _, address := range c.Addresses {
+		if _, _, err := net.ParseCIDR(address); err != nil {
+			return config.ErrInvalidAddress
+		}
+	}
+
+	for _, route := range c.Routes {
+		if _, _, err := parseRoute(route); err != nil {
+			return err
+		}
+	}
+
+	if c.MTU < 0 {
+		return config.ErrInvalidMTU
+	}
+
+	return nil
+}
+
+func parseRoute(route string) (*net.IPNet, net.IP, error) {
+	parts := strings.Split(route, "=")
+	if len(parts) > 2 {
+		return nil, nil, config.ErrInvalidRoute
+	}
+
+	_, destination, err := net.ParseCIDR(parts[0])
+	if err != nil {
+		return nil, nil, config.ErrInvalidRoute
+	}
+
+	// Routes without a gateway are on-link
+	if len(parts) == 1 {
+		return destination, nil, nil
+	}
+
+	gateway := net.ParseIP(parts[1])
+	if gateway == nil {
+		return nil, nil, config.ErrInvalidRoute
+	}
+
+	return destination, gateway, nil
+}
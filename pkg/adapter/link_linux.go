@@ -0,0 +1,165 @@
+//go:build linux
+// +build linux
+
+package adapter
+
+import (
+	"errors"
+	"syscall"
+
+	"github.com/pojntfx/weron/pkg/config"
+	"github.com/vishvananda/netlink"
+)
+
+// ConfigureLink configures the device in the network namespace (an empty namespace uses the current one)
+func ConfigureLink(name string, netNS string, c LinkConfig) error {
+	return inNetNS(netNS, func() error {
+		return configureLink(name, c)
+	})
+}
+
+func UnconfigureLink(name string, netNS string, c LinkConfig) error {
+	return inNetNS(netNS, func() error {
+		return unconfigureLink(name, c)
+	})
+}
+
+func configureLink(name string, c LinkConfig) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return err
+	}
+
+	if c.MTU > 0 {
+		if err := netlink.LinkSetMTU(link, c.MTU); err != nil {
+			return err
+		}
+	}
+
+	if c.Bridge != "" {
+		bridge, err := getOrCreateBridge(c.Bridge)
+		if err != nil {
+			return err
+		}
+
+		if err := netlink.LinkSetMaster(link, bridge); err != nil {
+			return err
+		}
+
+		if err := netlink.LinkSetUp(link); err != nil {
+			return err
+		}
+
+		// Addresses and routes of bridged devices are configured on the bridge
+		link = bridge
+	}
+
+	// Replace instead of adding so that configuring a link which is already configured, i.e. after a restart, doesn't fail
+	for _, address := range c.Addresses {
+		addr, err := netlink.ParseAddr(address)
+		if err != nil {
+			return err
+		}
+
+		if err := netlink.AddrReplace(link, addr); err != nil {
+			return err
+		}
+	}
+
+	// Routes can only be added once the link is up
+	if err := netlink.LinkSetUp(link); err != nil {
+		return err
+	}
+
+	for _, route := range c.Routes {
+		destination, gateway, err := parseRoute(route)
+		if err != nil {
+			return err
+		}
+
+		if err := netlink.RouteReplace(&netlink.Route{
+			LinkIndex: link.Attrs().Index,
+			Dst:       destination,
+			Gw:        gateway,
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func unconfigureLink(name string, c LinkConfig) error {
+	// The bridge is kept as other devices might be attached to it
+	if c.Bridge != "" {
+		name = c.Bridge
+	}
+
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		// The link might have been removed already
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			return nil
+		}
+
+		return err
+	}
+
+	for _, route := range c.Routes {
+		destination, gateway, err := parseRoute(route)
+		if err != nil {
+			return err
+		}
+
+		if err := netlink.RouteDel(&netlink.Route{
+			LinkIndex: link.Attrs().Index,
+			Dst:       destination,
+			Gw:        gateway,
+		}); err != nil && !errors.Is(err, syscall.ESRCH) {
+			return err
+		}
+	}
+
+	for _, address := range c.Addresses {
+		addr, err := netlink.ParseAddr(address)
+		if err != nil {
+			return err
+		}
+
+		if err := netlink.AddrDel(link, addr); err != nil && !errors.Is(err, syscall.EADDRNOTAVAIL) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func getOrCreateBridge(name string) (netlink.Link, error) {
+	bridge, err := netlink.LinkByName(name)
+	if err == nil {
+		if bridge.Type() != "bridge" {
+			return nil, config.ErrNotABridge
+		}
+
+		return bridge, netlink.LinkSetUp(bridge)
+	}
+
+	if _, ok := err.(netlink.LinkNotFoundError); !ok {
+		return nil, err
+	}
+
+	attrs := netlink.NewLinkAttrs()
+	attrs.Name = name
+
+	// Another agent might have created the bridge in the meantime
+	if err := netlink.LinkAdd(&netlink.Bridge{LinkAttrs: attrs}); err != nil && !errors.Is(err, syscall.EEXIST) {
+		return nil, err
+	}
+
+	bridge, err = netlink.LinkByName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return bridge, netlink.LinkSetUp(bridge)
+}
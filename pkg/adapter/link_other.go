@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package adapter
+
+import "github.com/pojntfx/weron/pkg/config"
+
+func ConfigureLink(name string, netNS string, c LinkConfig) error {
+	if c.IsEmpty() {
+		return nil // No-op
+	}
+
+	return config.ErrUnsupportedPlatform
+}
+
+func UnconfigureLink(name string, netNS string, c LinkConfig) error {
+	return nil // No-op
+}
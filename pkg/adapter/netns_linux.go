@@ -0,0 +1,60 @@
+//go:build linux
+// +build linux
+
+package adapter
+
+import (
+	"runtime"
+	"strings"
+
+	"github.com/vishvananda/netns"
+)
+
+// inNetNS runs f in the network namespace, which is either a path (i.e. /var/run/netns/tenant1) or a name (i.e. tenant1); an empty namespace runs f in the current namespace
+func inNetNS(netNS string, f func() error) error {
+	if netNS == "" {
+		return f()
+	}
+
+	var (
+		target netns.NsHandle
+		err    error
+	)
+	if strings.Contains(netNS, "/") {
+		target, err = netns.GetFromPath(netNS)
+	} else {
+		target, err = netns.GetFromName(netNS)
+	}
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+
+	// Namespaces are per thread, so the goroutine may not be moved to another thread while switching
+	runtime.LockOSThread()
+
+	current, err := netns.Get()
+	if err != nil {
+		runtime.UnlockOSThread()
+
+		return err
+	}
+	defer current.Close()
+
+	if err := netns.Set(target); err != nil {
+		runtime.UnlockOSThread()
+
+		return err
+	}
+
+	fErr := f()
+
+	// If the namespace can't be restored the thread is left locked, which makes the runtime terminate it once the goroutine exits
+	if err := netns.Set(current); err != nil {
+		return err
+	}
+
+	runtime.UnlockOSThread()
+
+	return fErr
+}
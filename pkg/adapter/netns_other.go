@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package adapter
+
+import "github.com/pojntfx/weron/pkg/config"
+
+func inNetNS(netNS string, f func() error) error {
+	if netNS != "" {
+		return config.ErrUnsupportedPlatform
+	}
+
+	return f()
+}
@@ -0,0 +1,391 @@
+package adapter
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/pojntfx/weron/pkg/config"
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/link/ethernet"
+	"gvisor.dev/gvisor/pkg/tcpip/network/arp"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/icmp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+)
+
+const (
+	netstackName     = "netstack"
+	netstackNICID    = 1
+	netstackQueueLen = 1024
+	netstackMTU      = 1500
+)
+
+type Netstack struct {
+	mtu       int
+	addresses []string
+
+	mac      net.HardwareAddr
+	stack    *stack.Stack
+	endpoint *channel.Endpoint
+
+	ctx    context.Context
+	cancel func()
+
+	lock sync.Mutex
+}
+
+func NewNetstack(mtu int, addresses []string) *Netstack {
+	if mtu <= 0 {
+		mtu = netstackMTU
+	}
+
+	return &Netstack{
+		mtu:       mtu,
+		addresses: addresses,
+	}
+}
+
+func (a *Netstack) Open() (string, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.stack != nil {
+		return "", config.ErrAlreadyOpened
+	}
+
+	protocolAddresses := []tcpip.ProtocolAddress{}
+	for _, address := range a.addresses {
+		protocolAddress, err := parseProtocolAddress(address)
+		if err != nil {
+			return "", err
+		}
+
+		protocolAddresses = append(protocolAddresses, protocolAddress)
+	}
+
+	mac, err := getRandomMACAddress()
+	if err != nil {
+		return "", err
+	}
+
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol, ipv6.NewProtocol, arp.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol, udp.NewProtocol, icmp.NewProtocol4, icmp.NewProtocol6},
+		HandleLocal:        true,
+	})
+
+	// The channel endpoint's MTU includes the Ethernet header, which the Ethernet endpoint adds and strips
+	endpoint := channel.New(netstackQueueLen, uint32(a.mtu+ethernetHeaderLength), tcpip.LinkAddress(mac))
+	if err := s.CreateNIC(netstackNICID, ethernet.New(endpoint)); err != nil {
+		s.Close()
+
+		return "", errors.New(err.String())
+	}
+
+	routes := []tcpip.Route{}
+	for _, protocolAddress := range protocolAddresses {
+		if err := s.AddProtocolAddress(netstackNICID, protocolAddress, stack.AddressProperties{}); err != nil {
+			s.Close()
+
+			return "", errors.New(err.String())
+		}
+
+		// Peers are reachable directly on the overlay, so there is no gateway
+		routes = append(routes, tcpip.Route{
+			Destination: protocolAddress.AddressWithPrefix.Subnet(),
+			NIC:         netstackNICID,
+		})
+	}
+	s.SetRouteTable(routes)
+
+	a.mac = mac
+	a.stack = s
+	a.endpoint = endpoint
+	a.ctx, a.cancel = context.WithCancel(context.Background())
+
+	return netstackName, nil
+}
+
+func (a *Netstack) Read(p []byte) (n int, err error) {
+	a.lock.Lock()
+	endpoint, ctx := a.endpoint, a.ctx
+	a.lock.Unlock()
+
+	if endpoint == nil {
+		return -1, net.ErrClosed
+	}
+
+	pkt := endpoint.ReadContext(ctx)
+	if pkt.IsNil() {
+		return -1, net.ErrClosed
+	}
+	defer pkt.DecRef()
+
+	view := pkt.ToView()
+	defer view.Release()
+
+	return copy(p, view.AsSlice()), nil
+}
+
+func (a *Netstack) Write(p []byte) (n int, err error) {
+	a.lock.Lock()
+	endpoint := a.endpoint
+	a.lock.Unlock()
+
+	if endpoint == nil {
+		return -1, net.ErrClosed
+	}
+
+	// The payload is copied, so the frame can be re-used by the caller
+	pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Payload: buffer.MakeWithData(p),
+	})
+	defer pkt.DecRef()
+
+	// The network protocol is parsed from the Ethernet header
+	endpoint.InjectInbound(0, pkt)
+
+	return len(p), nil
+}
+
+func (a *Netstack) Close() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.stack == nil {
+		return nil // No-op
+	}
+
+	a.cancel()
+	a.endpoint.Close()
+	a.stack.Close()
+
+	a.stack = nil
+	a.endpoint = nil
+
+	return nil
+}
+
+func (a *Netstack) GetFrameSize() (int, error) {
+	return a.mtu + ethernetHeaderLength, nil
+}
+
+func (a *Netstack) GetMACAddress() (net.HardwareAddr, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.stack == nil {
+		return nil, net.ErrClosed
+	}
+
+	return a.mac, nil
+}
+
+func (a *Netstack) AddAddress(address string) error {
+	s, err := a.getStack()
+	if err != nil {
+		return err
+	}
+
+	protocolAddress, err := parseProtocolAddress(address)
+	if err != nil {
+		return err
+	}
+
+	if err := s.AddProtocolAddress(netstackNICID, protocolAddress, stack.AddressProperties{}); err != nil {
+		return errors.New(err.String())
+	}
+
+	s.AddRoute(tcpip.Route{
+		Destination: protocolAddress.AddressWithPrefix.Subnet(),
+		NIC:         netstackNICID,
+	})
+
+	return nil
+}
+
+func (a *Netstack) RemoveAddress(address string) error {
+	s, err := a.getStack()
+	if err != nil {
+		return err
+	}
+
+	protocolAddress, err := parseProtocolAddress(address)
+	if err != nil {
+		return err
+	}
+
+	if err := s.RemoveAddress(netstackNICID, protocolAddress.AddressWithPrefix.Address); err != nil {
+		return errors.New(err.String())
+	}
+
+	// Keep the route if other addresses are in the same subnet
+	subnet := protocolAddress.AddressWithPrefix.Subnet()
+	for _, other := range s.AllAddresses()[netstackNICID] {
+		if subnet.Contains(other.AddressWithPrefix.Address) {
+			return nil
+		}
+	}
+
+	s.RemoveRoutes(func(r tcpip.Route) bool {
+		return r.NIC == netstackNICID && r.Destination == subnet
+	})
+
+	return nil
+}
+
+func (a *Netstack) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	s, err := a.getStack()
+	if err != nil {
+		return nil, err
+	}
+
+	fullAddress, protocol, err := parseFullAddress(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		return gonet.DialContextTCP(ctx, s, fullAddress, protocol)
+	case "udp", "udp4", "udp6":
+		return gonet.DialUDP(s, nil, &fullAddress, protocol)
+	}
+
+	return nil, config.ErrUnsupportedNetwork
+}
+
+func (a *Netstack) Listen(network, address string) (net.Listener, error) {
+	s, err := a.getStack()
+	if err != nil {
+		return nil, err
+	}
+
+	fullAddress, protocol, err := parseFullAddress(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		return gonet.ListenTCP(s, fullAddress, protocol)
+	}
+
+	return nil, config.ErrUnsupportedNetwork
+}
+
+func (a *Netstack) ListenPacket(network, address string) (net.PacketConn, error) {
+	s, err := a.getStack()
+	if err != nil {
+		return nil, err
+	}
+
+	fullAddress, protocol, err := parseFullAddress(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	switch network {
+	case "udp", "udp4", "udp6":
+		return gonet.DialUDP(s, &fullAddress, nil, protocol)
+	}
+
+	return nil, config.ErrUnsupportedNetwork
+}
+
+func (a *Netstack) getStack() (*stack.Stack, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.stack == nil {
+		return nil, net.ErrClosed
+	}
+
+	return a.stack, nil
+}
+
+func parseProtocolAddress(address string) (tcpip.ProtocolAddress, error) {
+	ip, subnet, err := net.ParseCIDR(address)
+	if err != nil {
+		return tcpip.ProtocolAddress{}, config.ErrInvalidAddress
+	}
+
+	prefixLen, _ := subnet.Mask.Size()
+
+	protocol := ipv6.ProtocolNumber
+	if v4 := ip.To4(); v4 != nil {
+		protocol = ipv4.ProtocolNumber
+		ip = v4
+	}
+
+	return tcpip.ProtocolAddress{
+		Protocol: protocol,
+		AddressWithPrefix: tcpip.AddressWithPrefix{
+			Address:   tcpip.AddrFromSlice(ip),
+			PrefixLen: prefixLen,
+		},
+	}, nil
+}
+
+func parseFullAddress(network, address string) (tcpip.FullAddress, tcpip.NetworkProtocolNumber, error) {
+	host, rawPort, err := net.SplitHostPort(address)
+	if err != nil {
+		return tcpip.FullAddress{}, 0, err
+	}
+
+	port, err := strconv.ParseUint(rawPort, 10, 16)
+	if err != nil {
+		return tcpip.FullAddress{}, 0, config.ErrInvalidAddress
+	}
+
+	fullAddress := tcpip.FullAddress{
+		NIC:  netstackNICID,
+		Port: uint16(port),
+	}
+
+	// There is no resolver on the overlay, so only IP addresses are supported
+	if host == "" {
+		if network == "tcp6" || network == "udp6" {
+			return fullAddress, ipv6.ProtocolNumber, nil
+		}
+
+		return fullAddress, ipv4.ProtocolNumber, nil
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return tcpip.FullAddress{}, 0, config.ErrInvalidAddress
+	}
+
+	if v4 := ip.To4(); v4 != nil {
+		fullAddress.Addr = tcpip.AddrFromSlice(v4)
+
+		return fullAddress, ipv4.ProtocolNumber, nil
+	}
+
+	fullAddress.Addr = tcpip.AddrFromSlice(ip)
+
+	return fullAddress, ipv6.ProtocolNumber, nil
+}
+
+func getRandomMACAddress() (net.HardwareAddr, error) {
+	mac := make(net.HardwareAddr, 6)
+	if _, err := rand.Read(mac); err != nil {
+		return nil, err
+	}
+
+	// Locally administered unicast address, like the ones the kernel assigns to TAP devices
+	mac[0] = (mac[0] | 0x02) &^ 0x01
+
+	return mac, nil
+}
@@ -0,0 +1,73 @@
+package adapter
+
+import (
+	"encoding/binary"
+
+	"github.com/pojntfx/weron/pkg/offload"
+)
+
+const (
+	MaxOffloadFrameSize = 65535 + ethernetHeaderLength + vlanTagLength // Largest unsegmented TCP segment the device can hand over
+
+	virtioNetHeaderLength = 10
+
+	virtioNetFlagNeedsChecksum = 1
+
+	virtioNetGSONone  = 0
+	virtioNetGSOTCPv4 = 1
+	virtioNetGSOTCPv6 = 4
+)
+
+// completeChecksum calculates the checksum which the device has left to us; unsegmented TCP segments which are larger
+// than the frame size are segmented later on, which calculates their checksums
+func completeChecksum(header []byte, frame []byte, frameSize int) {
+	if header[0]&virtioNetFlagNeedsChecksum == 0 || (header[1] != virtioNetGSONone && len(frame) > frameSize) {
+		return
+	}
+
+	start := int(binary.LittleEndian.Uint16(header[6:8]))
+	offset := start + int(binary.LittleEndian.Uint16(header[8:10]))
+	if offset+2 > len(frame) {
+		return
+	}
+
+	// The checksum field already contains the sum of the pseudo header
+	binary.BigEndian.PutUint16(frame[offset:], ^offload.FoldChecksum(offload.SumChecksum(0, frame[start:])))
+}
+
+// getSegmentationHeader tells the device to segment TCP segments which are larger than the frame size, which is how
+// peers which support offload send them
+func getSegmentationHeader(header []byte, frame []byte, frameSize int) {
+	for i := range header {
+		header[i] = 0
+	}
+
+	if len(frame) <= frameSize {
+		return
+	}
+
+	network, transport, ok := offload.GetTCPOffsets(frame)
+	if !ok {
+		return
+	}
+
+	headerLength := transport + int(frame[transport+12]>>4)*4
+	if len(frame) < headerLength || frameSize <= headerLength {
+		return
+	}
+
+	gsoType := byte(virtioNetGSOTCPv4)
+	if frame[network]>>4 == 6 {
+		gsoType = virtioNetGSOTCPv6
+	}
+
+	header[0] = virtioNetFlagNeedsChecksum
+	header[1] = gsoType
+	binary.LittleEndian.PutUint16(header[2:4], uint16(headerLength))
+	binary.LittleEndian.PutUint16(header[4:6], uint16(frameSize-headerLength))
+	binary.LittleEndian.PutUint16(header[6:8], uint16(transport))
+	binary.LittleEndian.PutUint16(header[8:10], 16)
+
+	// The device expects the checksum field to contain the sum of the pseudo header, which it completes for each segment
+	binary.BigEndian.PutUint16(frame[transport+16:], offload.FoldChecksum(offload.GetPseudoHeaderSum(frame[network:], len(frame)-transport)))
+}
@@ -0,0 +1,158 @@
+//go:build linux
+// +build linux
+
+package adapter
+
+import (
+	"io"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+type offloadQueue struct {
+	file         *os.File
+	conn         syscall.RawConn
+	getFrameSize func() int
+}
+
+// openOffloadQueues opens a TAP device which hands over TCP segments before they are segmented and
+// accepts them in return (IFF_VNET_HDR), which saves segmenting them in the kernel for every hop
+func openOffloadQueues(name string, queues int, getFrameSize func() int) ([]io.ReadWriteCloser, string, error) {
+	if queues < 1 {
+		queues = 1
+	}
+
+	files := []io.ReadWriteCloser{}
+	closeQueues := func() {
+		for _, file := range files {
+			_ = file.Close()
+		}
+	}
+
+	flags := uint16(unix.IFF_TAP | unix.IFF_NO_PI | unix.IFF_VNET_HDR)
+	if queues > 1 {
+		flags |= unix.IFF_MULTI_QUEUE
+	}
+
+	for i := 0; i < queues; i++ {
+		q, deviceName, err := openOffloadQueue(name, flags)
+		if err != nil {
+			closeQueues()
+
+			return nil, "", err
+		}
+
+		q.getFrameSize = getFrameSize
+
+		files = append(files, q)
+
+		// The other queues are attached to the device created by the first one
+		name = deviceName
+	}
+
+	return files, name, nil
+}
+
+func openOffloadQueue(name string, flags uint16) (*offloadQueue, string, error) {
+	fd, err := unix.Open("/dev/net/tun", unix.O_RDWR|unix.O_CLOEXEC|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ifr, err := unix.NewIfreq(name)
+	if err != nil {
+		_ = unix.Close(fd)
+
+		return nil, "", err
+	}
+	ifr.SetUint16(flags)
+
+	if err := unix.IoctlIfreq(fd, unix.TUNSETIFF, ifr); err != nil {
+		_ = unix.Close(fd)
+
+		return nil, "", err
+	}
+
+	// The header uses the host's byte order by default
+	if err := unix.IoctlSetPointerInt(fd, unix.TUNSETVNETLE, 1); err != nil {
+		_ = unix.Close(fd)
+
+		return nil, "", err
+	}
+
+	if err := unix.IoctlSetInt(fd, unix.TUNSETOFFLOAD, unix.TUN_F_CSUM|unix.TUN_F_TSO4|unix.TUN_F_TSO6); err != nil {
+		_ = unix.Close(fd)
+
+		return nil, "", err
+	}
+
+	// The file is non-blocking, so reads are interrupted once it is closed
+	file := os.NewFile(uintptr(fd), "/dev/net/tun")
+
+	conn, err := file.SyscallConn()
+	if err != nil {
+		_ = file.Close()
+
+		return nil, "", err
+	}
+
+	return &offloadQueue{
+		file: file,
+		conn: conn,
+	}, ifr.Name(), nil
+}
+
+func (q *offloadQueue) Read(p []byte) (int, error) {
+	header := [virtioNetHeaderLength]byte{}
+
+	var n int
+	var err error
+	if rerr := q.conn.Read(func(fd uintptr) bool {
+		n, err = unix.Readv(int(fd), [][]byte{header[:], p})
+
+		return err != unix.EAGAIN
+	}); rerr != nil {
+		return -1, rerr
+	}
+
+	if err != nil {
+		return -1, err
+	}
+
+	if n < virtioNetHeaderLength {
+		return -1, io.ErrUnexpectedEOF
+	}
+
+	n -= virtioNetHeaderLength
+
+	completeChecksum(header[:], p[:n], q.getFrameSize())
+
+	return n, nil
+}
+
+func (q *offloadQueue) Write(p []byte) (int, error) {
+	header := [virtioNetHeaderLength]byte{}
+	getSegmentationHeader(header[:], p, q.getFrameSize())
+
+	var n int
+	var err error
+	if werr := q.conn.Write(func(fd uintptr) bool {
+		n, err = unix.Writev(int(fd), [][]byte{header[:], p})
+
+		return err != unix.EAGAIN
+	}); werr != nil {
+		return -1, werr
+	}
+
+	if err != nil {
+		return -1, err
+	}
+
+	return n - virtioNetHeaderLength, nil
+}
+
+func (q *offloadQueue) Close() error {
+	return q.file.Close()
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package adapter
+
+import (
+	"io"
+
+	"github.com/pojntfx/weron/pkg/config"
+)
+
+func openOffloadQueues(name string, queues int, getFrameSize func() int) ([]io.ReadWriteCloser, string, error) {
+	return nil, "", config.ErrUnsupportedPlatform
+}
@@ -0,0 +1,236 @@
+package adapter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/mdlayher/ethernet"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/checksum"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+const testFrameSize = 1514
+
+var (
+	testIPv4Source      = tcpip.AddrFrom4Slice(net.ParseIP("10.0.0.1").To4())
+	testIPv4Destination = tcpip.AddrFrom4Slice(net.ParseIP("10.0.0.2").To4())
+	testIPv6Source      = tcpip.AddrFrom16Slice(net.ParseIP("fd00::1"))
+	testIPv6Destination = tcpip.AddrFrom16Slice(net.ParseIP("fd00::2"))
+)
+
+// newTestTCPFrame creates a TCP segment with valid checksums, which are calculated by gVisor instead of this package
+func newTestTCPFrame(ipv6, tagged bool, payloadLength int) []byte {
+	payload := make([]byte, payloadLength)
+	for i := range payload {
+		payload[i] = byte(i % 251)
+	}
+
+	tcp := header.TCP(make([]byte, header.TCPMinimumSize))
+	tcp.Encode(&header.TCPFields{
+		SrcPort:    1234,
+		DstPort:    80,
+		SeqNum:     1000,
+		AckNum:     2000,
+		DataOffset: header.TCPMinimumSize,
+		Flags:      header.TCPFlagAck | header.TCPFlagPsh,
+		WindowSize: 65535,
+	})
+
+	var ip []byte
+	src, dst := testIPv4Source, testIPv4Destination
+	etherType := ethernet.EtherTypeIPv4
+	if ipv6 {
+		src, dst = testIPv6Source, testIPv6Destination
+		etherType = ethernet.EtherTypeIPv6
+
+		ipv6 := header.IPv6(make([]byte, header.IPv6MinimumSize))
+		ipv6.Encode(&header.IPv6Fields{
+			PayloadLength:     uint16(len(tcp) + len(payload)),
+			TransportProtocol: header.TCPProtocolNumber,
+			HopLimit:          64,
+			SrcAddr:           src,
+			DstAddr:           dst,
+		})
+
+		ip = ipv6
+	} else {
+		ipv4 := header.IPv4(make([]byte, header.IPv4MinimumSize))
+		ipv4.Encode(&header.IPv4Fields{
+			TotalLength: uint16(len(ipv4) + len(tcp) + len(payload)),
+			ID:          0x1234,
+			TTL:         64,
+			Protocol:    uint8(header.TCPProtocolNumber),
+			SrcAddr:     src,
+			DstAddr:     dst,
+		})
+		ipv4.SetChecksum(^ipv4.CalculateChecksum())
+
+		ip = ipv4
+	}
+
+	tcp.SetChecksum(^tcp.CalculateChecksum(checksum.Checksum(payload, header.PseudoHeaderChecksum(header.TCPProtocolNumber, src, dst, uint16(len(tcp)+len(payload))))))
+
+	frame := append([]byte{}, testDestination...)
+	frame = append(frame, testSource...)
+	if tagged {
+		frame = binary.BigEndian.AppendUint16(frame, uint16(ethernet.EtherTypeVLAN))
+		frame = binary.BigEndian.AppendUint16(frame, 10)
+	}
+	frame = binary.BigEndian.AppendUint16(frame, uint16(etherType))
+
+	frame = append(frame, ip...)
+	frame = append(frame, tcp...)
+
+	return append(frame, payload...)
+}
+
+// newTestVirtioNetHeader creates the header which the device prepends to frames
+func newTestVirtioNetHeader(flags, gsoType byte, checksumStart, checksumOffset int) []byte {
+	virtioNetHeader := make([]byte, virtioNetHeaderLength)
+	virtioNetHeader[0] = flags
+	virtioNetHeader[1] = gsoType
+	binary.LittleEndian.PutUint16(virtioNetHeader[6:8], uint16(checksumStart))
+	binary.LittleEndian.PutUint16(virtioNetHeader[8:10], uint16(checksumOffset))
+
+	return virtioNetHeader
+}
+
+// withPseudoHeaderSum returns a copy of the frame with the TCP checksum replaced by the sum of the pseudo header, like
+// the device hands it over if the checksum is left to us
+func withPseudoHeaderSum(frame []byte, ipv6 bool, transport int) []byte {
+	src, dst := testIPv4Source, testIPv4Destination
+	if ipv6 {
+		src, dst = testIPv6Source, testIPv6Destination
+	}
+
+	frame = append([]byte{}, frame...)
+	header.TCP(frame[transport:]).SetChecksum(header.PseudoHeaderChecksum(header.TCPProtocolNumber, src, dst, uint16(len(frame)-transport)))
+
+	return frame
+}
+
+func TestCompleteChecksum(t *testing.T) {
+	for _, test := range []struct {
+		name          string
+		ipv6          bool
+		tagged        bool
+		payloadLength int
+		gsoType       byte
+	}{
+		{"ipv4", false, false, 1000, virtioNetGSONone},
+		{"ipv6", true, false, 1000, virtioNetGSONone},
+		{"tagged ipv4", false, true, 1000, virtioNetGSONone},
+		{"tagged ipv6", true, true, 1000, virtioNetGSONone},
+		{"odd length", false, false, 1001, virtioNetGSONone},
+		{"without payload", true, false, 0, virtioNetGSONone},
+		{"segmentation which isn't needed", false, false, 1000, virtioNetGSOTCPv4}, // The frame fits, so it isn't segmented later on
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			wantFrame := newTestTCPFrame(test.ipv6, test.tagged, test.payloadLength)
+
+			transport := 14 + header.IPv4MinimumSize
+			if test.ipv6 {
+				transport = 14 + header.IPv6MinimumSize
+			}
+			if test.tagged {
+				transport += 4
+			}
+
+			frame := withPseudoHeaderSum(wantFrame, test.ipv6, transport)
+
+			completeChecksum(newTestVirtioNetHeader(virtioNetFlagNeedsChecksum, test.gsoType, transport, header.TCPChecksumOffset), frame, testFrameSize)
+
+			if got, want := header.TCP(frame[transport:]).Checksum(), header.TCP(wantFrame[transport:]).Checksum(); got != want {
+				t.Fatalf("completed checksum is %#04x, want %#04x", got, want)
+			}
+
+			if !bytes.Equal(frame, wantFrame) {
+				t.Fatal("completing the checksum modified more than the checksum")
+			}
+		})
+	}
+}
+
+func TestCompleteChecksumSkipsFrames(t *testing.T) {
+	for _, test := range []struct {
+		name           string
+		payloadLength  int
+		flags          byte
+		gsoType        byte
+		checksumOffset int
+	}{
+		{"checksum is complete", 1000, 0, virtioNetGSONone, header.TCPChecksumOffset},
+		{"segmented later on", 4000, virtioNetFlagNeedsChecksum, virtioNetGSOTCPv4, header.TCPChecksumOffset},
+		{"offset outside of the frame", 1000, virtioNetFlagNeedsChecksum, virtioNetGSONone, 1000 + header.TCPMinimumSize - 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			transport := 14 + header.IPv4MinimumSize
+			frame := withPseudoHeaderSum(newTestTCPFrame(false, false, test.payloadLength), false, transport)
+			want := append([]byte{}, frame...)
+
+			completeChecksum(newTestVirtioNetHeader(test.flags, test.gsoType, transport, test.checksumOffset), frame, testFrameSize)
+
+			if !bytes.Equal(frame, want) {
+				t.Fatal("frame was modified")
+			}
+		})
+	}
+}
+
+func TestGetSegmentationHeader(t *testing.T) {
+	udp := newTestTCPFrame(false, false, 4000)
+	udp[14+9] = uint8(header.UDPProtocolNumber)
+
+	for _, test := range []struct {
+		name              string
+		frame             []byte
+		ipv6              bool
+		wantGSOType       byte
+		wantHeaderLength  int
+		wantChecksumStart int
+	}{
+		{"ipv4", newTestTCPFrame(false, false, 4000), false, virtioNetGSOTCPv4, 54, 34},
+		{"ipv6", newTestTCPFrame(true, false, 4000), true, virtioNetGSOTCPv6, 74, 54},
+		{"tagged ipv4", newTestTCPFrame(false, true, 4000), false, virtioNetGSOTCPv4, 58, 38},
+		{"tagged ipv6", newTestTCPFrame(true, true, 4000), true, virtioNetGSOTCPv6, 78, 58},
+		{"odd length", newTestTCPFrame(false, false, 4001), false, virtioNetGSOTCPv4, 54, 34},
+		{"fits into a frame", newTestTCPFrame(false, false, testFrameSize-54), false, virtioNetGSONone, 0, 0},
+		{"udp", udp, false, virtioNetGSONone, 0, 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			frame := append([]byte{}, test.frame...)
+
+			// The header is reused for every frame
+			virtioNetHeader := bytes.Repeat([]byte{0xff}, virtioNetHeaderLength)
+			getSegmentationHeader(virtioNetHeader, frame, testFrameSize)
+
+			if test.wantGSOType == virtioNetGSONone {
+				if !bytes.Equal(virtioNetHeader, make([]byte, virtioNetHeaderLength)) {
+					t.Fatalf("got header %x for a frame which isn't segmented, want an empty header", virtioNetHeader)
+				}
+
+				if !bytes.Equal(frame, test.frame) {
+					t.Fatal("frame which isn't segmented was modified")
+				}
+
+				return
+			}
+
+			want := newTestVirtioNetHeader(virtioNetFlagNeedsChecksum, test.wantGSOType, test.wantChecksumStart, header.TCPChecksumOffset)
+			binary.LittleEndian.PutUint16(want[2:4], uint16(test.wantHeaderLength))
+			binary.LittleEndian.PutUint16(want[4:6], uint16(testFrameSize-test.wantHeaderLength))
+
+			if !bytes.Equal(virtioNetHeader, want) {
+				t.Fatalf("got header %x, want %x", virtioNetHeader, want)
+			}
+
+			// The device completes the checksum for each segment from the sum of the pseudo header
+			if wantFrame := withPseudoHeaderSum(test.frame, test.ipv6, test.wantChecksumStart); !bytes.Equal(frame, wantFrame) {
+				t.Fatalf("got checksum field %#04x, want the pseudo header sum %#04x", header.TCP(frame[test.wantChecksumStart:]).Checksum(), header.TCP(wantFrame[test.wantChecksumStart:]).Checksum())
+			}
+		})
+	}
+}
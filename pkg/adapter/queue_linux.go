@@ -0,0 +1,12 @@
+//go:build linux
+// +build linux
+
+package adapter
+
+import "github.com/songgao/water"
+
+func addQueueParameters(config water.Config, queues int) (water.Config, error) {
+	config.PlatformSpecificParams.MultiQueue = queues > 1
+
+	return config, nil
+}
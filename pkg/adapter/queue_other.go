@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package adapter
+
+import (
+	"github.com/pojntfx/weron/pkg/config"
+	"github.com/songgao/water"
+)
+
+func addQueueParameters(c water.Config, queues int) (water.Config, error) {
+	if queues > 1 {
+		return c, config.ErrUnsupportedPlatform
+	}
+
+	return c, nil
+}
@@ -3,6 +3,8 @@ package adapter
 import (
 	"io"
 	"net"
+	"sync"
+	"sync/atomic"
 
 	"github.com/pojntfx/weron/pkg/config"
 	"github.com/songgao/water"
@@ -15,91 +17,149 @@ const (
 type TAP struct {
 	io.Writer
 
-	name string
+	frameSize int64 // Accessed atomically, so it must stay 64-bit aligned on 32-bit platforms
 
-	tap *water.Interface
+	name    string
+	netNS   string
+	queues  int
+	offload bool
+
+	deviceName string
+	taps       []io.ReadWriteCloser
+
+	lock sync.Mutex
 }
 
-func NewTAP(name string) *TAP {
+func NewTAP(name string, netNS string, queues int, offload bool) *TAP {
 	return &TAP{
-		name: name,
+		name:    name,
+		netNS:   netNS,
+		queues:  queues,
+		offload: offload,
 	}
 }
 
 func (a *TAP) Open() (string, error) {
-	if a.tap != nil {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.taps != nil {
 		return "", config.ErrAlreadyOpened
 	}
 
-	tap, err := water.New(
-		addPlatformParameters(
-			water.Config{
-				DeviceType: water.TAP,
-			},
-			a.name,
-		))
-	if err != nil {
+	// The device stays in the namespace it has been created in, but can be read from and written to from any namespace
+	if err := inNetNS(a.netNS, func() error {
+		var taps []io.ReadWriteCloser
+		var deviceName string
+		var err error
+		if a.offload {
+			taps, deviceName, err = openOffloadQueues(a.name, a.queues, a.getCachedFrameSize)
+		} else {
+			taps, deviceName, err = openQueues(water.TAP, a.name, a.queues)
+		}
+		if err != nil {
+			return err
+		}
+
+		a.taps = taps
+		a.deviceName = deviceName
+
+		return refreshMACAddress(a.deviceName)
+	}); err != nil {
 		return "", err
 	}
 
-	a.tap = tap
-
-	if err := refreshMACAddress(a.tap.Name()); err != nil {
-		return "", err
-	}
-
-	return a.tap.Name(), nil
+	return a.deviceName, nil
 }
 
 func (a *TAP) Read(p []byte) (n int, err error) {
-	if a.tap == nil {
+	return a.ReadQueue(0, p)
+}
+
+// ReadQueue reads from one of the device's queues; all frames of a flow arrive on the same queue
+func (a *TAP) ReadQueue(queue int, p []byte) (n int, err error) {
+	taps := a.getTAPs()
+	if len(taps) == 0 {
 		return -1, net.ErrClosed
 	}
 
-	return a.tap.Read(p)
+	return taps[queue].Read(p)
 }
 
 func (a *TAP) Write(p []byte) (n int, err error) {
-	if a.tap == nil {
+	taps := a.getTAPs()
+	if len(taps) == 0 {
 		return -1, net.ErrClosed
 	}
 
-	return a.tap.Write(p)
+	return taps[0].Write(p)
 }
 
 func (a *TAP) Close() error {
-	if a.tap == nil {
-		return nil // No-op
+	a.lock.Lock()
+	taps := a.taps
+	a.taps = nil
+	a.lock.Unlock()
+
+	for _, tap := range taps {
+		if err := tap.Close(); err != nil {
+			return err
+		}
 	}
 
-	if err := a.tap.Close(); err != nil {
-		return err
-	}
+	return nil
+}
 
-	a.tap = nil
+func (a *TAP) GetQueues() int {
+	return len(a.getTAPs())
+}
 
-	return nil
+// getTAPs returns the device's queues, which are replaced with nil once it is closed
+func (a *TAP) getTAPs() []io.ReadWriteCloser {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return a.taps
 }
 
 func (a *TAP) GetFrameSize() (int, error) {
-	if a.tap == nil {
+	if len(a.getTAPs()) == 0 {
 		return -1, net.ErrClosed
 	}
 
-	iface, err := net.InterfaceByName(a.tap.Name())
+	iface, err := getInterface(a.netNS, a.deviceName)
 	if err != nil {
 		return -1, err
 	}
 
-	return iface.MTU + ethernetHeaderLength, nil
+	frameSize := iface.MTU + ethernetHeaderLength
+
+	atomic.StoreInt64(&a.frameSize, int64(frameSize))
+
+	return frameSize, nil
+}
+
+// getCachedFrameSize returns the frame size without looking up the device for every frame, as it is needed to tell
+// unsegmented TCP segments apart when offloading
+func (a *TAP) getCachedFrameSize() int {
+	if frameSize := atomic.LoadInt64(&a.frameSize); frameSize > 0 {
+		return int(frameSize)
+	}
+
+	frameSize, err := a.GetFrameSize()
+	if err != nil {
+		return 0
+	}
+
+	return frameSize
 }
 
 func (a *TAP) GetMACAddress() (net.HardwareAddr, error) {
-	if a.tap == nil {
+	if len(a.getTAPs()) == 0 {
 		return nil, net.ErrClosed
 	}
 
-	iface, err := net.InterfaceByName(a.tap.Name())
+	iface, err := getInterface(a.netNS, a.deviceName)
 	if err != nil {
 		return nil, err
 	}
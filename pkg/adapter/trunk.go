@@ -0,0 +1,242 @@
+package adapter
+
+import (
+	"net"
+	"sync"
+
+	"github.com/mdlayher/ethernet"
+	"github.com/pojntfx/weron/pkg/config"
+)
+
+const (
+	vlanTagLength = 4
+	vlanQueueLen  = 1024
+	maxVLANID     = 4094
+)
+
+// Trunk shares one TAP device between communities by tagging the frames of each community with its VLAN ID (802.1Q)
+type Trunk struct {
+	tap   *TAP
+	vlans map[uint16]*VLAN
+
+	lock sync.Mutex
+}
+
+func NewTrunk(name string, netNS string) *Trunk {
+	return &Trunk{
+		tap:   NewTAP(name, netNS, 1, false),
+		vlans: map[uint16]*VLAN{},
+	}
+}
+
+func (t *Trunk) Open() (string, error) {
+	return t.tap.Open()
+}
+
+// Serve reads tagged frames from the TAP device and passes them on to the VLAN with their ID until the device is closed
+func (t *Trunk) Serve() error {
+	frameSize, err := t.tap.GetFrameSize()
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, frameSize+vlanTagLength)
+	for {
+		n, err := t.tap.Read(buf)
+		if err != nil {
+			return err
+		}
+
+		t.receive(buf[:n])
+	}
+}
+
+func (t *Trunk) receive(frame []byte) {
+	id, untagged, ok := untag(frame)
+	if !ok {
+		return
+	}
+
+	t.lock.Lock()
+	vlan, ok := t.vlans[id]
+	t.lock.Unlock()
+
+	if !ok {
+		return
+	}
+
+	// Drop frames like a switch would if the community can't keep up
+	select {
+	case vlan.frames <- untagged:
+	default:
+	}
+}
+
+func (t *Trunk) Close() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for id, vlan := range t.vlans {
+		vlan.close()
+
+		delete(t.vlans, id)
+	}
+
+	return t.tap.Close()
+}
+
+// VLAN returns an adapter which reads and writes the frames of the VLAN with the ID as untagged frames
+func (t *Trunk) VLAN(id uint16) *VLAN {
+	return &VLAN{
+		id:    id,
+		trunk: t,
+	}
+}
+
+type VLAN struct {
+	id    uint16
+	trunk *Trunk
+
+	frames chan []byte
+	done   chan struct{}
+	closed bool
+
+	lock sync.Mutex
+}
+
+func (a *VLAN) Open() (string, error) {
+	if a.id == 0 || a.id > maxVLANID {
+		return "", config.ErrInvalidVLANID
+	}
+
+	a.trunk.lock.Lock()
+	defer a.trunk.lock.Unlock()
+
+	if len(a.trunk.tap.getTAPs()) == 0 {
+		return "", net.ErrClosed
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if _, ok := a.trunk.vlans[a.id]; ok || a.frames != nil {
+		return "", config.ErrAlreadyOpened
+	}
+
+	// The channels aren't replaced after this, so the trunk can send to them without holding the VLAN's lock
+	a.frames = make(chan []byte, vlanQueueLen)
+	a.done = make(chan struct{})
+
+	a.trunk.vlans[a.id] = a
+
+	return a.trunk.tap.deviceName, nil
+}
+
+func (a *VLAN) Read(p []byte) (n int, err error) {
+	a.lock.Lock()
+	frames, done := a.frames, a.done
+	a.lock.Unlock()
+
+	if frames == nil {
+		return -1, net.ErrClosed
+	}
+
+	select {
+	case <-done:
+		return -1, net.ErrClosed
+	case frame := <-frames:
+		return copy(p, frame), nil
+	}
+}
+
+func (a *VLAN) Write(p []byte) (n int, err error) {
+	tagged, err := tag(p, a.id)
+	if err != nil {
+		return -1, err
+	}
+
+	// Frames which are already tagged can't be mapped to the community's VLAN
+	if tagged == nil {
+		return len(p), nil
+	}
+
+	if _, err := a.trunk.tap.Write(tagged); err != nil {
+		return -1, err
+	}
+
+	return len(p), nil
+}
+
+func (a *VLAN) Close() error {
+	a.trunk.lock.Lock()
+	defer a.trunk.lock.Unlock()
+
+	if a.trunk.vlans[a.id] == a {
+		delete(a.trunk.vlans, a.id)
+	}
+
+	a.close()
+
+	return nil
+}
+
+func (a *VLAN) close() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.done == nil || a.closed {
+		return // No-op
+	}
+
+	close(a.done)
+
+	a.closed = true
+}
+
+// GetFrameSize returns the size of untagged frames
+func (a *VLAN) GetFrameSize() (int, error) {
+	return a.trunk.tap.GetFrameSize()
+}
+
+func (a *VLAN) GetMACAddress() (net.HardwareAddr, error) {
+	return a.trunk.tap.GetMACAddress()
+}
+
+// tag returns the frame with the VLAN ID, or nil if it is tagged already
+func tag(frame []byte, id uint16) ([]byte, error) {
+	var parsedFrame ethernet.Frame
+	if err := parsedFrame.UnmarshalBinary(frame); err != nil {
+		return nil, err
+	}
+
+	if parsedFrame.VLAN != nil {
+		return nil, nil
+	}
+
+	parsedFrame.VLAN = &ethernet.VLAN{ID: id}
+
+	return parsedFrame.MarshalBinary()
+}
+
+// untag returns the VLAN ID of the frame and the frame without its tag
+func untag(frame []byte) (uint16, []byte, bool) {
+	var parsedFrame ethernet.Frame
+	if err := parsedFrame.UnmarshalBinary(frame); err != nil {
+		return 0, nil, false
+	}
+
+	// Untagged and double-tagged frames don't belong to any community
+	if parsedFrame.VLAN == nil || parsedFrame.ServiceVLAN != nil || parsedFrame.EtherType == ethernet.EtherTypeVLAN || parsedFrame.EtherType == ethernet.EtherTypeServiceVLAN {
+		return 0, nil, false
+	}
+
+	id := parsedFrame.VLAN.ID
+	parsedFrame.VLAN = nil
+
+	untagged, err := parsedFrame.MarshalBinary()
+	if err != nil {
+		return 0, nil, false
+	}
+
+	return id, untagged, true
+}
@@ -0,0 +1,148 @@
+package adapter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/mdlayher/ethernet"
+)
+
+var (
+	testDestination = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x0a}
+	testSource      = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x0b}
+	testPayload     = bytes.Repeat([]byte{0xaa}, 46)
+)
+
+// newTestFrame creates an IPv4 frame with a tag for each of the TPIDs and VLAN IDs, outermost first
+func newTestFrame(tags ...uint16) []byte {
+	frame := append([]byte{}, testDestination...)
+	frame = append(frame, testSource...)
+
+	for i := 0; i < len(tags); i += 2 {
+		frame = binary.BigEndian.AppendUint16(frame, tags[i])
+		frame = binary.BigEndian.AppendUint16(frame, tags[i+1])
+	}
+
+	frame = binary.BigEndian.AppendUint16(frame, uint16(ethernet.EtherTypeIPv4))
+
+	return append(frame, testPayload...)
+}
+
+func TestTag(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		frame []byte
+		id    uint16
+		want  []byte
+	}{
+		{"untagged", newTestFrame(), 10, newTestFrame(uint16(ethernet.EtherTypeVLAN), 10)},
+		{"max id", newTestFrame(), maxVLANID, newTestFrame(uint16(ethernet.EtherTypeVLAN), maxVLANID)},
+		{"tagged", newTestFrame(uint16(ethernet.EtherTypeVLAN), 20), 10, nil},
+		{"double-tagged", newTestFrame(uint16(ethernet.EtherTypeServiceVLAN), 30, uint16(ethernet.EtherTypeVLAN), 20), 10, nil},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			tagged, err := tag(test.frame, test.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(tagged, test.want) {
+				t.Fatalf("tagged frame is %x, want %x", tagged, test.want)
+			}
+		})
+	}
+
+	if _, err := tag([]byte{0x00}, 10); err == nil {
+		t.Fatal("tagging a truncated frame didn't fail")
+	}
+}
+
+func TestUntag(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		frame  []byte
+		wantID uint16
+		wantOK bool
+	}{
+		{"tagged", newTestFrame(uint16(ethernet.EtherTypeVLAN), 10), 10, true},
+		{"tagged with priority", newTestFrame(uint16(ethernet.EtherTypeVLAN), 5<<13|10), 10, true},
+		{"untagged", newTestFrame(), 0, false},
+		{"double-tagged", newTestFrame(uint16(ethernet.EtherTypeServiceVLAN), 30, uint16(ethernet.EtherTypeVLAN), 10), 0, false},
+		{"double-tagged with customer tpid", newTestFrame(uint16(ethernet.EtherTypeVLAN), 30, uint16(ethernet.EtherTypeVLAN), 10), 0, false},
+		{"truncated", newTestFrame(uint16(ethernet.EtherTypeVLAN), 10)[:14], 0, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			id, untagged, ok := untag(test.frame)
+			if ok != test.wantOK {
+				t.Fatalf("untagging returned %v, want %v", ok, test.wantOK)
+			}
+
+			if !ok {
+				return
+			}
+
+			if id != test.wantID {
+				t.Fatalf("got VLAN ID %v, want %v", id, test.wantID)
+			}
+
+			if !bytes.Equal(untagged, newTestFrame()) {
+				t.Fatalf("untagged frame is %x, want %x", untagged, newTestFrame())
+			}
+		})
+	}
+}
+
+func TestTrunkReceive(t *testing.T) {
+	trunk := &Trunk{vlans: map[uint16]*VLAN{}}
+	for _, id := range []uint16{10, 20} {
+		trunk.vlans[id] = &VLAN{
+			id:     id,
+			trunk:  trunk,
+			frames: make(chan []byte, 1),
+			done:   make(chan struct{}),
+		}
+	}
+
+	for _, test := range []struct {
+		name  string
+		frame []byte
+		want  uint16 // VLAN which receives the frame; 0 if it is dropped
+	}{
+		{"known vid", newTestFrame(uint16(ethernet.EtherTypeVLAN), 10), 10},
+		{"other known vid", newTestFrame(uint16(ethernet.EtherTypeVLAN), 20), 20},
+		{"unknown vid", newTestFrame(uint16(ethernet.EtherTypeVLAN), 30), 0},
+		{"untagged", newTestFrame(), 0},
+		{"double-tagged", newTestFrame(uint16(ethernet.EtherTypeServiceVLAN), 10, uint16(ethernet.EtherTypeVLAN), 10), 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			trunk.receive(test.frame)
+
+			for id, vlan := range trunk.vlans {
+				select {
+				case frame := <-vlan.frames:
+					if id != test.want {
+						t.Fatalf("VLAN %v received the frame, want %v", id, test.want)
+					}
+
+					if !bytes.Equal(frame, newTestFrame()) {
+						t.Fatalf("VLAN %v received %x, want the untagged frame %x", id, frame, newTestFrame())
+					}
+				default:
+					if id == test.want {
+						t.Fatalf("VLAN %v didn't receive the frame", id)
+					}
+				}
+			}
+		})
+	}
+
+	// Frames are dropped instead of blocking the trunk if a VLAN can't keep up
+	trunk.receive(newTestFrame(uint16(ethernet.EtherTypeVLAN), 10))
+	trunk.receive(newTestFrame(uint16(ethernet.EtherTypeVLAN), 10))
+
+	if queued := len(trunk.vlans[10].frames); queued != 1 {
+		t.Fatalf("VLAN has %v queued frames, want 1", queued)
+	}
+}
@@ -0,0 +1,164 @@
+package adapter
+
+import (
+	"io"
+	"net"
+	"sync"
+
+	"github.com/pojntfx/weron/pkg/config"
+	"github.com/songgao/water"
+)
+
+type TUN struct {
+	name   string
+	netNS  string
+	queues int
+
+	mac        net.HardwareAddr
+	deviceName string
+	tuns       []io.ReadWriteCloser
+
+	lock sync.Mutex
+}
+
+func NewTUN(name string, netNS string, queues int) *TUN {
+	return &TUN{
+		name:   name,
+		netNS:  netNS,
+		queues: queues,
+	}
+}
+
+func (a *TUN) Open() (string, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.tuns != nil {
+		return "", config.ErrAlreadyOpened
+	}
+
+	// TUN devices don't have a MAC address, but peers are identified by one
+	mac, err := getRandomMACAddress()
+	if err != nil {
+		return "", err
+	}
+
+	if err := inNetNS(a.netNS, func() error {
+		tuns, deviceName, err := openQueues(water.TUN, a.name, a.queues)
+		if err != nil {
+			return err
+		}
+
+		a.tuns = tuns
+		a.deviceName = deviceName
+
+		return nil
+	}); err != nil {
+		return "", err
+	}
+
+	a.mac = mac
+
+	return a.deviceName, nil
+}
+
+func (a *TUN) Read(p []byte) (n int, err error) {
+	return a.ReadQueue(0, p)
+}
+
+// ReadQueue reads from one of the device's queues; all packets of a flow arrive on the same queue
+func (a *TUN) ReadQueue(queue int, p []byte) (n int, err error) {
+	tuns := a.getTUNs()
+	if len(tuns) == 0 {
+		return -1, net.ErrClosed
+	}
+
+	return tuns[queue].Read(p)
+}
+
+func (a *TUN) Write(p []byte) (n int, err error) {
+	tuns := a.getTUNs()
+	if len(tuns) == 0 {
+		return -1, net.ErrClosed
+	}
+
+	return tuns[0].Write(p)
+}
+
+func (a *TUN) Close() error {
+	a.lock.Lock()
+	tuns := a.tuns
+	a.tuns = nil
+	a.lock.Unlock()
+
+	for _, tun := range tuns {
+		if err := tun.Close(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *TUN) GetQueues() int {
+	return len(a.getTUNs())
+}
+
+// getTUNs returns the device's queues, which are replaced with nil once it is closed
+func (a *TUN) getTUNs() []io.ReadWriteCloser {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return a.tuns
+}
+
+func (a *TUN) GetFrameSize() (int, error) {
+	if len(a.getTUNs()) == 0 {
+		return -1, net.ErrClosed
+	}
+
+	iface, err := getInterface(a.netNS, a.deviceName)
+	if err != nil {
+		return -1, err
+	}
+
+	// Packets on TUN devices don't have an Ethernet header
+	return iface.MTU, nil
+}
+
+func (a *TUN) GetMACAddress() (net.HardwareAddr, error) {
+	if len(a.getTUNs()) == 0 {
+		return nil, net.ErrClosed
+	}
+
+	return a.mac, nil
+}
+
+func (a *TUN) GetAddresses() ([]net.IP, error) {
+	if len(a.getTUNs()) == 0 {
+		return nil, net.ErrClosed
+	}
+
+	var addrs []net.Addr
+	if err := inNetNS(a.netNS, func() error {
+		iface, err := net.InterfaceByName(a.deviceName)
+		if err != nil {
+			return err
+		}
+
+		addrs, err = iface.Addrs()
+
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	addresses := []net.IP{}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			addresses = append(addresses, ipNet.IP)
+		}
+	}
+
+	return addresses, nil
+}
@@ -0,0 +1,1110 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/ethernet"
+	"github.com/pion/ice/v2"
+	"github.com/pion/webrtc/v3"
+	"github.com/pojntfx/weron/pkg/adapter"
+	"github.com/pojntfx/weron/pkg/autoconf"
+	"github.com/pojntfx/weron/pkg/config"
+	"github.com/pojntfx/weron/pkg/control"
+	"github.com/pojntfx/weron/pkg/dhcp"
+	"github.com/pojntfx/weron/pkg/encryption"
+	"github.com/pojntfx/weron/pkg/signaling"
+	"github.com/pojntfx/weron/pkg/transport"
+	"nhooyr.io/websocket"
+)
+
+type AgentConfig struct {
+	Raddr          string
+	Key            string
+	Community      string
+	DeviceName     string
+	Adapter        adapter.Adapter    // Defaults to a TAP or TUN device named DeviceName
+	Transport      TransportFactory   // Defaults to WebRTC
+	NetNS          string             // Network namespace to create the TAP or TUN device in, either a path (i.e. /var/run/netns/tenant1) or a name (i.e. tenant1); connections to the signaler and peers are still made from the agent's namespace
+	TUN            bool               // Route IP packets to peers by the addresses they advertise instead of switching Ethernet frames
+	Offload        bool               // Exchange TCP segments larger than the MTU with the TAP device and peers which support it, which segment them instead of us (Linux only)
+	Link           adapter.LinkConfig // Addresses, routes, MTU and bridge to configure on the TAP or TUN device
+	Autoconfigure  bool               // Assign IPv4 link-local and IPv6 addresses derived from the TAP device's MAC address once no peer uses them
+	DHCP           bool               // Request an IPv4 address from a DHCP server on the overlay
+	DHCPServer     string             // Address of the DHCP server in CIDR notation; the other addresses in its network are leased to peers (an empty address disables the server)
+	DHCPLeaseTime  time.Duration
+	STUNServers    []string
+	TURNServers    []string   // Formatted as username:credential@turn:global.turn.twilio.com:3478?transport=tcp
+	UDPMux         ice.UDPMux // Gathers host candidates on one port which can be shared with other agents; nil uses a new port for each peer
+	TLSFingerprint string
+	TLSInsecure    bool
+	TLSHosts       string
+	Timeout        time.Duration // Duration between reconnects and pings
+	MACTTL         time.Duration
+	RestartTimeout time.Duration
+	Reliability    transport.Reliability
+	Queueing       transport.Queueing
+	Batching       transport.Batching
+	Compressions   []transport.Compression
+	ControlSocket  string // An empty path disables the control socket
+	Verbose        bool
+}
+
+func (c AgentConfig) Validate() error {
+	if !(c.Key == "" || len(c.Key) == 16 || len(c.Key) == 24 || len(c.Key) == 32) {
+		return config.ErrInvalidKey
+	}
+
+	if strings.TrimSpace(c.Community) == "" {
+		return config.ErrInvalidCommunity
+	}
+
+	if err := c.Reliability.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.Queueing.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.Batching.Validate(); err != nil {
+		return err
+	}
+
+	if err := transport.ValidateCompressions(c.Compressions); err != nil {
+		return err
+	}
+
+	if err := c.Link.Validate(); err != nil {
+		return err
+	}
+
+	if c.Autoconfigure && (c.TUN || c.Adapter != nil) {
+		return config.ErrAutoconfigurationRequiresTAP
+	}
+
+	if c.Link.Bridge != "" && c.TUN {
+		return config.ErrBridgeRequiresTAP
+	}
+
+	if c.Offload && (c.TUN || c.Adapter != nil) {
+		return config.ErrOffloadRequiresTAP
+	}
+
+	if (c.DHCP || c.DHCPServer != "") && c.TUN {
+		return config.ErrDHCPRequiresEthernet
+	}
+
+	if c.DHCPServer != "" {
+		if ip, _, err := net.ParseCIDR(c.DHCPServer); err != nil || ip.To4() == nil {
+			return config.ErrInvalidDHCPServerAddress
+		}
+
+		if c.DHCPLeaseTime < time.Second {
+			return config.ErrInvalidDHCPLeaseTime
+		}
+	}
+
+	_, err := getICEServers(c.STUNServers, c.TURNServers)
+
+	return err
+}
+
+const (
+	broadcastMAC = "ff:ff:ff:ff:ff:ff"
+
+	addressSourceSignaler = "signaler"
+	addressSourceDHCP     = "dhcp"
+
+	signalBufferLength = 128
+)
+
+// TransportFactory creates the transport which connects the agent to its peers, i.e. an in-memory transport for tests
+type TransportFactory func(
+	mac string,
+
+	onReceive func(mac string, frame []byte),
+	onOffer func(mac string, o webrtc.SessionDescription),
+	onAnswer func(mac string, o webrtc.SessionDescription),
+	onDataChannelOpen func(mac string),
+	onDataChannelClose func(mac string),
+) transport.Transport
+
+type candidate struct {
+	mac string
+	i   webrtc.ICECandidate
+}
+
+type session struct {
+	mac string
+	o   webrtc.SessionDescription
+}
+
+type Agent struct {
+	config AgentConfig
+
+	mac        string
+	deviceName string
+	manager    *transport.WebRTCManager
+	routes     *transport.RoutingTable
+	autoconf   *autoconf.Autoconfigurator
+	addresses  map[string]string // Addresses leased from the signaler or a DHCP server by their source
+	signaler   *signaling.SignalingClient
+
+	lock sync.Mutex
+
+	onOpen               func(deviceName string) error
+	onSignalerConnect    func(raddr string)
+	onSignalerDisconnect func(raddr string, err error, reconnectIn time.Duration)
+	onPeerConnect        func(mac string)
+	onPeerDisconnect     func(mac string)
+	onTLSMessage         func(format string, v ...interface{})
+	onTLSRead            func(format string, v ...interface{}) (string, error)
+}
+
+func NewAgent(
+	config AgentConfig,
+
+	onOpen func(deviceName string) error,
+	onSignalerConnect func(raddr string),
+	onSignalerDisconnect func(raddr string, err error, reconnectIn time.Duration),
+	onPeerConnect func(mac string),
+	onPeerDisconnect func(mac string),
+	onTLSMessage func(format string, v ...interface{}),
+	onTLSRead func(format string, v ...interface{}) (string, error),
+) *Agent {
+	return &Agent{
+		config: config,
+
+		addresses: map[string]string{},
+
+		onOpen:               onOpen,
+		onSignalerConnect:    onSignalerConnect,
+		onSignalerDisconnect: onSignalerDisconnect,
+		onPeerConnect:        onPeerConnect,
+		onPeerDisconnect:     onPeerDisconnect,
+		onTLSMessage:         onTLSMessage,
+		onTLSRead:            onTLSRead,
+	}
+}
+
+func (a *Agent) Status() control.Status {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	status := control.Status{
+		MAC:               a.mac,
+		DeviceName:        a.deviceName,
+		Community:         a.config.Community,
+		Signaler:          a.config.Raddr,
+		SignalerConnected: a.signaler != nil,
+	}
+
+	if a.routes != nil {
+		status.Routes = a.routes.Routes()
+	}
+
+	if a.autoconf != nil {
+		status.Addresses = a.autoconf.Addresses()
+	}
+
+	sources := []string{}
+	for source := range a.addresses {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	for _, source := range sources {
+		status.Addresses = append(status.Addresses, a.addresses[source])
+	}
+
+	return status
+}
+
+func (a *Agent) Stats() transport.Stats {
+	a.lock.Lock()
+	manager := a.manager
+	a.lock.Unlock()
+
+	if manager == nil {
+		return transport.Stats{Peers: []transport.PeerStats{}}
+	}
+
+	return manager.Stats()
+}
+
+func (a *Agent) getSignaler() *signaling.SignalingClient {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return a.signaler
+}
+
+func (a *Agent) setSignaler(s *signaling.SignalingClient) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.signaler = s
+}
+
+// Run joins the community and blocks until the context is cancelled or a fatal error occurs
+func (a *Agent) Run(ctx context.Context) error {
+	if err := a.config.Validate(); err != nil {
+		return err
+	}
+
+	// Wait for background goroutines only after the context has been cancelled and the device has been closed
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Errors sent here stop the agent; errors in the connection to the signaler only cause a reconnect
+	fatal := make(chan error, 1)
+	fail := func(err error) {
+		select {
+		case fatal <- err:
+		default:
+		}
+	}
+
+	iceServers, err := getICEServers(a.config.STUNServers, a.config.TURNServers)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(a.config.TLSHosts), os.ModePerm); err != nil {
+		return err
+	}
+
+	var tap adapter.Adapter
+	switch {
+	case a.config.Adapter != nil:
+		tap = a.config.Adapter
+	case a.config.TUN:
+		tap = adapter.NewTUN(a.config.DeviceName, a.config.NetNS, a.config.Queueing.Queues)
+	default:
+		tap = adapter.NewTAP(a.config.DeviceName, a.config.NetNS, a.config.Queueing.Queues, a.config.Offload)
+	}
+
+	deviceName, err := tap.Open()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// Ignore as this can be a no-op
+		_ = tap.Close()
+	}()
+
+	// Devices which are provided by the caller are configured by the caller; autoconfiguration and DHCP need the device to be up to receive replies
+	if a.config.Adapter == nil && (!a.config.Link.IsEmpty() || a.config.Autoconfigure || a.config.DHCP) {
+		if err := adapter.ConfigureLink(deviceName, a.config.NetNS, a.config.Link); err != nil {
+			return err
+		}
+		defer func() {
+			if err := adapter.UnconfigureLink(deviceName, a.config.NetNS, a.config.Link); err != nil {
+				log.Println("could not remove link configuration, continuing:", err)
+			}
+		}()
+	}
+
+	mac, err := tap.GetMACAddress()
+	if err != nil {
+		return err
+	}
+
+	frameSize, err := tap.GetFrameSize()
+	if err != nil {
+		return err
+	}
+
+	// Frames are queued by the manager before they are compressed and encrypted, so their buffers can be re-used
+	pool := transport.NewBufferPool(frameSize)
+
+	// With offload, the device hands over TCP segments which are larger than the MTU; they are segmented for peers which don't support offload
+	readSize := frameSize
+	offloadSize := 0
+	if a.config.Offload {
+		readSize = adapter.MaxOffloadFrameSize
+		offloadSize = frameSize
+	}
+
+	// Peers advertise their addresses in layer 3 mode, so packets are only sent to the peer which has the destination address
+	var routes *transport.RoutingTable
+	getFlowHash := transport.GetFrameFlowHash
+	if a.config.TUN {
+		routes = transport.NewRoutingTable()
+		getFlowHash = transport.GetPacketFlowHash
+	}
+
+	// Addresses are only probed for once a peer has connected, as conflicts can't be detected without peers
+	var autoconfigurator *autoconf.Autoconfigurator
+	var dhcpServer *dhcp.Server
+	var dhcpClient *dhcp.Client
+	var peerConnected chan struct{}
+	var peerConnectedOnce sync.Once
+	if a.config.Autoconfigure {
+		peerConnected = make(chan struct{})
+	}
+
+	// Candidates, offers and answers share one channel so that they reach the peer in the order they were created;
+	// it is buffered so that a slow signaler doesn't stall the transport
+	signals := make(chan interface{}, signalBufferLength)
+	signal := func(s interface{}) {
+		select {
+		case <-ctx.Done():
+		case signals <- s:
+		}
+	}
+
+	var peers transport.Transport
+	var manager *transport.WebRTCManager
+	onCandidate := func(mac string, i webrtc.ICECandidate) {
+		if a.config.Verbose {
+			log.Println("Handling outgoing candidate for MAC", mac)
+		}
+
+		signal(candidate{mac, i})
+	}
+
+	onReceive := func(mac string, frame []byte) {
+		if a.config.Verbose {
+			log.Println("Handling outgoing frame for MAC", mac)
+		}
+
+		if routes != nil {
+			if transport.IsAdvertisement(frame) {
+				addresses, err := transport.DecodeAdvertisement(frame)
+				if err != nil {
+					log.Println("could not parse address advertisement, continuing:", err)
+
+					return
+				}
+
+				if a.config.Verbose {
+					log.Println("Peer with MAC", mac, "advertised addresses", addresses)
+				}
+
+				routes.Advertise(mac, addresses)
+
+				return
+			}
+		} else {
+			var parsedFrame ethernet.Frame
+			if err := parsedFrame.UnmarshalBinary(frame); err != nil {
+				log.Println("could not parse frame, continuing:", err)
+
+				return
+			}
+
+			// Remember which peer nodes bridged behind it can be reached through
+			peers.Learn(mac, parsedFrame.Source.String())
+
+			if autoconfigurator != nil {
+				autoconfigurator.Receive(frame)
+			}
+
+			if dhcpServer != nil {
+				dhcpServer.Receive(frame)
+			}
+
+			if dhcpClient != nil {
+				dhcpClient.Receive(frame)
+			}
+		}
+
+		if _, err := tap.Write(frame); err != nil {
+			fail(err)
+
+			return
+		}
+	}
+
+	onReceiveError := func(mac string, err error) {
+		log.Println("could not decrypt frame, continuing:", err)
+	}
+
+	onOffer := func(mac string, o webrtc.SessionDescription) {
+		if a.config.Verbose {
+			log.Println("Handling outgoing offer for MAC", mac)
+		}
+
+		signal(session{mac, o})
+	}
+
+	onAnswer := func(mac string, o webrtc.SessionDescription) {
+		if a.config.Verbose {
+			log.Println("Handling outgoing answer for MAC", mac)
+		}
+
+		signal(session{mac, o})
+	}
+
+	onDataChannelOpen := func(mac string) {
+		if routes != nil {
+			// The transport runs its callbacks one after the other, so don't hold up the ones queued behind this one
+			go a.advertise(peers, tap, mac)
+		}
+
+		if peerConnected != nil {
+			peerConnectedOnce.Do(func() {
+				close(peerConnected)
+			})
+		}
+
+		a.onPeerConnect(mac)
+	}
+
+	onDataChannelClose := func(mac string) {
+		if routes != nil {
+			routes.Forget(mac)
+		}
+
+		if a.config.Verbose && manager != nil {
+			if compression, ratio, err := manager.CompressionRatio(mac); err == nil && compression != transport.CompressionNone {
+				log.Printf("Compressed frames for MAC %v with %v at a ratio of %.2f", mac, compression, ratio)
+			}
+		}
+
+		a.onPeerDisconnect(mac)
+	}
+
+	if a.config.Transport != nil {
+		peers = a.config.Transport(
+			mac.String(),
+
+			onReceive,
+			onOffer,
+			onAnswer,
+			onDataChannelOpen,
+			onDataChannelClose,
+		)
+	} else {
+		manager = transport.NewWebRTCManager(
+			mac.String(),
+			iceServers,
+			a.config.UDPMux,
+			[]byte(a.config.Key),
+			a.config.Compressions,
+			a.config.MACTTL,
+			a.config.Reliability,
+			a.config.Queueing,
+			a.config.Batching,
+			a.config.RestartTimeout,
+			pool,
+			getFlowHash,
+			offloadSize,
+			onCandidate,
+			onReceive,
+			onReceiveError,
+			onOffer,
+			onAnswer,
+			onDataChannelOpen,
+			onDataChannelClose,
+		)
+		peers = manager
+	}
+	defer func() {
+		// Ignore as this can be a no-op
+		_ = peers.Close()
+	}()
+
+	if a.config.Autoconfigure {
+		autoconfigurator = autoconf.NewAutoconfigurator(
+			mac,
+			autoconf.GetIPv6Prefix(a.config.Community),
+
+			peers.Write,
+			func(address string) error {
+				log.Println("Assigning address", address)
+
+				return a.addAddress(tap, deviceName, address)
+			},
+			func(address string) error {
+				if a.config.Verbose {
+					log.Println("Removing address", address)
+				}
+
+				return a.removeAddress(tap, deviceName, address)
+			},
+			func(address string, mac string) {
+				log.Println("Address", address, "is already used by peer with MAC", mac+", choosing another one")
+			},
+			func(err error) {
+				if a.config.Verbose {
+					log.Println("could not probe for address, continuing:", err)
+				}
+			},
+		)
+	}
+
+	if a.config.DHCPServer != "" {
+		// Can't fail as the config has been validated
+		address, network, _ := net.ParseCIDR(a.config.DHCPServer)
+
+		if err := a.addAddress(tap, deviceName, a.config.DHCPServer); err != nil {
+			return err
+		}
+		defer func() {
+			if err := a.removeAddress(tap, deviceName, a.config.DHCPServer); err != nil && a.config.Verbose {
+				log.Println("could not remove DHCP server address, continuing:", err)
+			}
+		}()
+
+		dhcpServer = dhcp.NewServer(
+			mac,
+			address,
+			network,
+			a.config.DHCPLeaseTime,
+
+			peers.Write,
+			func(address string, mac string) {
+				if a.config.Verbose {
+					log.Println("Leased address", address, "to MAC", mac, "using DHCP")
+				}
+			},
+			func(err error) {
+				if a.config.Verbose {
+					log.Println("could not send DHCP reply, continuing:", err)
+				}
+			},
+		)
+	}
+
+	if a.config.DHCP {
+		dhcpClient = dhcp.NewClient(
+			mac,
+
+			peers.Write,
+			func(address string) error {
+				a.setAddress(tap, deviceName, addressSourceDHCP, address)
+
+				return nil
+			},
+			func(address string) error {
+				a.setAddress(tap, deviceName, addressSourceDHCP, "")
+
+				return nil
+			},
+			func(err error) {
+				if a.config.Verbose {
+					log.Println("could not send DHCP request, continuing:", err)
+				}
+			},
+		)
+	}
+
+	a.lock.Lock()
+	a.mac = mac.String()
+	a.deviceName = deviceName
+	a.manager = manager
+	a.routes = routes
+	a.autoconf = autoconfigurator
+	a.lock.Unlock()
+
+	if a.config.ControlSocket != "" {
+		controlServer := control.NewControlServer(
+			a.config.ControlSocket,
+			func() []control.Status {
+				return []control.Status{a.Status()}
+			},
+			func() []control.Peers {
+				return []control.Peers{{Community: a.config.Community, Stats: a.Stats()}}
+			},
+		)
+
+		if err := controlServer.Open(); err != nil {
+			return err
+		}
+		defer func() {
+			// Ignore as this can be a no-op
+			_ = controlServer.Close()
+		}()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if err := controlServer.Serve(); err != nil {
+				fail(err)
+			}
+		}()
+
+		if a.config.Verbose {
+			log.Println("Control socket listening on", a.config.ControlSocket)
+		}
+	}
+
+	// Forward signals to whichever signaler we are currently connected to
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for {
+			var signal interface{}
+			select {
+			case <-ctx.Done():
+				return
+			case signal = <-signals:
+			}
+
+			s := a.getSignaler()
+			if s == nil {
+				if a.config.Verbose {
+					log.Println("could not signal to peer, continuing:", config.ErrNotConnectedToSignaler)
+				}
+
+				continue
+			}
+
+			var err error
+			switch v := signal.(type) {
+			case candidate:
+				err = s.SignalCandidate(v.mac, v.i)
+			case session:
+				if v.o.Type == webrtc.SDPTypeOffer {
+					err = s.SignalOffer(v.mac, v.o)
+				} else {
+					err = s.SignalAnswer(v.mac, v.o)
+				}
+			}
+
+			// The signaler reconnects by itself if the connection broke
+			if err != nil && a.config.Verbose {
+				log.Println("could not signal to peer, continuing:", err)
+			}
+		}
+	}()
+
+	defer a.setAddress(tap, deviceName, addressSourceSignaler, "")
+
+	if err := a.onOpen(deviceName); err != nil {
+		return err
+	}
+
+	// Keep reconnecting to the signaler in the background; established peer connections are not affected by this
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for {
+			sleep := a.config.Timeout + time.Duration(time.Second*time.Duration(rand.Intn(5)))
+
+			signalerCtx, cancel := context.WithCancel(ctx)
+
+			err := func() error {
+				conn, err := a.dialSignaler(signalerCtx, sleep, fail)
+				if err != nil {
+					return err
+				}
+
+				s := signaling.NewSignalingClient(
+					conn,
+					mac.String(),
+					a.config.Community,
+
+					signalerCtx,
+					sleep,
+
+					func(address string) {
+						// The signaler only leases addresses if it manages addresses for the community
+						if address == "" {
+							return
+						}
+
+						a.setAddress(tap, deviceName, addressSourceSignaler, address)
+					},
+					func(mac string) {
+						if a.config.Verbose {
+							log.Println("Handling incoming introduction for MAC", mac)
+						}
+
+						if err := peers.HandleIntroduction(mac); err != nil {
+							log.Println("could not handle introduction, continuing:", err)
+						}
+					},
+					func(mac string, o webrtc.SessionDescription) {
+						if a.config.Verbose {
+							log.Println("Handling incoming offer for MAC", mac)
+						}
+
+						if err := peers.HandleOffer(mac, o); err != nil {
+							log.Println("could not handle offer, continuing:", err)
+						}
+					},
+					func(mac string, i webrtc.ICECandidateInit) {
+						if a.config.Verbose {
+							log.Println("Handling incoming candidate for MAC", mac)
+						}
+
+						if err := peers.HandleCandidate(mac, i); err != nil {
+							log.Println("could not handle candidate, continuing:", err)
+						}
+					},
+					func(mac string, o webrtc.SessionDescription) {
+						if a.config.Verbose {
+							log.Println("Handling incoming answer for MAC", mac)
+						}
+
+						if err := peers.HandleAnswer(mac, o); err != nil {
+							log.Println("could not handle answer, continuing:", err)
+						}
+					},
+					func(mac string, blocked bool) {
+						if blocked {
+							log.Println("Blocked connection to peer", mac, "due to wrong encryption key")
+						} else if peers.IsEstablished(mac) {
+							// The peer might only be reconnecting to the signaler; if it is gone for good, its connection fails
+							return
+						}
+
+						// Ignore as this can be a no-op
+						_ = peers.HandleResignation(mac)
+					},
+					func(data []byte) ([]byte, error) {
+						return encryption.Encrypt(data, []byte(a.config.Key))
+					},
+					func(data []byte) ([]byte, error) {
+						return encryption.Decrypt(data, []byte(a.config.Key))
+					},
+				)
+
+				a.setSignaler(s)
+				defer func() {
+					a.setSignaler(nil)
+
+					// Ignore as this can be a no-op
+					_ = s.Close()
+				}()
+
+				a.onSignalerConnect(a.config.Raddr)
+
+				return s.Run()
+			}()
+
+			cancel()
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			a.onSignalerDisconnect(a.config.Raddr, err, sleep)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(sleep):
+			}
+		}
+	}()
+
+	if routes != nil {
+		// Advertise the addresses to all peers again periodically, which picks up addresses which have changed, i.e. once they have
+		// been assigned by a child command, and replaces advertisements which have been lost, i.e. with unreliable data channels
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ticker := time.NewTicker(a.config.Timeout)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+
+				a.advertise(peers, tap, broadcastMAC)
+			}
+		}()
+	}
+
+	if dhcpClient != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if err := dhcpClient.Run(ctx); err != nil {
+				fail(err)
+			}
+		}()
+	}
+
+	if autoconfigurator != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// Nodes which are the first in their community don't wait for peers forever
+			select {
+			case <-ctx.Done():
+				return
+			case <-peerConnected:
+			case <-time.After(a.config.Timeout):
+			}
+
+			if err := autoconfigurator.Run(ctx); err != nil {
+				fail(err)
+			}
+		}()
+	}
+
+	// Devices with multiple queues are read from in parallel, one reader for each queue
+	queues := 1
+	readQueue := func(queue int, p []byte) (int, error) {
+		return tap.Read(p)
+	}
+	if q, ok := tap.(interface {
+		GetQueues() int
+		ReadQueue(queue int, p []byte) (int, error)
+	}); ok {
+		queues = q.GetQueues()
+		readQueue = q.ReadQueue
+	}
+
+	for i := 0; i < queues; i++ {
+		wg.Add(1)
+		go func(queue int) {
+			defer wg.Done()
+
+			// Frames are copied by the manager before Write returns, so each reader can re-use its buffer
+			buf := make([]byte, readSize)
+			for {
+				n, err := readQueue(queue, buf)
+				if err != nil {
+					// Reading fails once the TAP device is closed during shutdown
+					if ctx.Err() == nil {
+						fail(err)
+					}
+
+					return
+				}
+
+				var destination string
+				if routes != nil {
+					address, err := transport.GetDestinationAddress(buf[:n])
+					if err != nil {
+						log.Println("could not parse packet, continuing:", err)
+
+						continue
+					}
+
+					// Packets for addresses no peer has advertised, i.e. broadcasts, are dropped instead of being flooded
+					peer, ok := routes.Lookup(address)
+					if !ok {
+						if a.config.Verbose {
+							log.Println("could not route packet, continuing:", config.ErrNoRoute, address)
+						}
+
+						continue
+					}
+
+					destination = peer
+				} else {
+					var parsedFrame ethernet.Frame
+					if err := parsedFrame.UnmarshalBinary(buf[:n]); err != nil {
+						log.Println("could not parse frame, continuing:", err)
+
+						continue
+					}
+
+					destination = parsedFrame.Destination.String()
+				}
+
+				if err := peers.Write(destination, buf[:n]); err != nil {
+					if a.config.Verbose {
+						log.Println("could not write to peer, continuing:", err)
+					}
+
+					continue
+				}
+			}
+		}(i)
+	}
+
+	select {
+	case <-ctx.Done():
+		err = nil
+	case err = <-fatal:
+	}
+
+	cancel()
+
+	// Ignore as this can be a no-op
+	if s := a.getSignaler(); s != nil {
+		_ = s.Close()
+	}
+
+	return err
+}
+
+// setAddress replaces the address leased from the source; an empty address removes it
+func (a *Agent) setAddress(tap adapter.Adapter, deviceName string, source string, address string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.addresses[source] == address {
+		return
+	}
+
+	if previous, ok := a.addresses[source]; ok {
+		// The device might have been closed already during shutdown
+		if err := a.removeAddress(tap, deviceName, previous); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Println("could not remove leased address, continuing:", err)
+		}
+	}
+
+	delete(a.addresses, source)
+
+	if address == "" {
+		return
+	}
+
+	log.Println("Assigning address", address, "leased from", source)
+
+	if err := a.addAddress(tap, deviceName, address); err != nil {
+		log.Println("could not assign leased address, continuing:", err)
+
+		return
+	}
+
+	a.addresses[source] = address
+}
+
+func (a *Agent) advertise(peers transport.Transport, tap adapter.Adapter, mac string) {
+	advertisement, err := transport.EncodeAdvertisement(getAddresses(tap))
+	if err != nil {
+		log.Println("could not encode address advertisement, continuing:", err)
+
+		return
+	}
+
+	if err := peers.Write(mac, advertisement); err != nil && a.config.Verbose {
+		log.Println("could not advertise addresses, continuing:", err)
+	}
+}
+
+// addAddress assigns an address to devices created by the agent (or the bridge they are attached to) using netlink and to devices which support it, i.e. the userspace network stack, directly
+func (a *Agent) addAddress(tap adapter.Adapter, deviceName string, address string) error {
+	if d, ok := tap.(interface {
+		AddAddress(address string) error
+	}); ok {
+		return d.AddAddress(address)
+	}
+
+	if a.config.Adapter != nil {
+		return config.ErrDeviceNotConfigurable
+	}
+
+	return adapter.ConfigureLink(deviceName, a.config.NetNS, adapter.LinkConfig{Addresses: []string{address}, Bridge: a.config.Link.Bridge})
+}
+
+func (a *Agent) removeAddress(tap adapter.Adapter, deviceName string, address string) error {
+	if d, ok := tap.(interface {
+		RemoveAddress(address string) error
+	}); ok {
+		return d.RemoveAddress(address)
+	}
+
+	if a.config.Adapter != nil {
+		return config.ErrDeviceNotConfigurable
+	}
+
+	return adapter.UnconfigureLink(deviceName, a.config.NetNS, adapter.LinkConfig{Addresses: []string{address}, Bridge: a.config.Link.Bridge})
+}
+
+func getAddresses(tap adapter.Adapter) []net.IP {
+	a, ok := tap.(interface {
+		GetAddresses() ([]net.IP, error)
+	})
+	if !ok {
+		return []net.IP{}
+	}
+
+	addresses, err := a.GetAddresses()
+	if err != nil {
+		return []net.IP{}
+	}
+
+	return addresses
+}
+
+func (a *Agent) dialSignaler(ctx context.Context, sleep time.Duration, fail func(error)) (*websocket.Conn, error) {
+	retryWithFingerprint := false
+	for {
+		client := &http.Client{Timeout: sleep}
+		if a.config.TLSFingerprint != "" || retryWithFingerprint || a.config.TLSInsecure {
+			httpTransport := http.DefaultTransport.(*http.Transport).Clone()
+			httpTransport.TLSClientConfig = encryption.GetInteractiveTLSConfig(
+				a.config.TLSInsecure,
+				a.config.TLSFingerprint,
+				a.config.TLSHosts,
+				a.config.Raddr,
+				fail,
+				a.onTLSMessage,
+				a.onTLSRead,
+			)
+			client.Transport = httpTransport
+		}
+
+		log.Println("Agent connecting to signaler", a.config.Raddr)
+
+		dialCtx, cancel := context.WithTimeout(ctx, sleep)
+		conn, _, err := websocket.Dial(dialCtx, a.config.Raddr, &websocket.DialOptions{HTTPClient: client})
+		cancel()
+		if err != nil {
+			if strings.Contains(err.Error(), "x509:") && !retryWithFingerprint {
+				retryWithFingerprint = true
+
+				continue
+			}
+
+			return nil, err
+		}
+
+		return conn, nil
+	}
+}
+
+func getICEServers(stunServers []string, turnServers []string) ([]webrtc.ICEServer, error) {
+	iceServers := []webrtc.ICEServer{}
+
+	for _, stunServer := range stunServers {
+		iceServers = append(iceServers, webrtc.ICEServer{
+			URLs: []string{stunServer},
+		})
+	}
+
+	for _, turnServer := range turnServers {
+		addrParts := strings.Split(turnServer, "@")
+		if len(addrParts) < 2 {
+			return nil, config.ErrInvalidTURNServerAddr
+		}
+
+		authParts := strings.Split(addrParts[0], ":")
+		if len(authParts) < 2 {
+			return nil, config.ErrMissingTURNCredentials
+		}
+
+		iceServers = append(iceServers, webrtc.ICEServer{
+			URLs:           []string{addrParts[1]},
+			Username:       authParts[0],
+			Credential:     authParts[1],
+			CredentialType: webrtc.ICECredentialTypePassword,
+		})
+	}
+
+	return iceServers, nil
+}
@@ -0,0 +1,826 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/ethernet"
+	"github.com/pion/webrtc/v3"
+	api "github.com/pojntfx/weron/pkg/api/websockets/v1"
+	"github.com/pojntfx/weron/pkg/signaling"
+	"github.com/pojntfx/weron/pkg/transport"
+	"nhooyr.io/websocket"
+	"nhooyr.io/websocket/wsjson"
+)
+
+const (
+	testKey       = "0123456789101112"
+	testCommunity = "test"
+	testTimeout   = time.Second * 10
+	testEtherType = ethernet.EtherType(0x88b5) // Local experimental EtherType
+	testFrameSize = 1514
+
+	testDHCPTimeout = time.Second * 20 // Messages sent before the peers have connected are only retransmitted after a few seconds
+)
+
+var (
+	testMACA = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x0a}
+	testMACB = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x0b}
+	testMACC = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x0c}
+)
+
+// testAdapter passes frames written by the agent to the test and frames sent by the test to the agent
+type testAdapter struct {
+	mac       net.HardwareAddr
+	inbound   chan []byte
+	outbound  chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+
+	addresses map[string]struct{}
+	removals  int
+	lock      sync.Mutex
+}
+
+func newTestAdapter(mac net.HardwareAddr) *testAdapter {
+	return &testAdapter{
+		mac:       mac,
+		inbound:   make(chan []byte),
+		outbound:  make(chan []byte, 1024),
+		done:      make(chan struct{}),
+		addresses: map[string]struct{}{},
+	}
+}
+
+func (a *testAdapter) Open() (string, error) {
+	return "test0", nil
+}
+
+func (a *testAdapter) Read(p []byte) (int, error) {
+	select {
+	case <-a.done:
+		return -1, net.ErrClosed
+	case frame := <-a.inbound:
+		return copy(p, frame), nil
+	}
+}
+
+func (a *testAdapter) Write(p []byte) (int, error) {
+	select {
+	case a.outbound <- append([]byte{}, p...):
+	default:
+	}
+
+	return len(p), nil
+}
+
+func (a *testAdapter) Close() error {
+	a.closeOnce.Do(func() {
+		close(a.done)
+	})
+
+	return nil
+}
+
+func (a *testAdapter) GetFrameSize() (int, error) {
+	return testFrameSize, nil
+}
+
+func (a *testAdapter) GetMACAddress() (net.HardwareAddr, error) {
+	return a.mac, nil
+}
+
+func (a *testAdapter) AddAddress(address string) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.addresses[address] = struct{}{}
+
+	return nil
+}
+
+func (a *testAdapter) RemoveAddress(address string) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	delete(a.addresses, address)
+	a.removals++
+
+	return nil
+}
+
+func (a *testAdapter) GetAddresses() ([]net.IP, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	addresses := []net.IP{}
+	for address := range a.addresses {
+		ip, _, err := net.ParseCIDR(address)
+		if err != nil {
+			return nil, err
+		}
+
+		addresses = append(addresses, ip)
+	}
+
+	return addresses, nil
+}
+
+// waitForAddress waits for an address in the network to be assigned to the adapter
+func (a *testAdapter) waitForAddress(t *testing.T, network *net.IPNet) string {
+	t.Helper()
+
+	deadline := time.Now().Add(testDHCPTimeout)
+	for time.Now().Before(deadline) {
+		a.lock.Lock()
+		for address := range a.addresses {
+			if ip, _, err := net.ParseCIDR(address); err == nil && network.Contains(ip) {
+				a.lock.Unlock()
+
+				return address
+			}
+		}
+		a.lock.Unlock()
+
+		time.Sleep(time.Millisecond * 50)
+	}
+
+	t.Fatalf("no address in %v was assigned", network)
+
+	return ""
+}
+
+func (a *testAdapter) getRemovals() int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return a.removals
+}
+
+func (a *testAdapter) send(t *testing.T, destination net.HardwareAddr, payload string) {
+	t.Helper()
+
+	frame, err := (&ethernet.Frame{
+		Destination: destination,
+		Source:      a.mac,
+		EtherType:   testEtherType,
+		Payload:     []byte(payload),
+	}).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a.sendFrame(t, frame)
+}
+
+func (a *testAdapter) sendFrame(t *testing.T, frame []byte) {
+	t.Helper()
+
+	select {
+	case a.inbound <- frame:
+	case <-time.After(testTimeout):
+		t.Fatal("agent didn't read frame")
+	}
+}
+
+// receive waits for a frame with the payload, skipping other frames
+func (a *testAdapter) receive(t *testing.T, payload string) {
+	t.Helper()
+
+	deadline := time.After(testTimeout)
+	for {
+		select {
+		case frame := <-a.outbound:
+			if bytes.Contains(frame, []byte(payload)) {
+				return
+			}
+		case <-deadline:
+			t.Fatalf("didn't receive frame with payload %q", payload)
+		}
+	}
+}
+
+func (a *testAdapter) receiveNothing(t *testing.T, payload string, wait time.Duration) {
+	t.Helper()
+
+	deadline := time.After(wait)
+	for {
+		select {
+		case frame := <-a.outbound:
+			if bytes.Contains(frame, []byte(payload)) {
+				t.Fatalf("received unexpected frame with payload %q", payload)
+			}
+		case <-deadline:
+			return
+		}
+	}
+}
+
+type testSignaler struct {
+	raddr    string
+	signaler *signaling.SignalingServer
+}
+
+func startTestSignaler(t *testing.T) *testSignaler {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	write := func(conn *websocket.Conn, v interface{}) error {
+		ctx, cancel := context.WithTimeout(ctx, testTimeout)
+		defer cancel()
+
+		return wsjson.Write(ctx, conn, v)
+	}
+
+	communities := signaling.NewCommunitiesManager(
+		nil,
+		0,
+		"",
+
+		func(mac string, conn *websocket.Conn) error {
+			return write(conn, api.NewIntroduction(mac))
+		},
+		func(mac string, exchange api.Exchange, conn *websocket.Conn) error {
+			return write(conn, exchange)
+		},
+		func(mac string, conn *websocket.Conn) error {
+			return write(conn, api.NewResignation(mac))
+		},
+	)
+	if err := communities.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	signaler := signaling.NewSignalingServer(
+		ctx,
+		testTimeout,
+
+		communities.HandleApplication,
+		func(community, mac string, conn *websocket.Conn) error {
+			return write(conn, api.NewRejection())
+		},
+		func(community, mac, address string, conn *websocket.Conn) error {
+			return write(conn, api.NewAcceptance(address))
+		},
+		communities.HandleExited,
+		communities.HandleReady,
+		communities.HandleExchange,
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(rw, r, nil)
+		if err != nil {
+			return
+		}
+
+		_ = signaler.HandleConn(conn)
+	}))
+	t.Cleanup(func() {
+		_ = signaler.Close()
+		srv.Close()
+	})
+
+	return &testSignaler{
+		raddr:    "ws" + strings.TrimPrefix(srv.URL, "http"),
+		signaler: signaler,
+	}
+}
+
+type testAgent struct {
+	*Agent
+
+	adapter           *testAdapter
+	connected         chan string
+	disconnected      chan string
+	signalerConnected chan struct{}
+	stats             chan transport.Stats
+
+	cancel func()
+	done   chan error
+}
+
+func startTestAgent(t *testing.T, raddr string, network *transport.MemoryNetwork, mac net.HardwareAddr, configure func(c *AgentConfig)) *testAgent {
+	t.Helper()
+
+	a := &testAgent{
+		adapter:           newTestAdapter(mac),
+		connected:         make(chan string, 64),
+		disconnected:      make(chan string, 64),
+		signalerConnected: make(chan struct{}, 64),
+		stats:             make(chan transport.Stats, 64),
+		done:              make(chan error, 1),
+	}
+
+	c := AgentConfig{
+		Raddr:     raddr,
+		Key:       testKey,
+		Community: testCommunity,
+		Adapter:   a.adapter,
+		Transport: func(
+			mac string,
+
+			onReceive func(mac string, frame []byte),
+			onOffer func(mac string, o webrtc.SessionDescription),
+			onAnswer func(mac string, o webrtc.SessionDescription),
+			onDataChannelOpen func(mac string),
+			onDataChannelClose func(mac string),
+		) transport.Transport {
+			return transport.NewMemoryManager(mac, network, time.Minute, onReceive, onOffer, onAnswer, onDataChannelOpen, onDataChannelClose)
+		},
+		TLSHosts: filepath.Join(t.TempDir(), "hosts"),
+		Timeout:  time.Millisecond * 100,
+		MACTTL:   time.Minute,
+		Queueing: transport.Queueing{
+			Length:        1024,
+			ReceiveLength: 1024,
+			Policy:        transport.DropTail,
+			HighWatermark: 1024 * 1024,
+			LowWatermark:  256 * 1024,
+		},
+	}
+	if configure != nil {
+		configure(&c)
+	}
+
+	a.Agent = NewAgent(
+		c,
+
+		func(deviceName string) error {
+			return nil
+		},
+		func(raddr string) {
+			a.signalerConnected <- struct{}{}
+		},
+		func(raddr string, err error, reconnectIn time.Duration) {},
+		func(mac string) {
+			// Hooks may query the agent, i.e. to show its peers
+			select {
+			case a.stats <- a.Agent.Stats():
+			default:
+			}
+
+			a.connected <- mac
+		},
+		func(mac string) {
+			a.disconnected <- mac
+		},
+		func(format string, v ...interface{}) {},
+		func(format string, v ...interface{}) (string, error) {
+			return "", nil
+		},
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	a.cancel = cancel
+
+	go func() {
+		a.done <- a.Run(ctx)
+	}()
+
+	t.Cleanup(a.stop)
+
+	return a
+}
+
+func (a *testAgent) stop() {
+	a.cancel()
+
+	select {
+	case <-a.done:
+		// Prevent a second stop from blocking
+		a.done <- nil
+	case <-time.After(testTimeout):
+	}
+}
+
+func (a *testAgent) waitForPeers(t *testing.T, macs ...net.HardwareAddr) {
+	t.Helper()
+
+	waitForMACs(t, a.connected, "connect", macs...)
+}
+
+func (a *testAgent) waitForDisconnects(t *testing.T, macs ...net.HardwareAddr) {
+	t.Helper()
+
+	waitForMACs(t, a.disconnected, "disconnect", macs...)
+}
+
+func waitForMACs(t *testing.T, events chan string, event string, macs ...net.HardwareAddr) {
+	t.Helper()
+
+	missing := map[string]struct{}{}
+	for _, mac := range macs {
+		missing[mac.String()] = struct{}{}
+	}
+
+	deadline := time.After(testTimeout)
+	for len(missing) > 0 {
+		select {
+		case mac := <-events:
+			delete(missing, mac)
+		case <-deadline:
+			t.Fatalf("peers %v didn't %v", missing, event)
+		}
+	}
+}
+
+func TestAgentsExchangeFrames(t *testing.T) {
+	s := startTestSignaler(t)
+	network := transport.NewMemoryNetwork()
+
+	a := startTestAgent(t, s.raddr, network, testMACA, nil)
+	b := startTestAgent(t, s.raddr, network, testMACB, nil)
+	c := startTestAgent(t, s.raddr, network, testMACC, nil)
+
+	a.waitForPeers(t, testMACB, testMACC)
+	b.waitForPeers(t, testMACA, testMACC)
+	c.waitForPeers(t, testMACA, testMACB)
+
+	// Unicast frames only reach their destination
+	a.adapter.send(t, testMACB, "unicast from a")
+	b.adapter.receive(t, "unicast from a")
+	c.adapter.receiveNothing(t, "unicast from a", time.Millisecond*200)
+
+	// Broadcast frames reach all peers
+	c.adapter.send(t, ethernet.Broadcast, "broadcast from c")
+	a.adapter.receive(t, "broadcast from c")
+	b.adapter.receive(t, "broadcast from c")
+
+	if peers := len(a.Stats().Peers); peers != 0 {
+		t.Fatalf("in-memory transport reported %v peers in stats, want none", peers)
+	}
+}
+
+func TestAgentStatsFromPeerConnectHook(t *testing.T) {
+	s := startTestSignaler(t)
+
+	// The WebRTC transport runs the hook, so the stats have to be collected without it holding its lock
+	useWebRTC := func(c *AgentConfig) {
+		c.Transport = nil
+	}
+
+	a := startTestAgent(t, s.raddr, nil, testMACA, useWebRTC)
+	b := startTestAgent(t, s.raddr, nil, testMACB, useWebRTC)
+
+	a.waitForPeers(t, testMACB)
+	b.waitForPeers(t, testMACA)
+
+	select {
+	case stats := <-a.stats:
+		if len(stats.Peers) != 1 || stats.Peers[0].MAC != testMACB.String() {
+			t.Fatalf("hook got stats for peers %v, want %v", stats.Peers, testMACB)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("hook didn't get any stats")
+	}
+
+	// The transport keeps working after the hook has queried it
+	a.adapter.send(t, testMACB, "unicast from a")
+	b.adapter.receive(t, "unicast from a")
+}
+
+func TestAgentPeerLeaves(t *testing.T) {
+	s := startTestSignaler(t)
+	network := transport.NewMemoryNetwork()
+
+	a := startTestAgent(t, s.raddr, network, testMACA, nil)
+	b := startTestAgent(t, s.raddr, network, testMACB, nil)
+	c := startTestAgent(t, s.raddr, network, testMACC, nil)
+
+	a.waitForPeers(t, testMACB, testMACC)
+	c.waitForPeers(t, testMACA, testMACB)
+
+	b.stop()
+
+	a.waitForDisconnects(t, testMACB)
+	c.waitForDisconnects(t, testMACB)
+
+	// The remaining peers are still connected
+	a.adapter.send(t, testMACC, "unicast from a")
+	c.adapter.receive(t, "unicast from a")
+
+	a.adapter.send(t, testMACB, "unicast to b")
+	b.adapter.receiveNothing(t, "unicast to b", time.Millisecond*200)
+}
+
+func waitForRoute(t *testing.T, a *testAgent, address string, want string) {
+	t.Helper()
+
+	deadline := time.After(testTimeout)
+	for {
+		if a.Status().Routes[address] == want {
+			return
+		}
+
+		select {
+		case <-deadline:
+			t.Fatalf("timed out waiting for route to %v via %q, have %v", address, want, a.Status().Routes)
+		case <-time.After(time.Millisecond * 10):
+		}
+	}
+}
+
+func TestAgentForgetsRoutesOfLeavingPeers(t *testing.T) {
+	s := startTestSignaler(t)
+	network := transport.NewMemoryNetwork()
+
+	routeIPs := func(c *AgentConfig) {
+		c.TUN = true
+	}
+
+	a := startTestAgent(t, s.raddr, network, testMACA, routeIPs)
+	b := startTestAgent(t, s.raddr, network, testMACB, routeIPs)
+
+	if err := b.adapter.AddAddress("10.0.0.2/24"); err != nil {
+		t.Fatal(err)
+	}
+
+	a.waitForPeers(t, testMACB)
+
+	// Addresses are advertised once the data channel has opened and periodically afterwards
+	waitForRoute(t, a, "10.0.0.2", testMACB.String())
+
+	b.stop()
+	a.waitForDisconnects(t, testMACB)
+
+	if routes := a.Status().Routes; len(routes) != 0 {
+		t.Fatalf("routes %v are left after their peer has left", routes)
+	}
+}
+
+func TestAgentReconnects(t *testing.T) {
+	s := startTestSignaler(t)
+	network := transport.NewMemoryNetwork()
+
+	a := startTestAgent(t, s.raddr, network, testMACA, nil)
+	b := startTestAgent(t, s.raddr, network, testMACB, nil)
+
+	a.waitForPeers(t, testMACB)
+	b.waitForPeers(t, testMACA)
+
+	for _, agent := range []*testAgent{a, b} {
+		<-agent.signalerConnected
+	}
+
+	// Peers stay connected while the agents reconnect to the signaler
+	_ = s.signaler.Close()
+
+	for _, agent := range []*testAgent{a, b} {
+		select {
+		case <-agent.signalerConnected:
+		case <-time.After(testTimeout):
+			t.Fatal("agent didn't reconnect to signaler")
+		}
+	}
+
+	select {
+	case mac := <-a.disconnected:
+		t.Fatalf("peer %v disconnected while reconnecting to the signaler", mac)
+	default:
+	}
+
+	a.adapter.send(t, testMACB, "after signaler reconnect")
+	b.adapter.receive(t, "after signaler reconnect")
+
+	// Peers which rejoin with the same MAC address are connected again
+	b.stop()
+	a.waitForDisconnects(t, testMACB)
+
+	rejoined := startTestAgent(t, s.raddr, network, testMACB, nil)
+
+	a.waitForPeers(t, testMACB)
+	rejoined.waitForPeers(t, testMACA)
+
+	a.adapter.send(t, testMACB, "after rejoin")
+	rejoined.adapter.receive(t, "after rejoin")
+
+	rejoined.adapter.send(t, testMACA, "reply after rejoin")
+	a.adapter.receive(t, "reply after rejoin")
+}
+
+const (
+	testDHCPTypeAck = 5
+	testDHCPTypeNak = 6
+)
+
+// newTestDHCPRequest creates a DHCPREQUEST for the address, i.e. one which another client has already leased
+func newTestDHCPRequest(t *testing.T, mac net.HardwareAddr, requested net.IP, server net.IP) []byte {
+	t.Helper()
+
+	message := make([]byte, 240)
+	message[0] = 1 // BOOTREQUEST
+	message[1] = 1 // Ethernet
+	message[2] = 6 // Length of the MAC address
+	binary.BigEndian.PutUint32(message[4:8], rand.Uint32())
+	binary.BigEndian.PutUint16(message[10:12], 0x8000) // Broadcast
+	copy(message[28:34], mac)
+	copy(message[236:240], []byte{99, 130, 83, 99})
+
+	message = append(message, 53, 1, 3) // DHCPREQUEST
+	message = append(message, 50, 4)
+	message = append(message, requested.To4()...)
+	message = append(message, 54, 4)
+	message = append(message, server.To4()...)
+	message = append(message, 255)
+
+	packet := make([]byte, 20+8+len(message))
+	packet[0] = 4<<4 | 5
+	binary.BigEndian.PutUint16(packet[2:4], uint16(len(packet)))
+	packet[8] = 64
+	packet[9] = 17 // UDP
+	copy(packet[12:16], net.IPv4zero.To4())
+	copy(packet[16:20], net.IPv4bcast.To4())
+
+	sum := uint32(0)
+	for i := 0; i < 20; i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(packet[i:]))
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	binary.BigEndian.PutUint16(packet[10:12], ^uint16(sum))
+
+	binary.BigEndian.PutUint16(packet[20:22], 68)
+	binary.BigEndian.PutUint16(packet[22:24], 67)
+	binary.BigEndian.PutUint16(packet[24:26], uint16(8+len(message)))
+	copy(packet[28:], message)
+
+	frame, err := (&ethernet.Frame{
+		Destination: ethernet.Broadcast,
+		Source:      mac,
+		EtherType:   ethernet.EtherTypeIPv4,
+		Payload:     packet,
+	}).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return frame
+}
+
+// getTestDHCPReply returns the message type of a DHCP reply to the MAC address in the frame
+func getTestDHCPReply(frame []byte, mac net.HardwareAddr) (byte, bool) {
+	var parsedFrame ethernet.Frame
+	if err := parsedFrame.UnmarshalBinary(frame); err != nil || parsedFrame.EtherType != ethernet.EtherTypeIPv4 {
+		return 0, false
+	}
+
+	packet := parsedFrame.Payload
+	if len(packet) < 20 || packet[9] != 17 {
+		return 0, false
+	}
+
+	datagram := packet[int(packet[0]&0x0f)*4:]
+	if len(datagram) < 8+240 || binary.BigEndian.Uint16(datagram[2:4]) != 68 {
+		return 0, false
+	}
+
+	message := datagram[8:]
+	if message[0] != 2 || !bytes.Equal(message[28:34], mac) {
+		return 0, false
+	}
+
+	for options := message[240:]; len(options) >= 2; options = options[2+int(options[1]):] {
+		if options[0] == 53 && options[1] == 1 && len(options) >= 3 {
+			return options[2], true
+		}
+
+		if len(options) < 2+int(options[1]) {
+			break
+		}
+	}
+
+	return 0, false
+}
+
+// countDHCPReplies counts the DHCP replies of the type which the adapter receives within the duration
+func (a *testAdapter) countDHCPReplies(messageType byte, wait time.Duration) int {
+	replies := 0
+
+	deadline := time.After(wait)
+	for {
+		select {
+		case frame := <-a.outbound:
+			if t, ok := getTestDHCPReply(frame, a.mac); ok && t == messageType {
+				replies++
+			}
+		case <-deadline:
+			return replies
+		}
+	}
+}
+
+type testDHCPCommunity struct {
+	signaler *testSignaler
+	network  *transport.MemoryNetwork
+	leased   *net.IPNet
+
+	server *testAgent
+	client *testAgent
+}
+
+func startTestDHCPCommunity(t *testing.T, leaseTime time.Duration) *testDHCPCommunity {
+	t.Helper()
+
+	_, leased, err := net.ParseCIDR("10.0.0.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &testDHCPCommunity{
+		signaler: startTestSignaler(t),
+		network:  transport.NewMemoryNetwork(),
+		leased:   leased,
+	}
+
+	c.server = startTestAgent(t, c.signaler.raddr, c.network, testMACA, func(c *AgentConfig) {
+		c.DHCPServer = "10.0.0.1/24"
+		c.DHCPLeaseTime = leaseTime
+	})
+	c.client = startTestAgent(t, c.signaler.raddr, c.network, testMACB, func(c *AgentConfig) {
+		c.DHCP = true
+	})
+
+	return c
+}
+
+func TestAgentLeasesAddressWithDHCP(t *testing.T) {
+	leaseTime := time.Minute
+
+	c := startTestDHCPCommunity(t, leaseTime)
+
+	// The client has been through DISCOVER, OFFER, REQUEST and ACK once its address is assigned
+	address := c.client.adapter.waitForAddress(t, c.leased)
+	if ip, _, _ := net.ParseCIDR(address); ip.Equal(net.ParseIP("10.0.0.1")) {
+		t.Fatalf("client was leased the server's address %v", address)
+	}
+
+	if status := c.client.Status(); len(status.Addresses) != 1 || status.Addresses[0] != address {
+		t.Fatalf("client status shows addresses %v, want %v", status.Addresses, address)
+	}
+
+	if serverAddress := c.server.adapter.waitForAddress(t, c.leased); serverAddress != "10.0.0.1/24" {
+		t.Fatalf("server has address %v, want 10.0.0.1/24", serverAddress)
+	}
+}
+
+func TestAgentRenewsDHCPLease(t *testing.T) {
+	leaseTime := time.Second * 2
+
+	c := startTestDHCPCommunity(t, leaseTime)
+	client := c.client
+
+	address := client.adapter.waitForAddress(t, c.leased)
+
+	// The lease is renewed after half of the lease time, so it outlives the lease time without being removed
+	if acks := client.adapter.countDHCPReplies(testDHCPTypeAck, leaseTime*3); acks < 3 {
+		t.Fatalf("client received %v ACKs within three lease times, want at least three for the lease and its renewals", acks)
+	}
+
+	if removals := client.adapter.getRemovals(); removals != 0 {
+		t.Fatalf("client removed addresses %v times while renewing its lease, want none", removals)
+	}
+
+	if renewed := client.adapter.waitForAddress(t, c.leased); renewed != address {
+		t.Fatalf("client has address %v after renewing, want %v", renewed, address)
+	}
+}
+
+func TestAgentRefusesTakenDHCPAddress(t *testing.T) {
+	c := startTestDHCPCommunity(t, time.Minute)
+	client := c.client
+
+	address := client.adapter.waitForAddress(t, c.leased)
+
+	taken, _, err := net.ParseCIDR(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another node requests the address which has already been leased to the client
+	other := startTestAgent(t, c.signaler.raddr, c.network, testMACC, nil)
+	other.waitForPeers(t, testMACA, testMACB)
+	c.server.waitForPeers(t, testMACC)
+
+	other.adapter.sendFrame(t, newTestDHCPRequest(t, testMACC, taken, net.ParseIP("10.0.0.1")))
+
+	if naks := other.adapter.countDHCPReplies(testDHCPTypeNak, time.Second); naks != 1 {
+		t.Fatalf("node received %v NAKs for a taken address, want one", naks)
+	}
+
+	if removals := client.adapter.getRemovals(); removals != 0 {
+		t.Fatalf("client removed addresses %v times after another node requested its address, want none", removals)
+	}
+}
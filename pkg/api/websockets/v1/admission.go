@@ -6,6 +6,11 @@ type Application struct {
 	Mac       string `json:"mac"`
 }
 
+type Acceptance struct {
+	Message
+	Address string `json:"address,omitempty"` // In CIDR notation; empty if the signaler doesn't manage addresses for the community
+}
+
 type Introduction struct {
 	Message
 	Mac string `json:"mac"`
@@ -19,8 +24,11 @@ func NewApplication(community, mac string) *Application {
 	}
 }
 
-func NewAcceptance() *Message {
-	return &Message{TypeAcceptance}
+func NewAcceptance(address string) *Acceptance {
+	return &Acceptance{
+		Message: Message{TypeAcceptance},
+		Address: address,
+	}
 }
 
 func NewRejection() *Message {
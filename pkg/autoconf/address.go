@@ -0,0 +1,64 @@
+package autoconf
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"net"
+)
+
+const (
+	IPv4PrefixLength = 16
+	IPv6PrefixLength = 64
+)
+
+// GetIPv4LinkLocalAddress derives an address between 169.254.1.0 and 169.254.254.255 (RFC 3927) from the MAC address; attempt selects another address after a conflict
+func GetIPv4LinkLocalAddress(mac net.HardwareAddr, attempt int) net.IP {
+	sum := hash(mac, uint32(attempt))
+
+	n := binary.BigEndian.Uint32(sum) % (254 * 256)
+
+	return net.IPv4(169, 254, byte(1+n/256), byte(n%256)).To4()
+}
+
+// GetIPv6Prefix derives a unique local /64 prefix (RFC 4193) from the community name, so that all nodes in a community share it
+func GetIPv6Prefix(community string) *net.IPNet {
+	sum := sha256.Sum256([]byte(community))
+
+	prefix := make(net.IP, net.IPv6len)
+	prefix[0] = 0xfd
+	copy(prefix[1:6], sum[:5])
+
+	return &net.IPNet{
+		IP:   prefix,
+		Mask: net.CIDRMask(IPv6PrefixLength, 8*net.IPv6len),
+	}
+}
+
+// GetIPv6Address derives the interface identifier from the MAC address using EUI-64 (RFC 4291); after a conflict, a stable hash (RFC 7217) is used instead
+func GetIPv6Address(prefix *net.IPNet, mac net.HardwareAddr, attempt int) net.IP {
+	address := make(net.IP, net.IPv6len)
+	copy(address, prefix.IP.To16()[:8])
+
+	if attempt == 0 && len(mac) == 6 {
+		copy(address[8:11], mac[:3])
+		address[8] ^= 0x02 // Flip the universal/local bit
+		address[11] = 0xff
+		address[12] = 0xfe
+		copy(address[13:], mac[3:])
+
+		return address
+	}
+
+	copy(address[8:], hash(append(prefix.IP.To16()[:8:8], mac...), uint32(attempt)))
+
+	return address
+}
+
+func hash(data []byte, attempt uint32) []byte {
+	counter := make([]byte, 4)
+	binary.BigEndian.PutUint32(counter, attempt)
+
+	sum := sha256.Sum256(append(append([]byte{}, data...), counter...))
+
+	return sum[:]
+}
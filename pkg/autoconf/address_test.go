@@ -0,0 +1,97 @@
+package autoconf
+
+import (
+	"net"
+	"testing"
+)
+
+var (
+	testMAC      = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x0a}
+	testOtherMAC = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x0b}
+)
+
+func TestGetIPv4LinkLocalAddress(t *testing.T) {
+	linkLocal := &net.IPNet{IP: net.IPv4(169, 254, 0, 0), Mask: net.CIDRMask(IPv4PrefixLength, 32)}
+
+	seen := map[string]bool{}
+	for _, mac := range []net.HardwareAddr{testMAC, testOtherMAC} {
+		for attempt := 0; attempt < 64; attempt++ {
+			address := GetIPv4LinkLocalAddress(mac, attempt)
+
+			if !address.Equal(GetIPv4LinkLocalAddress(mac, attempt)) {
+				t.Fatalf("address for %v in attempt %v isn't deterministic", mac, attempt)
+			}
+
+			// The first and last 256 addresses are reserved (RFC 3927)
+			if !linkLocal.Contains(address) || address[2] == 0 || address[2] == 255 {
+				t.Fatalf("address %v for %v in attempt %v is outside of 169.254.1.0-169.254.254.255", address, mac, attempt)
+			}
+
+			seen[address.String()] = true
+		}
+	}
+
+	// Nodes and attempts select addresses independently of each other, so only few of them may collide
+	if len(seen) < 120 {
+		t.Fatalf("got %v different addresses for 128 MACs and attempts, want at least 120", len(seen))
+	}
+}
+
+func TestGetIPv6Prefix(t *testing.T) {
+	prefix := GetIPv6Prefix("test")
+
+	if !prefix.IP.Equal(GetIPv6Prefix("test").IP) {
+		t.Fatal("prefix of the community isn't deterministic")
+	}
+
+	if prefix.IP[0] != 0xfd {
+		t.Fatalf("prefix %v isn't a unique local prefix", prefix)
+	}
+
+	if ones, bits := prefix.Mask.Size(); ones != IPv6PrefixLength || bits != 128 {
+		t.Fatalf("prefix %v has a length of %v, want %v", prefix, ones, IPv6PrefixLength)
+	}
+
+	if prefix.IP.Equal(GetIPv6Prefix("other").IP) {
+		t.Fatal("different communities share a prefix")
+	}
+}
+
+func TestGetIPv6Address(t *testing.T) {
+	_, prefix, err := net.ParseCIDR("fd00:1:2:3::/64")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		mac     net.HardwareAddr
+		attempt int
+		want    string
+	}{
+		{testMAC, 0, "fd00:1:2:3::ff:fe00:a"},                                                      // The universal/local bit is flipped
+		{net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}, 0, "fd00:1:2:3:211:22ff:fe33:4455"}, // Example from RFC 4291
+	} {
+		if got := GetIPv6Address(prefix, test.mac, test.attempt); !got.Equal(net.ParseIP(test.want)) {
+			t.Fatalf("address for %v in attempt %v is %v, want %v", test.mac, test.attempt, got, test.want)
+		}
+	}
+
+	// After a conflict, a stable address in the prefix which doesn't depend on the EUI-64 is used instead
+	seen := map[string]bool{}
+	for attempt := 0; attempt < 16; attempt++ {
+		address := GetIPv6Address(prefix, testMAC, attempt)
+
+		if !address.Equal(GetIPv6Address(prefix, testMAC, attempt)) {
+			t.Fatalf("address in attempt %v isn't deterministic", attempt)
+		}
+
+		if !prefix.Contains(address) {
+			t.Fatalf("address %v in attempt %v is outside of %v", address, attempt, prefix)
+		}
+
+		if seen[address.String()] {
+			t.Fatalf("address %v in attempt %v has been used in a previous attempt", address, attempt)
+		}
+		seen[address.String()] = true
+	}
+}
@@ -0,0 +1,335 @@
+package autoconf
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	probeCount       = 3
+	probeMinInterval = time.Second
+	probeMaxInterval = time.Second * 2
+	announceWait     = time.Second * 2
+	announceCount    = 2
+	announceInterval = time.Second * 2
+	maxConflicts     = 10               // Number of conflicts after which new addresses are only tried at a limited rate
+	rateLimit        = time.Second * 60 // Time to wait between addresses after too many conflicts
+	defendInterval   = time.Second * 10 // Minimum time between announcements which defend an address
+)
+
+type family struct {
+	getAddress   func(attempt int) net.IP
+	prefixLength int
+	bits         int
+	newProbe     func(address net.IP) ([]byte, error)
+	newAnnounce  func(address net.IP) ([]byte, error)
+}
+
+type lease struct {
+	address     net.IP
+	cidr        string
+	assigned    bool
+	conflict    chan struct{}
+	newAnnounce func(address net.IP) ([]byte, error)
+	defended    time.Time
+}
+
+type Autoconfigurator struct {
+	mac    net.HardwareAddr
+	prefix *net.IPNet
+
+	leases map[string]*lease
+
+	lock sync.Mutex
+
+	onSend        func(mac string, frame []byte) error
+	onAssign      func(address string) error
+	onUnassign    func(address string) error
+	onConflict    func(address string, mac string)
+	onSendFailure func(err error)
+}
+
+func NewAutoconfigurator(
+	mac net.HardwareAddr,
+	prefix *net.IPNet,
+
+	onSend func(mac string, frame []byte) error,
+	onAssign func(address string) error,
+	onUnassign func(address string) error,
+	onConflict func(address string, mac string),
+	onSendFailure func(err error),
+) *Autoconfigurator {
+	return &Autoconfigurator{
+		mac:    mac,
+		prefix: prefix,
+
+		leases: map[string]*lease{},
+
+		onSend:        onSend,
+		onAssign:      onAssign,
+		onUnassign:    onUnassign,
+		onConflict:    onConflict,
+		onSendFailure: onSendFailure,
+	}
+}
+
+// Run assigns an IPv4 link-local and an IPv6 address and replaces them if they conflict with another node's; it blocks until the context is cancelled
+func (a *Autoconfigurator) Run(ctx context.Context) error {
+	families := []family{
+		{
+			getAddress: func(attempt int) net.IP {
+				return GetIPv4LinkLocalAddress(a.mac, attempt)
+			},
+			prefixLength: IPv4PrefixLength,
+			bits:         8 * net.IPv4len,
+			newProbe: func(address net.IP) ([]byte, error) {
+				return newARP(a.mac, net.IPv4zero, address)
+			},
+			newAnnounce: func(address net.IP) ([]byte, error) {
+				return newARP(a.mac, address, address)
+			},
+		},
+		{
+			getAddress: func(attempt int) net.IP {
+				return GetIPv6Address(a.prefix, a.mac, attempt)
+			},
+			prefixLength: IPv6PrefixLength,
+			bits:         8 * net.IPv6len,
+			newProbe: func(address net.IP) ([]byte, error) {
+				return newNeighborSolicitation(a.mac, address)
+			},
+			newAnnounce: func(address net.IP) ([]byte, error) {
+				return newNeighborAdvertisement(a.mac, address)
+			},
+		},
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(families))
+	for _, f := range families {
+		wg.Add(1)
+		go func(f family) {
+			defer wg.Done()
+
+			errs <- a.run(ctx, f)
+		}(f)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *Autoconfigurator) run(ctx context.Context, f family) error {
+	for attempt := 0; ; attempt++ {
+		// Limit the rate of attempts if many nodes are fighting for the same addresses
+		if attempt >= maxConflicts && !sleep(ctx, rateLimit) {
+			return nil
+		}
+
+		address := f.getAddress(attempt)
+		cidr := (&net.IPNet{IP: address, Mask: net.CIDRMask(f.prefixLength, f.bits)}).String()
+
+		l := &lease{
+			address:     address,
+			cidr:        cidr,
+			conflict:    make(chan struct{}),
+			newAnnounce: f.newAnnounce,
+		}
+
+		a.lock.Lock()
+		a.leases[address.String()] = l
+		a.lock.Unlock()
+
+		if !a.probe(ctx, l, f) {
+			a.release(l)
+
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			continue
+		}
+
+		if err := a.onAssign(cidr); err != nil {
+			a.release(l)
+
+			return err
+		}
+
+		a.lock.Lock()
+		l.assigned = true
+		a.lock.Unlock()
+
+		// Announce the address so that peers update their neighbor caches
+		for i := 0; i < announceCount; i++ {
+			a.send(f.newAnnounce, address)
+
+			if !a.wait(ctx, l, announceInterval) {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-l.conflict:
+		}
+
+		a.release(l)
+
+		if err := a.onUnassign(cidr); err != nil {
+			return err
+		}
+
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+// probe checks whether another node is using or probing for the address (RFC 5227 and RFC 4862)
+func (a *Autoconfigurator) probe(ctx context.Context, l *lease, f family) bool {
+	// Desynchronize nodes which start at the same time
+	if !a.wait(ctx, l, time.Duration(rand.Int63n(int64(probeMinInterval)))) {
+		return false
+	}
+
+	for i := 0; i < probeCount; i++ {
+		a.send(f.newProbe, l.address)
+
+		interval := probeMinInterval + time.Duration(rand.Int63n(int64(probeMaxInterval-probeMinInterval)))
+		if i == probeCount-1 {
+			interval = announceWait
+		}
+
+		if !a.wait(ctx, l, interval) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (a *Autoconfigurator) send(newFrame func(address net.IP) ([]byte, error), address net.IP) {
+	frame, err := newFrame(address)
+	if err != nil {
+		a.onSendFailure(err)
+
+		return
+	}
+
+	// Probes and announcements are broadcast or multicast, so they are flooded to all peers
+	var parsedDestination net.HardwareAddr = frame[:6]
+	if err := a.onSend(parsedDestination.String(), frame); err != nil {
+		a.onSendFailure(err)
+	}
+}
+
+// wait returns false if a conflict has been detected or the context has been cancelled
+func (a *Autoconfigurator) wait(ctx context.Context, l *lease, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-l.conflict:
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+func (a *Autoconfigurator) release(l *lease) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.leases[l.address.String()] == l {
+		delete(a.leases, l.address.String())
+	}
+}
+
+// Receive checks frames received from peers for ARP and NDP packets which conflict with our addresses
+func (a *Autoconfigurator) Receive(frame []byte) {
+	c, ok := parseClaim(frame)
+	if !ok || bytes.Equal(c.mac, a.mac) {
+		return
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	l, ok := a.leases[c.address.String()]
+	if !ok {
+		return
+	}
+
+	if l.assigned {
+		// The kernel answers probes for addresses which are already assigned
+		if c.probe {
+			return
+		}
+
+		// If two nodes have assigned the same address, i.e. after two partitions of the overlay have merged, the node with the lower MAC address keeps it
+		if bytes.Compare(a.mac, c.mac) < 0 {
+			if time.Since(l.defended) > defendInterval {
+				l.defended = time.Now()
+
+				go a.send(l.newAnnounce, l.address)
+			}
+
+			return
+		}
+	}
+
+	select {
+	case <-l.conflict:
+		return
+	default:
+	}
+
+	close(l.conflict)
+
+	a.onConflict(l.address.String(), c.mac.String())
+}
+
+// Addresses returns the addresses which have been assigned
+func (a *Autoconfigurator) Addresses() []string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	addresses := []string{}
+	for _, l := range a.leases {
+		if l.assigned {
+			addresses = append(addresses, l.cidr)
+		}
+	}
+
+	sort.Strings(addresses)
+
+	return addresses
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -0,0 +1,228 @@
+package autoconf
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+const testTimeout = time.Second * 10
+
+type conflict struct {
+	address string
+	mac     string
+}
+
+type testAutoconfigurator struct {
+	*Autoconfigurator
+
+	probes    chan *claim
+	conflicts chan conflict
+}
+
+func startTestAutoconfigurator(t *testing.T) *testAutoconfigurator {
+	t.Helper()
+
+	a := &testAutoconfigurator{
+		probes:    make(chan *claim, 64),
+		conflicts: make(chan conflict, 64),
+	}
+
+	a.Autoconfigurator = NewAutoconfigurator(
+		testMAC,
+		GetIPv6Prefix("test"),
+
+		func(mac string, frame []byte) error {
+			if c, ok := parseClaim(frame); ok && c.probe {
+				a.probes <- c
+			}
+
+			return nil
+		},
+		func(address string) error {
+			return nil
+		},
+		func(address string) error {
+			return nil
+		},
+		func(address string, mac string) {
+			a.conflicts <- conflict{address, mac}
+		},
+		func(err error) {
+			t.Error(err)
+		},
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		if err := a.Run(ctx); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return a
+}
+
+// waitForProbe waits for a probe for the address, ignoring probes for other addresses
+func (a *testAutoconfigurator) waitForProbe(t *testing.T, address net.IP) {
+	t.Helper()
+
+	deadline := time.After(testTimeout)
+	for {
+		select {
+		case c := <-a.probes:
+			if c.address.Equal(address) {
+				return
+			}
+		case <-deadline:
+			t.Fatalf("timed out waiting for a probe for %v", address)
+		}
+	}
+}
+
+func TestAutoconfiguratorChoosesNewAddressOnConflict(t *testing.T) {
+	ipv4 := func(attempt int) net.IP {
+		return GetIPv4LinkLocalAddress(testMAC, attempt)
+	}
+	ipv6 := func(attempt int) net.IP {
+		return GetIPv6Address(GetIPv6Prefix("test"), testMAC, attempt)
+	}
+
+	for _, test := range []struct {
+		name       string
+		getAddress func(attempt int) net.IP
+		newReply   func(mac net.HardwareAddr, address net.IP) ([]byte, error)
+	}{
+		{"arp announcement", ipv4, func(mac net.HardwareAddr, address net.IP) ([]byte, error) {
+			return newARP(mac, address, address)
+		}},
+		{"arp probe", ipv4, func(mac net.HardwareAddr, address net.IP) ([]byte, error) {
+			return newARP(mac, net.IPv4zero, address)
+		}},
+		{"ndp advertisement", ipv6, newNeighborAdvertisement},
+		{"ndp solicitation", ipv6, newNeighborSolicitation},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			a := startTestAutoconfigurator(t)
+
+			first := test.getAddress(0)
+			a.waitForProbe(t, first)
+
+			// Our own frames are flooded back to us, i.e. by bridges, so they must not cause conflicts
+			own, err := test.newReply(testMAC, first)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a.Receive(own)
+
+			select {
+			case c := <-a.conflicts:
+				t.Fatalf("got conflict for %v with our own frame from %v", c.address, c.mac)
+			default:
+			}
+
+			reply, err := test.newReply(testOtherMAC, first)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a.Receive(reply)
+
+			select {
+			case c := <-a.conflicts:
+				if c.address != first.String() || c.mac != testOtherMAC.String() {
+					t.Fatalf("got conflict for %v with %v, want %v with %v", c.address, c.mac, first, testOtherMAC)
+				}
+			case <-time.After(testTimeout):
+				t.Fatal("timed out waiting for a conflict")
+			}
+
+			// The next address is probed for instead
+			a.waitForProbe(t, test.getAddress(1))
+
+			if addresses := a.Addresses(); len(addresses) != 0 {
+				t.Fatalf("addresses %v have been assigned while probing", addresses)
+			}
+		})
+	}
+}
+
+func TestAutoconfiguratorDefendsAssignedAddress(t *testing.T) {
+	address := GetIPv4LinkLocalAddress(testMAC, 0)
+
+	for _, test := range []struct {
+		name         string
+		mac          net.HardwareAddr
+		sender       net.IP
+		wantConflict bool
+	}{
+		{"probe", net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01}, net.IPv4zero, false}, // The kernel answers probes for assigned addresses
+		{"higher mac", testOtherMAC, address, false},                             // The node with the lower MAC address keeps the address
+		{"lower mac", net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01}, address, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			conflicts := 0
+			a := NewAutoconfigurator(
+				testMAC,
+				GetIPv6Prefix("test"),
+
+				func(mac string, frame []byte) error {
+					return nil
+				},
+				func(address string) error {
+					return nil
+				},
+				func(address string) error {
+					return nil
+				},
+				func(address string, mac string) {
+					conflicts++
+				},
+				func(err error) {
+					t.Error(err)
+				},
+			)
+
+			l := &lease{
+				address:  address,
+				cidr:     (&net.IPNet{IP: address, Mask: net.CIDRMask(IPv4PrefixLength, 32)}).String(),
+				assigned: true,
+				conflict: make(chan struct{}),
+				newAnnounce: func(address net.IP) ([]byte, error) {
+					return newARP(testMAC, address, address)
+				},
+			}
+			a.leases[address.String()] = l
+
+			frame, err := newARP(test.mac, test.sender, address)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			a.Receive(frame)
+
+			if got := conflicts > 0; got != test.wantConflict {
+				t.Fatalf("got conflict %v, want %v", got, test.wantConflict)
+			}
+
+			select {
+			case <-l.conflict:
+				if !test.wantConflict {
+					t.Fatal("lease was given up")
+				}
+			default:
+				if test.wantConflict {
+					t.Fatal("lease wasn't given up")
+				}
+			}
+		})
+	}
+}
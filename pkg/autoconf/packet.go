@@ -0,0 +1,184 @@
+package autoconf
+
+import (
+	"encoding/binary"
+	"net"
+
+	"github.com/mdlayher/ethernet"
+)
+
+const (
+	arpLength = 28
+
+	ipv6HeaderLength         = 40
+	icmpv6Protocol           = 58
+	ndpHopLimit              = 255
+	ndpLength                = 24
+	ndpNeighborSolicitation  = 135
+	ndpNeighborAdvertisement = 136
+	ndpOverrideFlag          = 0x20
+	ndpTargetLinkLayerOption = 2
+)
+
+var (
+	broadcastMAC = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	allNodesMAC  = net.HardwareAddr{0x33, 0x33, 0x00, 0x00, 0x00, 0x01}
+	allNodes     = net.ParseIP("ff02::1")
+)
+
+type claim struct {
+	mac     net.HardwareAddr
+	address net.IP
+	probe   bool // Probes only ask for the address, while announcements and replies claim it
+}
+
+// parseClaim returns the address an ARP or NDP packet in the frame asks for or claims
+func parseClaim(frame []byte) (*claim, bool) {
+	var parsedFrame ethernet.Frame
+	if err := parsedFrame.UnmarshalBinary(frame); err != nil {
+		return nil, false
+	}
+
+	payload := parsedFrame.Payload
+
+	switch parsedFrame.EtherType {
+	case ethernet.EtherTypeARP:
+		if len(payload) < arpLength || binary.BigEndian.Uint16(payload[2:4]) != uint16(ethernet.EtherTypeIPv4) || payload[4] != 6 || payload[5] != 4 {
+			return nil, false
+		}
+
+		mac := net.HardwareAddr(append([]byte{}, payload[8:14]...))
+		sender := net.IP(append([]byte{}, payload[14:18]...))
+		target := net.IP(append([]byte{}, payload[24:28]...))
+
+		// Probes are sent from the unspecified address
+		if sender.IsUnspecified() {
+			return &claim{mac, target, true}, true
+		}
+
+		return &claim{mac, sender, false}, true
+	case ethernet.EtherTypeIPv6:
+		if len(payload) < ipv6HeaderLength+ndpLength || payload[6] != icmpv6Protocol {
+			return nil, false
+		}
+
+		source := net.IP(payload[8:24])
+		icmp := payload[ipv6HeaderLength:]
+		target := net.IP(append([]byte{}, icmp[8:24]...))
+
+		switch icmp[0] {
+		case ndpNeighborSolicitation:
+			// Only solicitations for duplicate address detection are sent from the unspecified address
+			if !source.IsUnspecified() {
+				return nil, false
+			}
+
+			return &claim{parsedFrame.Source, target, true}, true
+		case ndpNeighborAdvertisement:
+			return &claim{parsedFrame.Source, target, false}, true
+		}
+	}
+
+	return nil, false
+}
+
+// newARP creates a probe if sender is the unspecified address and an announcement if sender and target are the same (RFC 5227)
+func newARP(mac net.HardwareAddr, sender net.IP, target net.IP) ([]byte, error) {
+	payload := make([]byte, arpLength)
+	binary.BigEndian.PutUint16(payload[0:2], 1) // Ethernet
+	binary.BigEndian.PutUint16(payload[2:4], uint16(ethernet.EtherTypeIPv4))
+	payload[4] = 6
+	payload[5] = 4
+	binary.BigEndian.PutUint16(payload[6:8], 1) // Request
+	copy(payload[8:14], mac)
+	copy(payload[14:18], sender.To4())
+	copy(payload[24:28], target.To4())
+
+	frame := ethernet.Frame{
+		Destination: broadcastMAC,
+		Source:      mac,
+		EtherType:   ethernet.EtherTypeARP,
+		Payload:     payload,
+	}
+
+	return frame.MarshalBinary()
+}
+
+// newNeighborSolicitation creates a probe for duplicate address detection (RFC 4862)
+func newNeighborSolicitation(mac net.HardwareAddr, target net.IP) ([]byte, error) {
+	icmp := make([]byte, ndpLength)
+	icmp[0] = ndpNeighborSolicitation
+	copy(icmp[8:], target.To16())
+
+	// Solicitations are sent to the solicited-node multicast address of the target
+	destination := net.ParseIP("ff02::1:ff00:0")
+	copy(destination[13:], target.To16()[13:])
+
+	return newIPv6(
+		mac,
+		net.HardwareAddr{0x33, 0x33, destination[12], destination[13], destination[14], destination[15]},
+		net.IPv6unspecified,
+		destination,
+		icmp,
+	)
+}
+
+// newNeighborAdvertisement creates an unsolicited advertisement which announces the address to all nodes
+func newNeighborAdvertisement(mac net.HardwareAddr, target net.IP) ([]byte, error) {
+	icmp := make([]byte, ndpLength+8)
+	icmp[0] = ndpNeighborAdvertisement
+	icmp[4] = ndpOverrideFlag
+	copy(icmp[8:], target.To16())
+	icmp[ndpLength] = ndpTargetLinkLayerOption
+	icmp[ndpLength+1] = 1 // In units of 8 bytes
+	copy(icmp[ndpLength+2:], mac)
+
+	return newIPv6(mac, allNodesMAC, target, allNodes, icmp)
+}
+
+func newIPv6(sourceMAC net.HardwareAddr, destinationMAC net.HardwareAddr, source net.IP, destination net.IP, icmp []byte) ([]byte, error) {
+	packet := make([]byte, ipv6HeaderLength+len(icmp))
+	packet[0] = 6 << 4
+	binary.BigEndian.PutUint16(packet[4:6], uint16(len(icmp)))
+	packet[6] = icmpv6Protocol
+	packet[7] = ndpHopLimit
+	copy(packet[8:24], source.To16())
+	copy(packet[24:40], destination.To16())
+	copy(packet[ipv6HeaderLength:], icmp)
+
+	binary.BigEndian.PutUint16(packet[ipv6HeaderLength+2:], getICMPv6Checksum(packet[8:24], packet[24:40], packet[ipv6HeaderLength:]))
+
+	frame := ethernet.Frame{
+		Destination: destinationMAC,
+		Source:      sourceMAC,
+		EtherType:   ethernet.EtherTypeIPv6,
+		Payload:     packet,
+	}
+
+	return frame.MarshalBinary()
+}
+
+func getICMPv6Checksum(source []byte, destination []byte, icmp []byte) uint16 {
+	// The checksum covers a pseudo header with the addresses, the length and the protocol
+	pseudo := make([]byte, 0, 40+len(icmp)+1)
+	pseudo = append(pseudo, source...)
+	pseudo = append(pseudo, destination...)
+	pseudo = binary.BigEndian.AppendUint32(pseudo, uint32(len(icmp)))
+	pseudo = append(pseudo, 0, 0, 0, icmpv6Protocol)
+	pseudo = append(pseudo, icmp...)
+
+	if len(pseudo)%2 == 1 {
+		pseudo = append(pseudo, 0)
+	}
+
+	sum := uint32(0)
+	for i := 0; i < len(pseudo); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(pseudo[i:]))
+	}
+
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+
+	return ^uint16(sum)
+}
@@ -21,4 +21,54 @@ var (
 	ErrCouldNotGetUserInput         = errors.New("could not get user input")
 	ErrKnownHostsSyntax             = errors.New("syntax error in known hosts")
 	ErrAlreadyOpened                = errors.New("already opened")
+	ErrConflictingReliability       = errors.New("cannot limit both retransmits and packet lifetime")
+	ErrInvalidQueueLength           = errors.New("queue length must be at least one")
+	ErrInvalidQueueCount            = errors.New("number of queues can't be negative")
+	ErrInvalidDropPolicy            = errors.New("invalid drop policy")
+	ErrInvalidWatermarks            = errors.New("low watermark can't be higher than high watermark")
+	ErrInvalidBatchSize             = errors.New("invalid batch size")
+	ErrInvalidBatch                 = errors.New("invalid batch")
+	ErrUnknownCompression           = errors.New("unknown compression")
+	ErrInvalidCompressedFrame       = errors.New("invalid compressed frame")
+	ErrCiphertextTooShort           = errors.New("ciphertext too short")
+	ErrControlSocketInUse           = errors.New("control socket is already in use by another agent")
+	ErrUnexpectedControlResponse    = errors.New("unexpected response from control socket")
+	ErrMissingControlSocket         = errors.New("missing control socket path")
+	ErrInvalidKey                   = errors.New("key is not 16, 24 or 32 characters long")
+	ErrInvalidCommunity             = errors.New("invalid community name")
+	ErrInvalidTURNServerAddr        = errors.New("invalid TURN server address")
+	ErrMissingTURNCredentials       = errors.New("missing TURN server credentials")
+	ErrNotConnectedToSignaler       = errors.New("not connected to signaler")
+	ErrInvalidAddress               = errors.New("invalid address")
+	ErrUnsupportedNetwork           = errors.New("unsupported network")
+	ErrInvalidForward               = errors.New("invalid forward")
+	ErrInvalidAdvertisement         = errors.New("invalid address advertisement")
+	ErrInvalidPacket                = errors.New("invalid IP packet")
+	ErrNoRoute                      = errors.New("no route to address")
+	ErrInvalidRoute                 = errors.New("invalid route")
+	ErrInvalidMTU                   = errors.New("invalid MTU")
+	ErrUnsupportedPlatform          = errors.New("not supported on this platform")
+	ErrAutoconfigurationRequiresTAP = errors.New("address autoconfiguration requires a TAP device")
+	ErrNetworkExhausted             = errors.New("no more addresses available in the community's network")
+	ErrCouldNotReadLeases           = errors.New("could not read leases file")
+	ErrInvalidCommunityNetwork      = errors.New("invalid community network")
+	ErrDeviceNotConfigurable        = errors.New("device can't be configured by the agent")
+	ErrInvalidDHCPMessage           = errors.New("invalid DHCP message")
+	ErrDHCPRequiresEthernet         = errors.New("DHCP requires a TAP device or the userspace network stack")
+	ErrInvalidDHCPServerAddress     = errors.New("invalid DHCP server address")
+	ErrInvalidDHCPLeaseTime         = errors.New("DHCP lease time must be at least one second")
+	ErrNotABridge                   = errors.New("device is not a bridge")
+	ErrBridgeRequiresTAP            = errors.New("only TAP devices can be attached to a bridge")
+	ErrInvalidVLANID                = errors.New("VLAN ID must be between 1 and 4094")
+	ErrOffloadRequiresTAP           = errors.New("offload requires a TAP device")
+	ErrConflictingDeviceTypes       = errors.New("the userspace network stack can't be combined with a TUN device")
+	ErrProxyRequiresUserspace       = errors.New("SOCKS5 proxy, forwards and exposes require the userspace network stack")
+	ErrRoutesRequireDevice          = errors.New("routes require a TAP or TUN device")
+	ErrNetNSRequiresDevice          = errors.New("network namespaces require a TAP or TUN device")
+	ErrInvalidMaxRetransmits        = errors.New("max retransmits must be less than 65536")
+	ErrInvalidMaxPacketLifetime     = errors.New("max packet lifetime must be less than 65536ms")
+	ErrDeviceInUse                  = errors.New("device can't be used by more than one community")
+	ErrConflictingTrunk             = errors.New("trunk device can't be combined with TUN devices, the userspace network stack, address autoconfiguration, DHCP or offload")
+	ErrVLANInUse                    = errors.New("VLAN can't be used by more than one community")
+	ErrVLANRequiresTrunk            = errors.New("VLAN requires a trunk device")
 )
@@ -0,0 +1,70 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pojntfx/weron/pkg/config"
+)
+
+type ControlClient struct {
+	client *http.Client
+}
+
+func NewControlClient(path string) *ControlClient {
+	httpTransport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			var dialer net.Dialer
+
+			return dialer.DialContext(ctx, "unix", path)
+		},
+	}
+
+	return &ControlClient{
+		client: &http.Client{Transport: httpTransport},
+	}
+}
+
+// Status returns the status of each community the agent has joined
+func (c *ControlClient) Status(ctx context.Context) ([]Status, error) {
+	status := []Status{}
+	if err := c.get(ctx, statusPath, &status); err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// Peers returns the peers of each community the agent has joined
+func (c *ControlClient) Peers(ctx context.Context) ([]Peers, error) {
+	peers := []Peers{}
+	if err := c.get(ctx, peersPath, &peers); err != nil {
+		return nil, err
+	}
+
+	return peers, nil
+}
+
+func (c *ControlClient) get(ctx context.Context, path string, v interface{}) error {
+	// The host is ignored as requests are always sent to the socket
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://weron"+path, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %v", config.ErrUnexpectedControlResponse, strings.TrimSpace(res.Status))
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
@@ -0,0 +1,26 @@
+package control
+
+import "github.com/pojntfx/weron/pkg/transport"
+
+const (
+	statusPath = "/status"
+	peersPath  = "/peers"
+)
+
+type Status struct {
+	MAC               string `json:"mac"`
+	DeviceName        string `json:"deviceName"`
+	Community         string `json:"community"`
+	Signaler          string `json:"signaler"`
+	SignalerConnected bool   `json:"signalerConnected"`
+
+	Addresses []string          `json:"addresses,omitempty"` // Addresses which have been assigned by autoconfiguration or leased from the signaler
+	Routes    map[string]string `json:"routes,omitempty"`    // Addresses advertised by peers in layer 3 mode, mapped to their MACs
+}
+
+// Peers are the peers of one community
+type Peers struct {
+	Community string `json:"community"`
+
+	transport.Stats
+}
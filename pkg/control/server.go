@@ -0,0 +1,170 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pojntfx/weron/pkg/config"
+)
+
+type ControlServer struct {
+	path string
+
+	listener net.Listener
+	server   *http.Server
+
+	lock sync.Mutex
+
+	onStatus func() []Status
+	onPeers  func() []Peers
+}
+
+func NewControlServer(
+	path string,
+
+	onStatus func() []Status,
+	onPeers func() []Peers,
+) *ControlServer {
+	return &ControlServer{
+		path: path,
+
+		onStatus: onStatus,
+		onPeers:  onPeers,
+	}
+}
+
+func (s *ControlServer) Open() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.listener != nil {
+		return config.ErrAlreadyOpened
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), os.ModePerm); err != nil {
+		return err
+	}
+
+	// Sockets of agents which didn't shut down cleanly are left behind, but they can't be connected to
+	if conn, err := net.DialTimeout("unix", s.path, time.Second); err == nil {
+		_ = conn.Close()
+
+		return config.ErrControlSocketInUse
+	}
+
+	if err := os.Remove(s.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	listener, err := s.listen()
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(statusPath, func(rw http.ResponseWriter, r *http.Request) {
+		s.respond(rw, r, s.onStatus())
+	})
+	mux.HandleFunc(peersPath, func(rw http.ResponseWriter, r *http.Request) {
+		s.respond(rw, r, s.onPeers())
+	})
+
+	s.listener = listener
+	s.server = &http.Server{Handler: mux}
+
+	return nil
+}
+
+func (s *ControlServer) listen() (net.Listener, error) {
+	// The peer table contains addresses of other members of the community, so only the owner may read it. The socket is
+	// created in a directory only the owner can access and moved into place once its permissions have been restricted,
+	// so that nobody can connect to it in between.
+	dir, err := os.MkdirTemp(filepath.Dir(s.path), ".weron")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "s")
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	// The socket is removed from its final path on close
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+
+	if err := os.Chmod(path, 0600); err != nil {
+		_ = listener.Close()
+
+		return nil, err
+	}
+
+	if err := os.Rename(path, s.path); err != nil {
+		_ = listener.Close()
+
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+func (s *ControlServer) respond(rw http.ResponseWriter, r *http.Request, v interface{}) {
+	if r.Method != http.MethodGet {
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(rw).Encode(v); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (s *ControlServer) Serve() error {
+	s.lock.Lock()
+	server, listener := s.server, s.listener
+	s.lock.Unlock()
+
+	if server == nil {
+		return net.ErrClosed
+	}
+
+	if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
+
+func (s *ControlServer) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.server == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	err := s.server.Shutdown(ctx)
+
+	s.server = nil
+	s.listener = nil
+
+	// Ignore as the listener might have removed the socket already
+	_ = os.Remove(s.path)
+
+	return err
+}
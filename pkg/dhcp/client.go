@@ -0,0 +1,265 @@
+package dhcp
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	minRetransmit = time.Second * 4  // Initial time to wait for a reply before retransmitting (RFC 2131)
+	maxRetransmit = time.Second * 64 // Maximum time to wait for a reply before retransmitting (RFC 2131)
+	minRenew      = time.Minute      // Minimum time to wait between renewals if the server sends a short lease time
+)
+
+type Client struct {
+	mac net.HardwareAddr
+
+	xid     uint32
+	types   []byte
+	replies chan *message
+
+	lock sync.Mutex
+
+	onSend        func(mac string, frame []byte) error
+	onAssign      func(address string) error
+	onUnassign    func(address string) error
+	onSendFailure func(err error)
+}
+
+func NewClient(
+	mac net.HardwareAddr,
+
+	onSend func(mac string, frame []byte) error,
+	onAssign func(address string) error,
+	onUnassign func(address string) error,
+	onSendFailure func(err error),
+) *Client {
+	return &Client{
+		mac: mac,
+
+		onSend:        onSend,
+		onAssign:      onAssign,
+		onUnassign:    onUnassign,
+		onSendFailure: onSendFailure,
+	}
+}
+
+// Run acquires a lease and renews it until the context is cancelled, after which the lease is released
+func (c *Client) Run(ctx context.Context) error {
+	for {
+		offer, ok := c.exchange(ctx, time.Time{}, &message{
+			op:      opRequest,
+			options: map[byte][]byte{optionMessageType: {typeDiscover}},
+		}, typeOffer)
+		if !ok {
+			return nil
+		}
+
+		serverID := offer.getIP(optionServerID)
+		if serverID == nil {
+			continue
+		}
+
+		ack, ok := c.exchange(ctx, time.Now().Add(maxRetransmit), &message{
+			op: opRequest,
+			options: map[byte][]byte{
+				optionMessageType: {typeRequest},
+				optionRequestedIP: offer.yiaddr.To4(),
+				optionServerID:    serverID.To4(),
+			},
+		}, typeAck, typeNak)
+		if !ok {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			continue
+		}
+
+		if ack.getType() == typeNak {
+			continue
+		}
+
+		address := ack.yiaddr.To4()
+		cidr := (&net.IPNet{IP: address, Mask: getMask(ack)}).String()
+
+		if err := c.onAssign(cidr); err != nil {
+			return err
+		}
+
+		released := c.renew(ctx, ack, address, serverID)
+
+		if err := c.onUnassign(cidr); err != nil {
+			return err
+		}
+
+		if released {
+			return nil
+		}
+	}
+}
+
+// renew keeps the lease until the server refuses to renew it or it expires; it returns true if the lease has been released because the context has been cancelled
+func (c *Client) renew(ctx context.Context, ack *message, address net.IP, serverID net.IP) bool {
+	for {
+		leaseTime := ack.getDuration(optionLeaseTime)
+		expires := time.Now().Add(leaseTime)
+
+		// Leases without a lease time don't expire
+		if leaseTime == 0 {
+			leaseTime = time.Duration(math.MaxInt64)
+			expires = time.Time{}
+		}
+
+		renewalTime := ack.getDuration(optionRenewalTime)
+		if renewalTime == 0 {
+			renewalTime = leaseTime / 2
+		}
+
+		if renewalTime < minRenew && leaseTime > minRenew {
+			renewalTime = minRenew
+		}
+
+		t := time.NewTimer(renewalTime)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+
+			c.send(&message{
+				op:      opRequest,
+				ciaddr:  address,
+				options: map[byte][]byte{optionMessageType: {typeRelease}, optionServerID: serverID.To4()},
+			}, rand.Uint32(), address)
+
+			return true
+		case <-t.C:
+		}
+
+		// Renewals are broadcast too, as the server is only reachable over the overlay anyways
+		reply, ok := c.exchange(ctx, expires, &message{
+			op:      opRequest,
+			ciaddr:  address,
+			options: map[byte][]byte{optionMessageType: {typeRequest}},
+		}, typeAck, typeNak)
+		if !ok {
+			if ctx.Err() != nil {
+				return true
+			}
+
+			// The lease has expired
+			return false
+		}
+
+		if reply.getType() == typeNak || !reply.yiaddr.Equal(address) {
+			return false
+		}
+
+		ack = reply
+	}
+}
+
+// exchange sends the message until a reply of one of the types arrives, the deadline is reached or the context is cancelled
+func (c *Client) exchange(ctx context.Context, deadline time.Time, request *message, types ...byte) (*message, bool) {
+	xid := rand.Uint32()
+
+	replies := make(chan *message, 1)
+
+	c.lock.Lock()
+	c.xid = xid
+	c.types = types
+	c.replies = replies
+	c.lock.Unlock()
+
+	defer func() {
+		c.lock.Lock()
+		c.replies = nil
+		c.lock.Unlock()
+	}()
+
+	retransmit := minRetransmit
+	for {
+		c.send(request, xid, request.ciaddr)
+
+		// Randomize retransmits so that clients don't send at the same time
+		wait := retransmit + time.Duration(rand.Int63n(int64(time.Second*2))) - time.Second
+		if !deadline.IsZero() && time.Until(deadline) < wait {
+			wait = time.Until(deadline)
+		}
+
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+
+			return nil, false
+		case reply := <-replies:
+			t.Stop()
+
+			return reply, true
+		case <-t.C:
+		}
+
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return nil, false
+		}
+
+		if retransmit *= 2; retransmit > maxRetransmit {
+			retransmit = maxRetransmit
+		}
+	}
+}
+
+func (c *Client) send(request *message, xid uint32, source net.IP) {
+	request.xid = xid
+	request.chaddr = c.mac
+	request.flags = flagBroadcast
+
+	if source == nil {
+		source = net.IPv4zero
+	}
+
+	frame, err := newFrame(c.mac, broadcastMAC, source, net.IPv4bcast, clientPort, serverPort, request)
+	if err != nil {
+		c.onSendFailure(err)
+
+		return
+	}
+
+	if err := c.onSend(broadcastMAC.String(), frame); err != nil {
+		c.onSendFailure(err)
+	}
+}
+
+// Receive handles DHCP replies in frames received from peers
+func (c *Client) Receive(frame []byte) {
+	reply, ok := parseFrame(frame, clientPort)
+	if !ok || reply.op != opReply || !bytes.Equal(reply.chaddr, c.mac) {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.replies == nil || reply.xid != c.xid || !bytes.Contains(c.types, []byte{reply.getType()}) {
+		return
+	}
+
+	select {
+	case c.replies <- reply:
+	default:
+	}
+}
+
+func getMask(m *message) net.IPMask {
+	if mask := m.getIP(optionSubnetMask); mask != nil {
+		return net.IPMask(mask)
+	}
+
+	// Fall back to the classful network's mask
+	return m.yiaddr.DefaultMask()
+}
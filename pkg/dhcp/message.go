@@ -0,0 +1,234 @@
+package dhcp
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+
+	"github.com/mdlayher/ethernet"
+	"github.com/pojntfx/weron/pkg/config"
+)
+
+const (
+	opRequest = 1
+	opReply   = 2
+
+	typeDiscover = 1
+	typeOffer    = 2
+	typeRequest  = 3
+	typeDecline  = 4
+	typeAck      = 5
+	typeNak      = 6
+	typeRelease  = 7
+
+	optionPad           = 0
+	optionSubnetMask    = 1
+	optionRequestedIP   = 50
+	optionLeaseTime     = 51
+	optionMessageType   = 53
+	optionServerID      = 54
+	optionRenewalTime   = 58
+	optionRebindingTime = 59
+	optionEnd           = 255
+
+	serverPort = 67
+	clientPort = 68
+
+	headerLength     = 236
+	ipv4HeaderLength = 20
+	udpHeaderLength  = 8
+	udpProtocol      = 17
+	ipv4TTL          = 64
+	flagBroadcast    = 0x8000
+)
+
+var (
+	magicCookie = []byte{99, 130, 83, 99}
+
+	broadcastMAC = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+)
+
+type message struct {
+	op      byte
+	xid     uint32
+	flags   uint16
+	ciaddr  net.IP
+	yiaddr  net.IP
+	siaddr  net.IP
+	chaddr  net.HardwareAddr
+	options map[byte][]byte
+}
+
+func (m *message) getType() byte {
+	if t, ok := m.options[optionMessageType]; ok && len(t) == 1 {
+		return t[0]
+	}
+
+	return 0
+}
+
+func (m *message) getIP(option byte) net.IP {
+	if ip, ok := m.options[option]; ok && len(ip) == net.IPv4len {
+		return net.IP(ip)
+	}
+
+	return nil
+}
+
+func (m *message) getDuration(option byte) time.Duration {
+	if d, ok := m.options[option]; ok && len(d) == 4 {
+		return time.Duration(binary.BigEndian.Uint32(d)) * time.Second
+	}
+
+	return 0
+}
+
+func (m *message) marshal() []byte {
+	data := make([]byte, headerLength)
+	data[0] = m.op
+	data[1] = 1 // Ethernet
+	data[2] = 6 // Length of the MAC address
+	binary.BigEndian.PutUint32(data[4:8], m.xid)
+	binary.BigEndian.PutUint16(data[10:12], m.flags)
+	copy(data[12:16], m.ciaddr.To4())
+	copy(data[16:20], m.yiaddr.To4())
+	copy(data[20:24], m.siaddr.To4())
+	copy(data[28:44], m.chaddr)
+
+	data = append(data, magicCookie...)
+
+	// The message type has to come first
+	if t, ok := m.options[optionMessageType]; ok {
+		data = append(data, optionMessageType, byte(len(t)))
+		data = append(data, t...)
+	}
+
+	for option, value := range m.options {
+		if option == optionMessageType {
+			continue
+		}
+
+		data = append(data, option, byte(len(value)))
+		data = append(data, value...)
+	}
+
+	return append(data, optionEnd)
+}
+
+func unmarshalMessage(data []byte) (*message, error) {
+	if len(data) < headerLength+len(magicCookie) || data[1] != 1 || data[2] != 6 || !net.IP(data[headerLength:headerLength+4]).Equal(net.IP(magicCookie)) {
+		return nil, config.ErrInvalidDHCPMessage
+	}
+
+	m := &message{
+		op:      data[0],
+		xid:     binary.BigEndian.Uint32(data[4:8]),
+		flags:   binary.BigEndian.Uint16(data[10:12]),
+		ciaddr:  net.IP(append([]byte{}, data[12:16]...)),
+		yiaddr:  net.IP(append([]byte{}, data[16:20]...)),
+		siaddr:  net.IP(append([]byte{}, data[20:24]...)),
+		chaddr:  net.HardwareAddr(append([]byte{}, data[28:34]...)),
+		options: map[byte][]byte{},
+	}
+
+	options := data[headerLength+len(magicCookie):]
+	for len(options) > 0 {
+		option := options[0]
+		if option == optionEnd {
+			break
+		}
+
+		if option == optionPad {
+			options = options[1:]
+
+			continue
+		}
+
+		if len(options) < 2 || len(options) < 2+int(options[1]) {
+			return nil, config.ErrInvalidDHCPMessage
+		}
+
+		m.options[option] = append([]byte{}, options[2:2+int(options[1])]...)
+
+		options = options[2+int(options[1]):]
+	}
+
+	return m, nil
+}
+
+// parseFrame returns the DHCP message in the frame if it is sent to the given UDP port
+func parseFrame(frame []byte, port uint16) (*message, bool) {
+	var parsedFrame ethernet.Frame
+	if err := parsedFrame.UnmarshalBinary(frame); err != nil || parsedFrame.EtherType != ethernet.EtherTypeIPv4 {
+		return nil, false
+	}
+
+	packet := parsedFrame.Payload
+	if len(packet) < ipv4HeaderLength || packet[0]>>4 != 4 || packet[9] != udpProtocol {
+		return nil, false
+	}
+
+	ihl := int(packet[0]&0x0f) * 4
+	if len(packet) < ihl+udpHeaderLength {
+		return nil, false
+	}
+
+	datagram := packet[ihl:]
+	if binary.BigEndian.Uint16(datagram[2:4]) != port {
+		return nil, false
+	}
+
+	m, err := unmarshalMessage(datagram[udpHeaderLength:])
+	if err != nil {
+		return nil, false
+	}
+
+	return m, true
+}
+
+// newFrame wraps the message in UDP, IPv4 and Ethernet headers
+func newFrame(sourceMAC net.HardwareAddr, destinationMAC net.HardwareAddr, source net.IP, destination net.IP, sourcePort uint16, destinationPort uint16, m *message) ([]byte, error) {
+	payload := m.marshal()
+
+	datagram := make([]byte, udpHeaderLength+len(payload))
+	binary.BigEndian.PutUint16(datagram[0:2], sourcePort)
+	binary.BigEndian.PutUint16(datagram[2:4], destinationPort)
+	binary.BigEndian.PutUint16(datagram[4:6], uint16(len(datagram)))
+	copy(datagram[udpHeaderLength:], payload) // The UDP checksum is optional for IPv4
+
+	packet := make([]byte, ipv4HeaderLength+len(datagram))
+	packet[0] = 4<<4 | ipv4HeaderLength/4
+	binary.BigEndian.PutUint16(packet[2:4], uint16(len(packet)))
+	packet[8] = ipv4TTL
+	packet[9] = udpProtocol
+	copy(packet[12:16], source.To4())
+	copy(packet[16:20], destination.To4())
+	binary.BigEndian.PutUint16(packet[10:12], getIPv4Checksum(packet[:ipv4HeaderLength]))
+	copy(packet[ipv4HeaderLength:], datagram)
+
+	frame := ethernet.Frame{
+		Destination: destinationMAC,
+		Source:      sourceMAC,
+		EtherType:   ethernet.EtherTypeIPv4,
+		Payload:     packet,
+	}
+
+	return frame.MarshalBinary()
+}
+
+func getIPv4Checksum(header []byte) uint16 {
+	sum := uint32(0)
+	for i := 0; i < len(header); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(header[i:]))
+	}
+
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+
+	return ^uint16(sum)
+}
+
+func newDuration(d time.Duration) []byte {
+	return binary.BigEndian.AppendUint32(nil, uint32(d/time.Second))
+}
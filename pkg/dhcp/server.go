@@ -0,0 +1,210 @@
+package dhcp
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	offerTimeout = time.Minute // Time to reserve an offered address for before it can be offered to another client
+	maxAddresses = 65536       // Maximum number of addresses to search for a free one
+)
+
+type lease struct {
+	address net.IP
+	expires time.Time
+}
+
+type Server struct {
+	mac       net.HardwareAddr
+	address   net.IP
+	network   *net.IPNet
+	leaseTime time.Duration
+
+	leases map[string]*lease // Leases by MAC address; declined addresses are reserved under their own address
+
+	lock sync.Mutex
+
+	onSend        func(mac string, frame []byte) error
+	onLease       func(address string, mac string)
+	onSendFailure func(err error)
+}
+
+func NewServer(
+	mac net.HardwareAddr,
+	address net.IP,
+	network *net.IPNet,
+	leaseTime time.Duration,
+
+	onSend func(mac string, frame []byte) error,
+	onLease func(address string, mac string),
+	onSendFailure func(err error),
+) *Server {
+	return &Server{
+		mac:       mac,
+		address:   address.To4(),
+		network:   network,
+		leaseTime: leaseTime,
+
+		leases: map[string]*lease{},
+
+		onSend:        onSend,
+		onLease:       onLease,
+		onSendFailure: onSendFailure,
+	}
+}
+
+// Receive answers DHCP messages in frames received from peers
+func (s *Server) Receive(frame []byte) {
+	request, ok := parseFrame(frame, serverPort)
+	if !ok || request.op != opRequest {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	mac := request.chaddr.String()
+	now := time.Now()
+
+	switch request.getType() {
+	case typeDiscover:
+		address := s.allocate(mac, request.getIP(optionRequestedIP), now)
+		if address == nil {
+			return
+		}
+
+		s.leases[mac] = &lease{address, now.Add(offerTimeout)}
+
+		s.reply(request, typeOffer, address)
+	case typeRequest:
+		// The client has chosen another server's offer
+		if serverID := request.getIP(optionServerID); serverID != nil && !serverID.Equal(s.address) {
+			if l, ok := s.leases[mac]; ok && l.expires.Sub(now) <= offerTimeout {
+				delete(s.leases, mac)
+			}
+
+			return
+		}
+
+		// Clients which renew their lease send their address in ciaddr instead of the option
+		address := request.getIP(optionRequestedIP)
+		if address == nil {
+			address = request.ciaddr
+		}
+
+		if !s.isAvailable(mac, address, now) {
+			s.reply(request, typeNak, nil)
+
+			return
+		}
+
+		s.leases[mac] = &lease{address.To4(), now.Add(s.leaseTime)}
+
+		s.reply(request, typeAck, address)
+
+		s.onLease(address.String(), mac)
+	case typeDecline:
+		// Another node uses the address, so don't lease it for a while
+		if l, ok := s.leases[mac]; ok {
+			delete(s.leases, mac)
+
+			s.leases[l.address.String()] = &lease{l.address, now.Add(s.leaseTime)}
+		}
+	case typeRelease:
+		if l, ok := s.leases[mac]; ok && l.address.Equal(request.ciaddr) {
+			delete(s.leases, mac)
+		}
+	}
+}
+
+// allocate prefers the client's previous address, then the address it asked for and then the first free address
+func (s *Server) allocate(mac string, requested net.IP, now time.Time) net.IP {
+	if l, ok := s.leases[mac]; ok && s.isAvailable(mac, l.address, now) {
+		return l.address
+	}
+
+	if requested != nil && s.isAvailable(mac, requested, now) {
+		return requested.To4()
+	}
+
+	ones, bits := s.network.Mask.Size()
+	size := uint64(1) << (bits - ones)
+	if size > maxAddresses {
+		size = maxAddresses
+	}
+
+	base := binary.BigEndian.Uint32(s.network.IP.To4())
+	for i := uint64(1); i < size; i++ {
+		address := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(address, base+uint32(i))
+
+		if s.isAvailable(mac, address, now) {
+			return address
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) isAvailable(mac string, address net.IP, now time.Time) bool {
+	address = address.To4()
+	if address == nil || !s.network.Contains(address) || address.Equal(s.address) || address.Equal(s.network.IP) || address.Equal(getBroadcastAddress(s.network)) {
+		return false
+	}
+
+	for candidate, l := range s.leases {
+		if candidate != mac && l.address.Equal(address) && now.Before(l.expires) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (s *Server) reply(request *message, messageType byte, address net.IP) {
+	response := &message{
+		op:     opReply,
+		xid:    request.xid,
+		flags:  request.flags,
+		ciaddr: net.IPv4zero,
+		yiaddr: net.IPv4zero,
+		siaddr: s.address,
+		chaddr: request.chaddr,
+		options: map[byte][]byte{
+			optionMessageType: {messageType},
+			optionServerID:    s.address,
+		},
+	}
+
+	if messageType != typeNak {
+		response.yiaddr = address
+		response.options[optionSubnetMask] = s.network.Mask[len(s.network.Mask)-net.IPv4len:]
+		response.options[optionLeaseTime] = newDuration(s.leaseTime)
+		response.options[optionRenewalTime] = newDuration(s.leaseTime / 2)
+		response.options[optionRebindingTime] = newDuration(s.leaseTime * 7 / 8)
+	}
+
+	// Replies are broadcast on the IP layer as the client doesn't have an address yet, but only sent to the client's peer
+	frame, err := newFrame(s.mac, request.chaddr, s.address, net.IPv4bcast, serverPort, clientPort, response)
+	if err != nil {
+		s.onSendFailure(err)
+
+		return
+	}
+
+	if err := s.onSend(request.chaddr.String(), frame); err != nil {
+		s.onSendFailure(err)
+	}
+}
+
+func getBroadcastAddress(network *net.IPNet) net.IP {
+	broadcast := make(net.IP, net.IPv4len)
+	for i, b := range network.IP.To4() {
+		broadcast[i] = b | ^network.Mask[len(network.Mask)-net.IPv4len+i]
+	}
+
+	return broadcast
+}
@@ -5,6 +5,8 @@ import (
 	"crypto/cipher"
 	"crypto/rand"
 	"io"
+
+	"github.com/pojntfx/weron/pkg/config"
 )
 
 func Encrypt(data []byte, key []byte) ([]byte, error) {
@@ -28,6 +30,9 @@ func Decrypt(data []byte, key []byte) ([]byte, error) {
 	}
 
 	nonceSize := counter.NonceSize()
+	if len(data) < nonceSize {
+		return nil, config.ErrCiphertextTooShort
+	}
 
 	nonce, cyphertext := data[:nonceSize], data[nonceSize:]
 
@@ -0,0 +1,91 @@
+package offload
+
+import (
+	"encoding/binary"
+)
+
+const (
+	ethernetHeaderLength = 14
+	vlanTagLength        = 4
+
+	etherTypeIPv4 = 0x0800
+	etherTypeIPv6 = 0x86dd
+	etherTypeVLAN = 0x8100
+
+	ipv4HeaderLength = 20
+	ipv6HeaderLength = 40
+	tcpHeaderLength  = 20
+	protocolTCP      = 6
+)
+
+// GetTCPOffsets returns the offsets of the IP and TCP headers in an Ethernet frame with at most one VLAN tag
+func GetTCPOffsets(frame []byte) (int, int, bool) {
+	if len(frame) < ethernetHeaderLength {
+		return 0, 0, false
+	}
+
+	network := ethernetHeaderLength
+	etherType := binary.BigEndian.Uint16(frame[12:14])
+	if etherType == etherTypeVLAN && len(frame) >= ethernetHeaderLength+vlanTagLength {
+		network += vlanTagLength
+		etherType = binary.BigEndian.Uint16(frame[16:18])
+	}
+
+	transport := 0
+	switch etherType {
+	case etherTypeIPv4:
+		if len(frame) < network+ipv4HeaderLength || frame[network+9] != protocolTCP {
+			return 0, 0, false
+		}
+
+		transport = network + int(frame[network]&0x0f)*4
+	case etherTypeIPv6:
+		// Segments with extension headers aren't offloaded by the device
+		if len(frame) < network+ipv6HeaderLength || frame[network+6] != protocolTCP {
+			return 0, 0, false
+		}
+
+		transport = network + ipv6HeaderLength
+	default:
+		return 0, 0, false
+	}
+
+	if len(frame) < transport+tcpHeaderLength {
+		return 0, 0, false
+	}
+
+	return network, transport, true
+}
+
+// GetPseudoHeaderSum sums the addresses, protocol and length which the TCP checksum covers in addition to the segment
+func GetPseudoHeaderSum(packet []byte, length int) uint32 {
+	sum := uint32(protocolTCP) + uint32(length)
+	if packet[0]>>4 == 6 {
+		return SumChecksum(sum, packet[8:40])
+	}
+
+	return SumChecksum(sum, packet[12:20])
+}
+
+// SumChecksum adds the data to the sum of an Internet checksum (RFC 1071)
+func SumChecksum(sum uint32, data []byte) uint32 {
+	for len(data) >= 2 {
+		sum += uint32(binary.BigEndian.Uint16(data))
+		data = data[2:]
+	}
+
+	if len(data) == 1 {
+		sum += uint32(data[0]) << 8
+	}
+
+	return sum
+}
+
+// FoldChecksum folds the sum of an Internet checksum into 16 bits
+func FoldChecksum(sum uint32) uint16 {
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+
+	return uint16(sum)
+}
@@ -0,0 +1,200 @@
+package offload
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/checksum"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+var (
+	testIPv4Source      = tcpip.AddrFrom4Slice(net.ParseIP("10.0.0.1").To4())
+	testIPv4Destination = tcpip.AddrFrom4Slice(net.ParseIP("10.0.0.2").To4())
+	testIPv6Source      = tcpip.AddrFrom16Slice(net.ParseIP("fd00::1"))
+	testIPv6Destination = tcpip.AddrFrom16Slice(net.ParseIP("fd00::2"))
+)
+
+// newTestTCPFrame creates a TCP segment with valid checksums, which are calculated by gVisor instead of this package
+func newTestTCPFrame(ipv6, tagged bool, ipv4Options header.IPv4OptionsSerializer, payloadLength int) []byte {
+	payload := make([]byte, payloadLength)
+	for i := range payload {
+		payload[i] = byte(i % 251)
+	}
+
+	tcp := header.TCP(make([]byte, header.TCPMinimumSize))
+	tcp.Encode(&header.TCPFields{
+		SrcPort:    1234,
+		DstPort:    80,
+		SeqNum:     1000,
+		AckNum:     2000,
+		DataOffset: header.TCPMinimumSize,
+		Flags:      header.TCPFlagAck | header.TCPFlagPsh,
+		WindowSize: 65535,
+	})
+
+	var ip []byte
+	src, dst := testIPv4Source, testIPv4Destination
+	etherType := uint16(etherTypeIPv4)
+	if ipv6 {
+		src, dst = testIPv6Source, testIPv6Destination
+		etherType = etherTypeIPv6
+
+		ipv6 := header.IPv6(make([]byte, header.IPv6MinimumSize))
+		ipv6.Encode(&header.IPv6Fields{
+			PayloadLength:     uint16(len(tcp) + len(payload)),
+			TransportProtocol: header.TCPProtocolNumber,
+			HopLimit:          64,
+			SrcAddr:           src,
+			DstAddr:           dst,
+		})
+
+		ip = ipv6
+	} else {
+		ipv4 := header.IPv4(make([]byte, header.IPv4MinimumSize+int(ipv4Options.Length())))
+		ipv4.Encode(&header.IPv4Fields{
+			TotalLength: uint16(len(ipv4) + len(tcp) + len(payload)),
+			ID:          0x1234,
+			TTL:         64,
+			Protocol:    uint8(header.TCPProtocolNumber),
+			SrcAddr:     src,
+			DstAddr:     dst,
+			Options:     ipv4Options,
+		})
+		ipv4.SetChecksum(^ipv4.CalculateChecksum())
+
+		ip = ipv4
+	}
+
+	tcp.SetChecksum(^tcp.CalculateChecksum(checksum.Checksum(payload, header.PseudoHeaderChecksum(header.TCPProtocolNumber, src, dst, uint16(len(tcp)+len(payload))))))
+
+	frame := make([]byte, 12)
+	if tagged {
+		frame = binary.BigEndian.AppendUint16(frame, etherTypeVLAN)
+		frame = binary.BigEndian.AppendUint16(frame, 10)
+	}
+	frame = binary.BigEndian.AppendUint16(frame, etherType)
+
+	frame = append(frame, ip...)
+	frame = append(frame, tcp...)
+
+	return append(frame, payload...)
+}
+
+// withByte returns a copy of the frame with the byte at the offset replaced
+func withByte(frame []byte, offset int, value byte) []byte {
+	frame = append([]byte{}, frame...)
+	frame[offset] = value
+
+	return frame
+}
+
+func TestGetTCPOffsets(t *testing.T) {
+	ipv4 := newTestTCPFrame(false, false, nil, 100)
+	ipv6 := newTestTCPFrame(true, false, nil, 100)
+
+	doubleTagged := append([]byte{}, ipv4[:12]...)
+	doubleTagged = append(doubleTagged, 0x88, 0xa8, 0x00, 0x1e)
+	doubleTagged = append(doubleTagged, newTestTCPFrame(false, true, nil, 100)[12:]...)
+
+	for _, test := range []struct {
+		name          string
+		frame         []byte
+		wantNetwork   int
+		wantTransport int
+		wantOK        bool
+	}{
+		{"ipv4", ipv4, 14, 34, true},
+		{"ipv4 with options", newTestTCPFrame(false, false, header.IPv4OptionsSerializer{&header.IPv4SerializableRouterAlertOption{}}, 100), 14, 38, true},
+		{"ipv6", ipv6, 14, 54, true},
+		{"tagged ipv4", newTestTCPFrame(false, true, nil, 100), 18, 38, true},
+		{"tagged ipv6", newTestTCPFrame(true, true, nil, 100), 18, 58, true},
+		{"ipv4 without payload", newTestTCPFrame(false, false, nil, 0), 14, 34, true},
+		{"double-tagged", doubleTagged, 0, 0, false},
+		{"udp over ipv4", withByte(ipv4, 14+9, uint8(header.UDPProtocolNumber)), 0, 0, false},
+		{"udp over ipv6", withByte(ipv6, 14+6, uint8(header.UDPProtocolNumber)), 0, 0, false},
+		{"ipv6 with extension header", withByte(ipv6, 14+6, uint8(header.IPv6HopByHopOptionsExtHdrIdentifier)), 0, 0, false},
+		{"arp", withByte(ipv4, 13, 0x06), 0, 0, false},
+		{"truncated ethernet header", ipv4[:13], 0, 0, false},
+		{"truncated ipv4 header", ipv4[:14+header.IPv4MinimumSize-1], 0, 0, false},
+		{"truncated ipv6 header", ipv6[:14+header.IPv6MinimumSize-1], 0, 0, false},
+		{"truncated tcp header", ipv4[:34+header.TCPMinimumSize-1], 0, 0, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			network, transport, ok := GetTCPOffsets(test.frame)
+			if ok != test.wantOK {
+				t.Fatalf("getting the offsets returned %v, want %v", ok, test.wantOK)
+			}
+
+			if network != test.wantNetwork || transport != test.wantTransport {
+				t.Fatalf("got offsets %v and %v, want %v and %v", network, transport, test.wantNetwork, test.wantTransport)
+			}
+		})
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	for _, length := range []int{0, 1, 2, 3, 20, 63, 64, 1500, 65535} {
+		// Bytes of 0xff cause a carry for every sum, the pattern catches swapped bytes
+		for _, data := range [][]byte{bytes.Repeat([]byte{0xff}, length), newTestTCPFrame(false, false, nil, length)[:length]} {
+			want := checksum.Checksum(data, 0)
+
+			if got := FoldChecksum(SumChecksum(0, data)); got != want {
+				t.Fatalf("checksum of %v bytes is %#04x, want %#04x", length, got, want)
+			}
+
+			// Sums can be continued as long as all but the last part have an even length
+			half := length / 2 &^ 1
+			if got := FoldChecksum(SumChecksum(SumChecksum(0, data[:half]), data[half:])); got != want {
+				t.Fatalf("continued checksum of %v bytes is %#04x, want %#04x", length, got, want)
+			}
+		}
+	}
+}
+
+func TestGetPseudoHeaderSum(t *testing.T) {
+	for _, test := range []struct {
+		name          string
+		ipv6          bool
+		payloadLength int
+	}{
+		{"ipv4", false, 100},
+		{"ipv4 with odd length", false, 1001},
+		{"ipv4 with maximum length", false, 65535 - header.IPv4MinimumSize - header.TCPMinimumSize},
+		{"ipv6", true, 100},
+		{"ipv6 with odd length", true, 1001},
+		{"ipv6 with maximum length", true, 65535 - header.TCPMinimumSize},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			frame := newTestTCPFrame(test.ipv6, false, nil, test.payloadLength)
+
+			network, transport, ok := GetTCPOffsets(frame)
+			if !ok {
+				t.Fatal("frame isn't a TCP segment")
+			}
+
+			src, dst := testIPv4Source, testIPv4Destination
+			if test.ipv6 {
+				src, dst = testIPv6Source, testIPv6Destination
+			}
+
+			length := len(frame) - transport
+			sum := GetPseudoHeaderSum(frame[network:], length)
+
+			if got, want := FoldChecksum(sum), header.PseudoHeaderChecksum(header.TCPProtocolNumber, src, dst, uint16(length)); got != want {
+				t.Fatalf("pseudo header sum is %#04x, want %#04x", got, want)
+			}
+
+			tcp := header.TCP(frame[transport:])
+			want := tcp.Checksum()
+
+			tcp.SetChecksum(0)
+			if got := ^FoldChecksum(SumChecksum(sum, tcp)); got != want {
+				t.Fatalf("TCP checksum is %#04x, want %#04x", got, want)
+			}
+		})
+	}
+}
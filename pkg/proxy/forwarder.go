@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/pojntfx/weron/pkg/config"
+)
+
+type Forwarder struct {
+	laddr string
+	raddr string
+
+	listener net.Listener
+
+	lock sync.Mutex
+
+	onListen func(network, address string) (net.Listener, error)
+	onDial   func(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+func NewForwarder(
+	laddr string,
+	raddr string,
+
+	onListen func(network, address string) (net.Listener, error),
+	onDial func(ctx context.Context, network, address string) (net.Conn, error),
+) *Forwarder {
+	return &Forwarder{
+		laddr: laddr,
+		raddr: raddr,
+
+		onListen: onListen,
+		onDial:   onDial,
+	}
+}
+
+func (f *Forwarder) Open() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.listener != nil {
+		return config.ErrAlreadyOpened
+	}
+
+	listener, err := f.onListen("tcp", f.laddr)
+	if err != nil {
+		return err
+	}
+
+	f.listener = listener
+
+	return nil
+}
+
+func (f *Forwarder) Serve() error {
+	f.lock.Lock()
+	listener := f.listener
+	f.lock.Unlock()
+
+	if listener == nil {
+		return net.ErrClosed
+	}
+
+	return serve(listener, f.isClosed, func(conn net.Conn) {
+		remote, err := f.onDial(context.Background(), "tcp", f.raddr)
+		if err != nil {
+			return
+		}
+
+		splice(conn, remote)
+	})
+}
+
+func (f *Forwarder) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.listener == nil {
+		return nil // No-op
+	}
+
+	err := f.listener.Close()
+
+	f.listener = nil
+
+	return err
+}
+
+func (f *Forwarder) isClosed() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.listener == nil
+}
+
+func serve(listener net.Listener, isClosed func() bool, onConn func(conn net.Conn)) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			// Not all listeners return net.ErrClosed once they are closed on shutdown
+			if isClosed() {
+				return nil
+			}
+
+			return err
+		}
+
+		go func() {
+			defer conn.Close()
+
+			onConn(conn)
+		}()
+	}
+}
+
+func splice(local net.Conn, remote net.Conn) {
+	defer remote.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	forward := func(dst net.Conn, src net.Conn) {
+		defer wg.Done()
+
+		// Ignore as the connection is closed either way
+		_, _ = io.Copy(dst, src)
+
+		// Half-close so that the other direction can finish sending
+		if c, ok := dst.(interface{ CloseWrite() error }); ok {
+			_ = c.CloseWrite()
+		} else {
+			_ = dst.Close()
+		}
+	}
+
+	go forward(remote, local)
+	go forward(local, remote)
+
+	wg.Wait()
+}
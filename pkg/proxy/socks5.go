@@ -0,0 +1,224 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pojntfx/weron/pkg/config"
+)
+
+const (
+	socks5Version = 5
+
+	socks5MethodNoAuth       = 0
+	socks5MethodNoAcceptable = 0xff
+
+	socks5CommandConnect = 1
+
+	socks5AddressIPv4   = 1
+	socks5AddressDomain = 3
+	socks5AddressIPv6   = 4
+
+	socks5ReplySucceeded               = 0
+	socks5ReplyHostUnreachable         = 4
+	socks5ReplyCommandNotSupported     = 7
+	socks5ReplyAddressTypeNotSupported = 8
+
+	socks5HandshakeTimeout = time.Second * 10
+)
+
+type SOCKS5Server struct {
+	laddr string
+
+	listener net.Listener
+
+	lock sync.Mutex
+
+	onDial func(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+func NewSOCKS5Server(
+	laddr string,
+
+	onDial func(ctx context.Context, network, address string) (net.Conn, error),
+) *SOCKS5Server {
+	return &SOCKS5Server{
+		laddr: laddr,
+
+		onDial: onDial,
+	}
+}
+
+func (s *SOCKS5Server) Open() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.listener != nil {
+		return config.ErrAlreadyOpened
+	}
+
+	listener, err := net.Listen("tcp", s.laddr)
+	if err != nil {
+		return err
+	}
+
+	s.listener = listener
+
+	return nil
+}
+
+func (s *SOCKS5Server) Serve() error {
+	s.lock.Lock()
+	listener := s.listener
+	s.lock.Unlock()
+
+	if listener == nil {
+		return net.ErrClosed
+	}
+
+	return serve(listener, s.isClosed, s.handleConn)
+}
+
+func (s *SOCKS5Server) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.listener == nil {
+		return nil // No-op
+	}
+
+	err := s.listener.Close()
+
+	s.listener = nil
+
+	return err
+}
+
+func (s *SOCKS5Server) isClosed() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.listener == nil
+}
+
+func (s *SOCKS5Server) handleConn(conn net.Conn) {
+	// Don't let clients which never finish the handshake keep connections open
+	if err := conn.SetDeadline(time.Now().Add(socks5HandshakeTimeout)); err != nil {
+		return
+	}
+
+	// Negotiate the authentication method; only unauthenticated access is supported as the proxy should only listen locally
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil || header[0] != socks5Version {
+		return
+	}
+
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return
+	}
+
+	method := byte(socks5MethodNoAcceptable)
+	for _, m := range methods {
+		if m == socks5MethodNoAuth {
+			method = socks5MethodNoAuth
+
+			break
+		}
+	}
+
+	if _, err := conn.Write([]byte{socks5Version, method}); err != nil || method == socks5MethodNoAcceptable {
+		return
+	}
+
+	// Read the request
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil || request[0] != socks5Version {
+		return
+	}
+
+	if request[1] != socks5CommandConnect {
+		s.reply(conn, socks5ReplyCommandNotSupported)
+
+		return
+	}
+
+	var host string
+	switch request[3] {
+	case socks5AddressIPv4, socks5AddressIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if request[3] == socks5AddressIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return
+		}
+
+		host = ip.String()
+	case socks5AddressDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return
+		}
+
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			return
+		}
+
+		// There is no resolver on the overlay, but some clients send IP addresses as domains
+		if net.ParseIP(string(domain)) == nil {
+			s.reply(conn, socks5ReplyAddressTypeNotSupported)
+
+			return
+		}
+
+		host = string(domain)
+	default:
+		s.reply(conn, socks5ReplyAddressTypeNotSupported)
+
+		return
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), socks5HandshakeTimeout)
+	defer cancel()
+
+	remote, err := s.onDial(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+	if err != nil {
+		s.reply(conn, socks5ReplyHostUnreachable)
+
+		return
+	}
+
+	if err := s.reply(conn, socks5ReplySucceeded); err != nil {
+		_ = remote.Close()
+
+		return
+	}
+
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		_ = remote.Close()
+
+		return
+	}
+
+	splice(conn, remote)
+}
+
+func (s *SOCKS5Server) reply(conn net.Conn, code byte) error {
+	// Clients don't need the bound address, so it is left unspecified
+	_, err := conn.Write([]byte{socks5Version, code, 0, socks5AddressIPv4, 0, 0, 0, 0, 0, 0})
+
+	return err
+}
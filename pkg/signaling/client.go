@@ -23,6 +23,7 @@ type SignalingClient struct {
 	ctx     context.Context
 	timeout time.Duration
 
+	onAcceptance   func(address string)
 	onIntroduction func(mac string)
 	onOffer        func(mac string, o webrtc.SessionDescription)
 	onCandidate    func(mac string, i webrtc.ICECandidateInit)
@@ -41,6 +42,7 @@ func NewSignalingClient(
 	ctx context.Context,
 	timeout time.Duration,
 
+	onAcceptance func(address string),
 	onIntroduction func(mac string),
 	onOffer func(mac string, o webrtc.SessionDescription),
 	onCandidate func(mac string, i webrtc.ICECandidateInit),
@@ -58,6 +60,7 @@ func NewSignalingClient(
 		ctx:     ctx,
 		timeout: timeout,
 
+		onAcceptance:   onAcceptance,
 		onIntroduction: onIntroduction,
 		onOffer:        onOffer,
 		onCandidate:    onCandidate,
@@ -117,6 +120,16 @@ func (c *SignalingClient) Run() error {
 
 				return
 			case api.TypeAcceptance:
+				// Cast to acceptance
+				var acceptance api.Acceptance
+				if err := json.Unmarshal(data, &acceptance); err != nil {
+					fatal <- err
+
+					return
+				}
+
+				c.onAcceptance(acceptance.Address)
+
 				ready <- struct{}{}
 			case api.TypeIntroduction:
 				// Cast to introduction
@@ -1,7 +1,10 @@
 package signaling
 
 import (
+	"net"
+	"sort"
 	"sync"
+	"time"
 
 	"nhooyr.io/websocket"
 
@@ -12,6 +15,11 @@ import (
 type CommunitiesManager struct {
 	communities map[string]map[string]*websocket.Conn
 
+	networks   map[string]*net.IPNet        // Communities without a network don't get leases
+	leases     map[string]map[string]*Lease // Leases by community and MAC address
+	leaseTTL   time.Duration
+	leasesPath string // An empty path disables persistence
+
 	lock sync.Mutex
 
 	onIntroduction func(mac string, conn *websocket.Conn) error
@@ -20,6 +28,10 @@ type CommunitiesManager struct {
 }
 
 func NewCommunitiesManager(
+	networks map[string]*net.IPNet,
+	leaseTTL time.Duration,
+	leasesPath string,
+
 	onIntroduction func(mac string, conn *websocket.Conn) error,
 	onExchange func(mac string, exchange api.Exchange, conn *websocket.Conn) error,
 	onResignation func(mac string, conn *websocket.Conn) error,
@@ -27,16 +39,73 @@ func NewCommunitiesManager(
 	return &CommunitiesManager{
 		communities: map[string]map[string]*websocket.Conn{},
 
+		networks:   networks,
+		leases:     map[string]map[string]*Lease{},
+		leaseTTL:   leaseTTL,
+		leasesPath: leasesPath,
+
 		onIntroduction: onIntroduction,
 		onExchange:     onExchange,
 		onResignation:  onResignation,
 	}
 }
 
-func (m *CommunitiesManager) HandleApplication(community string, mac string, conn *websocket.Conn) error {
+func (m *CommunitiesManager) Open() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.leasesPath == "" {
+		return nil
+	}
+
+	leases, err := readLeases(m.leasesPath)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, l := range leases {
+		l := l
+
+		// Drop leases which don't fit the community's network anymore, i.e. because it has been changed
+		network, ok := m.networks[l.Community]
+		if !ok {
+			continue
+		}
+
+		address, _, err := net.ParseCIDR(l.Address)
+		if err != nil || !network.Contains(address) {
+			continue
+		}
+
+		// Nodes which were connected before the signaler was restarted have to reconnect before their leases expire
+		if l.Expires.IsZero() {
+			l.Expires = now.Add(m.leaseTTL)
+		}
+
+		if l.isExpired(now) {
+			continue
+		}
+
+		if _, ok := m.leases[l.Community]; !ok {
+			m.leases[l.Community] = map[string]*Lease{}
+		}
+
+		m.leases[l.Community][l.MAC] = &l
+	}
+
+	return m.persistLeases()
+}
+
+func (m *CommunitiesManager) HandleApplication(community string, mac string, conn *websocket.Conn) (string, error) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
+	address, err := m.lease(community, mac)
+	if err != nil {
+		return "", err
+	}
+
 	// Create or copy community
 	newCommunity := make(map[string]*websocket.Conn)
 	if candidate, ok := m.communities[community]; ok {
@@ -48,7 +117,7 @@ func (m *CommunitiesManager) HandleApplication(community string, mac string, con
 	// Apply changes
 	m.communities[community] = newCommunity
 
-	return nil
+	return address, nil
 }
 
 func (m *CommunitiesManager) HandleReady(community string, mac string) error {
@@ -86,10 +155,11 @@ func (m *CommunitiesManager) HandleExchange(community string, mac string, exchan
 		return err
 	}
 
-	// Get the connection for the destination
+	// Get the connection for the destination; drop the exchange if the destination has disconnected in the meantime,
+	// i.e. because it is reconnecting, as the sender will retry once the destination has been re-introduced
 	destination, ok := comm[exchange.Mac]
 	if !ok {
-		return config.ErrConnectionDoesNotExist
+		return nil
 	}
 
 	// Swap source and destination MACs in exchange
@@ -103,7 +173,7 @@ func (m *CommunitiesManager) HandleExchange(community string, mac string, exchan
 	return nil
 }
 
-func (m *CommunitiesManager) HandleExited(community string, mac string, err error) error {
+func (m *CommunitiesManager) HandleExited(community string, mac string, conn *websocket.Conn, err error) error {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
@@ -113,6 +183,14 @@ func (m *CommunitiesManager) HandleExited(community string, mac string, err erro
 		return communityErr
 	}
 
+	// The node has reconnected before its previous connection exited, so the exit must not affect the new connection
+	if comm[mac] != conn {
+		// Ignore as the previous connection is broken already
+		_ = conn.Close(websocket.StatusNormalClosure, "replaced")
+
+		return nil
+	}
+
 	for candidate, conn := range comm {
 		// Ignore the node which sent the exited message
 		if candidate == mac {
@@ -125,12 +203,18 @@ func (m *CommunitiesManager) HandleExited(community string, mac string, err erro
 		}
 	}
 
-	// Get a copy of the connection
-	conn := comm[mac]
-
 	// Delete the connection from the community
 	delete(comm, mac)
 
+	// Keep the lease for a while so that the node gets the same address when it reconnects
+	if l, ok := m.leases[community][mac]; ok {
+		l.Expires = time.Now().Add(m.leaseTTL)
+
+		if err := m.persistLeases(); err != nil {
+			return err
+		}
+	}
+
 	// Delete the community if it is now empty
 	if len(comm) == 0 {
 		delete(m.communities, community)
@@ -154,8 +238,8 @@ func (m *CommunitiesManager) Close() []error {
 	errors := []error{}
 
 	for community, comm := range m.communities {
-		for mac := range comm {
-			if err := m.HandleExited(community, mac, nil); err != nil {
+		for mac, conn := range comm {
+			if err := m.HandleExited(community, mac, conn, nil); err != nil {
 				errors = append(errors, err)
 			}
 		}
@@ -164,6 +248,90 @@ func (m *CommunitiesManager) Close() []error {
 	return errors
 }
 
+func (m *CommunitiesManager) lease(community string, mac string) (string, error) {
+	network, ok := m.networks[community]
+	if !ok {
+		return "", nil
+	}
+
+	if _, ok := m.leases[community]; !ok {
+		m.leases[community] = map[string]*Lease{}
+	}
+	leases := m.leases[community]
+
+	// Renew the existing lease
+	if l, ok := leases[mac]; ok {
+		l.Expires = time.Time{}
+
+		return l.Address, m.persistLeases()
+	}
+
+	now := time.Now()
+	used := map[string]struct{}{}
+	for candidate, l := range leases {
+		// Expired leases can be given to other nodes
+		if l.isExpired(now) {
+			delete(leases, candidate)
+
+			continue
+		}
+
+		ip, _, err := net.ParseCIDR(l.Address)
+		if err != nil {
+			continue
+		}
+
+		used[ip.String()] = struct{}{}
+	}
+
+	// Try the address derived from the MAC address first, then the following ones
+	for attempt := uint64(0); attempt < getLeaseAttempts(network); attempt++ {
+		ip, ok := getLeaseAddress(network, mac, attempt)
+		if !ok {
+			continue
+		}
+
+		if _, ok := used[ip.String()]; ok {
+			continue
+		}
+
+		l := &Lease{
+			Community: community,
+			MAC:       mac,
+			Address:   (&net.IPNet{IP: ip, Mask: network.Mask}).String(),
+		}
+
+		leases[mac] = l
+
+		return l.Address, m.persistLeases()
+	}
+
+	return "", config.ErrNetworkExhausted
+}
+
+func (m *CommunitiesManager) persistLeases() error {
+	if m.leasesPath == "" {
+		return nil
+	}
+
+	leases := []Lease{}
+	for _, comm := range m.leases {
+		for _, l := range comm {
+			leases = append(leases, *l)
+		}
+	}
+
+	sort.Slice(leases, func(i, j int) bool {
+		if leases[i].Community == leases[j].Community {
+			return leases[i].MAC < leases[j].MAC
+		}
+
+		return leases[i].Community < leases[j].Community
+	})
+
+	return writeLeases(m.leasesPath, leases)
+}
+
 func (m *CommunitiesManager) getCommunity(community string, mac string) (map[string]*websocket.Conn, error) {
 	// Check if community exists
 	comm, ok := m.communities[community]
@@ -0,0 +1,188 @@
+package signaling
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	api "github.com/pojntfx/weron/pkg/api/websockets/v1"
+	"nhooyr.io/websocket"
+)
+
+const (
+	testCommunity = "test"
+	testMACA      = "02:00:00:00:00:0a"
+	testMACB      = "02:00:00:00:00:0b"
+	testMACC      = "02:00:00:00:00:0c"
+)
+
+type resignation struct {
+	mac  string
+	conn *websocket.Conn
+}
+
+func newTestConn(t *testing.T) *websocket.Conn {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(rw, r, nil)
+		if err != nil {
+			return
+		}
+
+		// Keep the connection open until the client closes it
+		_, _, _ = conn.Read(context.Background())
+	}))
+	t.Cleanup(srv.Close)
+
+	conn, _, err := websocket.Dial(context.Background(), "ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close(websocket.StatusNormalClosure, "")
+	})
+
+	return conn
+}
+
+func newTestCommunitiesManager(t *testing.T, networks map[string]*net.IPNet, leaseTTL time.Duration, resignations *[]resignation, exchanges *[]*websocket.Conn) *CommunitiesManager {
+	t.Helper()
+
+	var lock sync.Mutex
+
+	m := NewCommunitiesManager(
+		networks,
+		leaseTTL,
+		"",
+
+		func(mac string, conn *websocket.Conn) error {
+			return nil
+		},
+		func(mac string, exchange api.Exchange, conn *websocket.Conn) error {
+			lock.Lock()
+			defer lock.Unlock()
+
+			*exchanges = append(*exchanges, conn)
+
+			return nil
+		},
+		func(mac string, conn *websocket.Conn) error {
+			lock.Lock()
+			defer lock.Unlock()
+
+			*resignations = append(*resignations, resignation{mac, conn})
+
+			return nil
+		},
+	)
+
+	if err := m.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func TestHandleExitedIgnoresReplacedConnection(t *testing.T) {
+	resignations := []resignation{}
+	exchanges := []*websocket.Conn{}
+	m := newTestCommunitiesManager(t, nil, 0, &resignations, &exchanges)
+
+	a, previous, current := newTestConn(t), newTestConn(t), newTestConn(t)
+
+	for _, application := range []struct {
+		mac  string
+		conn *websocket.Conn
+	}{
+		{testMACA, a},
+		{testMACB, previous},
+		{testMACB, current}, // B reconnects before its previous connection has exited
+	} {
+		if _, err := m.HandleApplication(testCommunity, application.mac, application.conn); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := m.HandleExited(testCommunity, testMACB, previous, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resignations) != 0 {
+		t.Fatalf("exit of the replaced connection sent %v resignations, want none", len(resignations))
+	}
+
+	if err := m.HandleExchange(testCommunity, testMACA, api.Exchange{Mac: testMACB}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(exchanges) != 1 || exchanges[0] != current {
+		t.Fatal("exchange wasn't sent to the current connection")
+	}
+
+	if err := m.HandleExited(testCommunity, testMACB, current, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resignations) != 1 || resignations[0].mac != testMACB || resignations[0].conn != a {
+		t.Fatalf("exit of the current connection sent resignations %v, want one for %v", resignations, testMACB)
+	}
+}
+
+func TestHandleExitedKeepsLeaseOfReplacedConnection(t *testing.T) {
+	_, network, err := net.ParseCIDR("10.0.0.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaseTTL := time.Millisecond * 10
+
+	resignations := []resignation{}
+	exchanges := []*websocket.Conn{}
+	m := newTestCommunitiesManager(t, map[string]*net.IPNet{testCommunity: network}, leaseTTL, &resignations, &exchanges)
+
+	previous, current := newTestConn(t), newTestConn(t)
+
+	address, err := m.HandleApplication(testCommunity, testMACB, previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// B reconnects before its previous connection has exited
+	if _, err := m.HandleApplication(testCommunity, testMACB, current); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.HandleExited(testCommunity, testMACB, previous, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if expires := m.leases[testCommunity][testMACB].Expires; !expires.IsZero() {
+		t.Fatalf("exit of the replaced connection started expiry of the lease at %v, want none", expires)
+	}
+
+	// The lease would have expired by now if the exit had started its expiry
+	time.Sleep(leaseTTL * 2)
+
+	other, err := m.HandleApplication(testCommunity, testMACC, newTestConn(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if other == address {
+		t.Fatalf("address %v of a connected node was leased to another node", address)
+	}
+
+	if err := m.HandleExited(testCommunity, testMACB, current, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if m.leases[testCommunity][testMACB].Expires.IsZero() {
+		t.Fatal("exit of the current connection didn't start expiry of the lease")
+	}
+}
@@ -0,0 +1,109 @@
+package signaling
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pojntfx/weron/pkg/config"
+)
+
+const (
+	maxLeaseAttempts = 65536 // Maximum number of addresses to try before a community's network is considered to be exhausted
+)
+
+type Lease struct {
+	Community string    `json:"community"`
+	MAC       string    `json:"mac"`
+	Address   string    `json:"address"` // In CIDR notation, i.e. 10.0.0.1/24
+	Expires   time.Time `json:"expires"` // Zero while the node is connected
+}
+
+func (l *Lease) isExpired(now time.Time) bool {
+	return !l.Expires.IsZero() && now.After(l.Expires)
+}
+
+// getLeaseAddress derives an address from the MAC address so that a node gets the same address even if its lease has expired; attempt selects another address after a conflict
+func getLeaseAddress(network *net.IPNet, mac string, attempt uint64) (net.IP, bool) {
+	ones, bits := network.Mask.Size()
+	hostBits := bits - ones
+
+	sum := sha256.Sum256([]byte(mac))
+	offset := binary.BigEndian.Uint64(sum[:]) + attempt
+
+	// Only the lower 64 bits of the host part are used in larger networks
+	if hostBits < 64 {
+		size := uint64(1) << hostBits
+
+		offset %= size
+
+		// The network address and the IPv4 broadcast address can't be leased
+		if offset == 0 || (bits == 8*net.IPv4len && offset == size-1) {
+			return nil, false
+		}
+	} else if offset == 0 {
+		return nil, false
+	}
+
+	address := make(net.IP, len(network.IP))
+	copy(address, network.IP)
+
+	for i := len(address) - 1; i >= 0 && offset > 0; i-- {
+		address[i] |= byte(offset)
+
+		offset >>= 8
+	}
+
+	return address, true
+}
+
+func getLeaseAttempts(network *net.IPNet) uint64 {
+	ones, bits := network.Mask.Size()
+	if hostBits := bits - ones; hostBits < 16 {
+		return uint64(1) << hostBits
+	}
+
+	return maxLeaseAttempts
+}
+
+func readLeases(path string) ([]Lease, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		// No leases have been persisted yet
+		if errors.Is(err, os.ErrNotExist) {
+			return []Lease{}, nil
+		}
+
+		return nil, err
+	}
+
+	leases := []Lease{}
+	if err := json.Unmarshal(data, &leases); err != nil {
+		return nil, config.ErrCouldNotReadLeases
+	}
+
+	return leases, nil
+}
+
+func writeLeases(path string, leases []Lease) error {
+	data, err := json.MarshalIndent(leases, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so that the leases don't get lost if the signaler crashes while writing
+	if err := os.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
@@ -0,0 +1,302 @@
+package signaling
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	api "github.com/pojntfx/weron/pkg/api/websockets/v1"
+	"github.com/pojntfx/weron/pkg/config"
+	"nhooyr.io/websocket"
+)
+
+func mustParseNetwork(t *testing.T, cidr string) *net.IPNet {
+	t.Helper()
+
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return network
+}
+
+func newTestLeasesManager(t *testing.T, network *net.IPNet, leaseTTL time.Duration, leasesPath string) (*CommunitiesManager, error) {
+	t.Helper()
+
+	m := NewCommunitiesManager(
+		map[string]*net.IPNet{testCommunity: network},
+		leaseTTL,
+		leasesPath,
+
+		func(mac string, conn *websocket.Conn) error {
+			return nil
+		},
+		func(mac string, exchange api.Exchange, conn *websocket.Conn) error {
+			return nil
+		},
+		func(mac string, conn *websocket.Conn) error {
+			return nil
+		},
+	)
+
+	return m, m.Open()
+}
+
+func TestGetLeaseAddress(t *testing.T) {
+	for _, test := range []struct {
+		network string
+		usable  int
+	}{
+		{"10.0.0.0/29", 6}, // Without the network and broadcast addresses
+		{"10.0.0.0/24", 254},
+		{"fd00::/120", 255}, // IPv6 has no broadcast address
+		{"fd00::/64", 0},
+	} {
+		t.Run(test.network, func(t *testing.T) {
+			network := mustParseNetwork(t, test.network)
+
+			address, ok := getLeaseAddress(network, testMACA, 0)
+			if !ok {
+				t.Fatal("no address derived for the first attempt")
+			}
+
+			again, _ := getLeaseAddress(network, testMACA, 0)
+			if !address.Equal(again) {
+				t.Fatalf("derived %v and then %v, want the same address", address, again)
+			}
+
+			// The number of usable addresses can only be checked for networks which can be tried exhaustively
+			if test.usable == 0 {
+				if !network.Contains(address) {
+					t.Fatalf("derived address %v is outside of %v", address, network)
+				}
+
+				return
+			}
+
+			seen := map[string]bool{}
+			for attempt := uint64(0); attempt < getLeaseAttempts(network); attempt++ {
+				address, ok := getLeaseAddress(network, testMACA, attempt)
+				if !ok {
+					continue
+				}
+
+				if !network.Contains(address) {
+					t.Fatalf("derived address %v is outside of %v", address, network)
+				}
+
+				if address.Equal(network.IP) {
+					t.Fatalf("derived the network address %v", address)
+				}
+
+				seen[address.String()] = true
+			}
+
+			if len(seen) != test.usable {
+				t.Fatalf("derived %v different addresses, want %v", len(seen), test.usable)
+			}
+		})
+	}
+}
+
+func TestLeaseRetriesOnConflict(t *testing.T) {
+	network := mustParseNetwork(t, "10.0.0.0/28")
+
+	m, err := newTestLeasesManager(t, network, time.Minute, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := m.HandleApplication(testCommunity, testMACA, newTestConn(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, _ := getLeaseAddress(network, testMACA, 0)
+	if first != (&net.IPNet{IP: want, Mask: network.Mask}).String() {
+		t.Fatalf("got lease %v, want %v derived from the MAC address", first, want)
+	}
+
+	// Find a MAC address whose derived address is already leased to the first node
+	var conflicting string
+	for i := 0; i < 1024 && conflicting == ""; i++ {
+		mac := fmt.Sprintf("02:00:00:00:%02x:%02x", i>>8, i&0xff)
+
+		if address, ok := getLeaseAddress(network, mac, 0); ok && address.Equal(want) && mac != testMACA {
+			conflicting = mac
+		}
+	}
+	if conflicting == "" {
+		t.Fatal("could not find a MAC address with a conflicting address")
+	}
+
+	second, err := m.HandleApplication(testCommunity, conflicting, newTestConn(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if second == first {
+		t.Fatalf("address %v was leased twice", first)
+	}
+
+	// The next address which is still available is used instead
+	for attempt := uint64(1); ; attempt++ {
+		next, ok := getLeaseAddress(network, conflicting, attempt)
+		if !ok {
+			continue
+		}
+
+		if want := (&net.IPNet{IP: next, Mask: network.Mask}).String(); second != want {
+			t.Fatalf("got lease %v after a conflict, want %v", second, want)
+		}
+
+		break
+	}
+}
+
+func TestLeaseExpiresAndIsReused(t *testing.T) {
+	// Only the addresses .1 and .2 can be leased
+	network := mustParseNetwork(t, "10.0.0.0/30")
+	leaseTTL := time.Millisecond * 100
+
+	m, err := newTestLeasesManager(t, network, leaseTTL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := newTestConn(t)
+	addressA, err := m.HandleApplication(testCommunity, testMACA, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.HandleApplication(testCommunity, testMACB, newTestConn(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.HandleApplication(testCommunity, testMACC, newTestConn(t)); !errors.Is(err, config.ErrNetworkExhausted) {
+		t.Fatalf("leasing from a full network returned %v, want %v", err, config.ErrNetworkExhausted)
+	}
+
+	if err := m.HandleExited(testCommunity, testMACA, a, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The lease is kept for the node until it expires
+	if _, err := m.HandleApplication(testCommunity, testMACC, newTestConn(t)); !errors.Is(err, config.ErrNetworkExhausted) {
+		t.Fatalf("leasing an address which hasn't expired yet returned %v, want %v", err, config.ErrNetworkExhausted)
+	}
+
+	a = newTestConn(t)
+	renewed, err := m.HandleApplication(testCommunity, testMACA, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if renewed != addressA {
+		t.Fatalf("got lease %v after reconnecting, want %v", renewed, addressA)
+	}
+
+	if err := m.HandleExited(testCommunity, testMACA, a, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(leaseTTL * 2)
+
+	// Expired leases are given to other nodes
+	addressC, err := m.HandleApplication(testCommunity, testMACC, newTestConn(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if addressC != addressA {
+		t.Fatalf("got lease %v, want the expired lease %v", addressC, addressA)
+	}
+
+	if _, ok := m.leases[testCommunity][testMACA]; ok {
+		t.Fatal("expired lease wasn't removed")
+	}
+}
+
+func TestLeasesArePersisted(t *testing.T) {
+	network := mustParseNetwork(t, "10.0.0.0/24")
+	leasesPath := filepath.Join(t.TempDir(), "leases.json")
+	leaseTTL := time.Minute
+
+	m, err := newTestLeasesManager(t, network, leaseTTL, leasesPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addressA, err := m.HandleApplication(testCommunity, testMACA, newTestConn(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := newTestConn(t)
+	addressB, err := m.HandleApplication(testCommunity, testMACB, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.HandleExited(testCommunity, testMACB, b, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The signaler restarts
+	restarted, err := newTestLeasesManager(t, network, leaseTTL, leasesPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for mac, want := range map[string]string{testMACA: addressA, testMACB: addressB} {
+		l, ok := restarted.leases[testCommunity][mac]
+		if !ok {
+			t.Fatalf("lease of %v wasn't reloaded", mac)
+		}
+
+		if l.Address != want {
+			t.Fatalf("reloaded lease %v for %v, want %v", l.Address, mac, want)
+		}
+
+		// Nodes which were connected before the restart have to reconnect before their lease expires too
+		if l.Expires.IsZero() {
+			t.Fatalf("reloaded lease of %v doesn't expire", mac)
+		}
+	}
+
+	address, err := restarted.HandleApplication(testCommunity, testMACA, newTestConn(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if address != addressA {
+		t.Fatalf("got lease %v after the restart, want %v", address, addressA)
+	}
+
+	// Leases which don't fit the community's network anymore are dropped
+	changed, err := newTestLeasesManager(t, mustParseNetwork(t, "10.1.0.0/24"), leaseTTL, leasesPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if leases := changed.leases[testCommunity]; len(leases) != 0 {
+		t.Fatalf("leases %v outside of the changed network were reloaded", leases)
+	}
+}
+
+func TestOpenRejectsCorruptLeases(t *testing.T) {
+	leasesPath := filepath.Join(t.TempDir(), "leases.json")
+	if err := os.WriteFile(leasesPath, []byte("[{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := newTestLeasesManager(t, mustParseNetwork(t, "10.0.0.0/24"), time.Minute, leasesPath); !errors.Is(err, config.ErrCouldNotReadLeases) {
+		t.Fatalf("opening corrupt leases returned %v, want %v", err, config.ErrCouldNotReadLeases)
+	}
+}
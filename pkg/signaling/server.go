@@ -28,10 +28,10 @@ type SignalingServer struct {
 	ctx     context.Context
 	timeout time.Duration
 
-	onApplication func(community string, mac string, conn *websocket.Conn) error
+	onApplication func(community string, mac string, conn *websocket.Conn) (string, error)
 	onRejection   func(community string, mac string, conn *websocket.Conn) error
-	onAcceptance  func(community string, mac string, conn *websocket.Conn) error
-	onExited      func(community string, mac string, err error) error
+	onAcceptance  func(community string, mac string, address string, conn *websocket.Conn) error
+	onExited      func(community string, mac string, conn *websocket.Conn, err error) error
 	onReady       func(community string, mac string) error
 	onExchange    func(community string, mac string, exchange api.Exchange) error
 }
@@ -40,10 +40,10 @@ func NewSignalingServer(
 	ctx context.Context,
 	timeout time.Duration,
 
-	onApplication func(community string, mac string, conn *websocket.Conn) error,
+	onApplication func(community string, mac string, conn *websocket.Conn) (string, error),
 	onRejection func(community string, mac string, conn *websocket.Conn) error,
-	onAcceptance func(community string, mac string, conn *websocket.Conn) error,
-	onExited func(community string, mac string, err error) error,
+	onAcceptance func(community string, mac string, address string, conn *websocket.Conn) error,
+	onExited func(community string, mac string, conn *websocket.Conn, err error) error,
 	onReady func(community string, mac string) error,
 	onExchange func(community string, mac string, exchange api.Exchange) error,
 ) *SignalingServer {
@@ -146,7 +146,8 @@ func (s *SignalingServer) HandleConn(conn *websocket.Conn) error {
 				}
 
 				// Handle application
-				if err := s.onApplication(application.Community, incomingMAC.String(), conn); err != nil {
+				address, err := s.onApplication(application.Community, incomingMAC.String(), conn)
+				if err != nil {
 					msg := config.ErrCouldNotHandleApplication.Error() + ": " + err.Error()
 
 					// Send rejection on error
@@ -164,7 +165,7 @@ func (s *SignalingServer) HandleConn(conn *websocket.Conn) error {
 				mac = incomingMAC.String()
 
 				// Send acceptance
-				if err := s.onAcceptance(community, mac, conn); err != nil {
+				if err := s.onAcceptance(community, mac, address, conn); err != nil {
 					fatal <- err
 
 					return
@@ -210,7 +211,7 @@ func (s *SignalingServer) HandleConn(conn *websocket.Conn) error {
 			// Discharge
 			case api.TypeExited:
 				// Handle exited
-				if err := s.onExited(community, mac, nil); err != nil {
+				if err := s.onExited(community, mac, conn, nil); err != nil {
 					fatal <- fmt.Errorf("%v: %v", config.ErrCouldNotHandleExited, err)
 
 					return
@@ -236,7 +237,7 @@ func (s *SignalingServer) HandleConn(conn *websocket.Conn) error {
 	s.lock.Unlock()
 
 	// Handle exited; ignore the error as it might be a no-op
-	_ = s.onExited(community, mac, err)
+	_ = s.onExited(community, mac, conn, err)
 
 	// Handle error during application; the connection might not be added to any community yet, so close directly
 	if community == invalidCommunity && mac == invalidMAC && err != nil {
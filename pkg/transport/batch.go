@@ -0,0 +1,81 @@
+package transport
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/pojntfx/weron/pkg/config"
+)
+
+const (
+	batchProtocol = "weron-batch"
+	lengthPrefix  = 2
+
+	MaxBatchSize = 65535 // Largest message pion's SCTP implementation accepts
+
+	maxMessageOverhead = 64                                // Nonce and tag added by encryption and compression header, with room to spare
+	maxBatchPayload    = MaxBatchSize - maxMessageOverhead // Batches are encoded as a whole, so their frames can't add up to more than this
+)
+
+type Batching struct {
+	MaxSize  int           // Send a batch once it holds this many bytes; 0 disables batching
+	MaxDelay time.Duration // Time to wait for more frames before sending an incomplete batch
+}
+
+func (b Batching) Validate() error {
+	if b.MaxSize < 0 || b.MaxSize > MaxBatchSize {
+		return config.ErrInvalidBatchSize
+	}
+
+	return nil
+}
+
+func appendFrame(batch []byte, frame []byte) []byte {
+	prefix := [lengthPrefix]byte{}
+	binary.BigEndian.PutUint16(prefix[:], uint16(len(frame)))
+
+	return append(append(batch, prefix[:]...), frame...)
+}
+
+func fillBatch(batch []byte, queue *sendQueue, pool *BufferPool, maxSize int, deadline time.Time) ([]byte, uint64, []byte) {
+	// Add frames until the batch is full or no more frames arrive in time
+	frames := uint64(0)
+	for len(batch) < maxSize {
+		next, ok := queue.popUntil(deadline)
+		if !ok {
+			break
+		}
+
+		// The frame which doesn't fit anymore is carried over to the next batch
+		if len(batch)+lengthPrefix+len(next) > maxSize {
+			return batch, frames, next
+		}
+
+		batch = appendFrame(batch, next)
+		pool.Put(next)
+		frames++
+	}
+
+	return batch, frames, nil
+}
+
+func unpackFrames(batch []byte, onFrame func(frame []byte)) error {
+	for len(batch) > 0 {
+		if len(batch) < lengthPrefix {
+			return config.ErrInvalidBatch
+		}
+
+		length := int(binary.BigEndian.Uint16(batch))
+		batch = batch[lengthPrefix:]
+
+		if len(batch) < length {
+			return config.ErrInvalidBatch
+		}
+
+		onFrame(batch[:length])
+
+		batch = batch[length:]
+	}
+
+	return nil
+}
@@ -0,0 +1,150 @@
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pojntfx/weron/pkg/config"
+)
+
+func testFrame(length int, fill byte) []byte {
+	return bytes.Repeat([]byte{fill}, length)
+}
+
+func TestBatchRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		frames [][]byte
+	}{
+		{"one frame", [][]byte{testFrame(60, 1)}},
+		{"many frames", [][]byte{testFrame(60, 1), testFrame(1500, 2), testFrame(0, 3), testFrame(9000, 4)}},
+		{"max batch payload", [][]byte{testFrame(maxBatchPayload-lengthPrefix, 5)}},
+		{"max frame length", [][]byte{testFrame(0xffff, 6)}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			batch := []byte{}
+			for _, frame := range test.frames {
+				batch = appendFrame(batch, frame)
+			}
+
+			got := [][]byte{}
+			if err := unpackFrames(batch, func(frame []byte) {
+				got = append(got, append([]byte{}, frame...))
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got) != len(test.frames) {
+				t.Fatalf("unpacked %v frames, want %v", len(got), len(test.frames))
+			}
+
+			for i := range got {
+				if !bytes.Equal(got[i], test.frames[i]) {
+					t.Fatalf("frame %v has a length of %v, want %v", i, len(got[i]), len(test.frames[i]))
+				}
+			}
+		})
+	}
+}
+
+func TestUnpackFramesRejectsMalformedBatches(t *testing.T) {
+	valid := appendFrame([]byte{}, testFrame(4, 1))
+
+	for _, test := range []struct {
+		name       string
+		batch      []byte
+		wantFrames int
+	}{
+		{"truncated prefix", []byte{0x00}, 0},
+		{"truncated prefix after frame", append(append([]byte{}, valid...), 0x00), 1},
+		{"oversized prefix", []byte{0x00, 0x05, 1, 2, 3, 4}, 0},
+		{"oversized prefix after frame", append(append([]byte{}, valid...), 0xff, 0xff, 1, 2), 1},
+		{"prefix without frame", []byte{0x00, 0x01}, 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			frames := 0
+			if err := unpackFrames(test.batch, func(frame []byte) {
+				frames++
+			}); !errors.Is(err, config.ErrInvalidBatch) {
+				t.Fatalf("unpacking returned %v, want %v", err, config.ErrInvalidBatch)
+			}
+
+			// Frames in front of the malformed part are still received
+			if frames != test.wantFrames {
+				t.Fatalf("unpacked %v frames, want %v", frames, test.wantFrames)
+			}
+		})
+	}
+}
+
+func TestFillBatch(t *testing.T) {
+	first := testFrame(100, 1)
+	maxSize := lengthPrefix + len(first) + 2*(lengthPrefix+100)
+
+	for _, test := range []struct {
+		name       string
+		queued     [][]byte
+		wantFrames uint64
+		wantCarry  []byte
+	}{
+		{"empty queue", [][]byte{}, 0, nil},
+		{"fits", [][]byte{testFrame(100, 2)}, 1, nil},
+		{"exactly full", [][]byte{testFrame(100, 2), testFrame(100, 3), testFrame(100, 4)}, 2, nil},
+		{"carry over", [][]byte{testFrame(100, 2), testFrame(101, 3)}, 1, testFrame(101, 3)},
+		{"carry over after first frame", [][]byte{testFrame(maxSize, 2)}, 0, testFrame(maxSize, 2)},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			pool := NewBufferPool(maxSize)
+			q := newSendQueue(len(test.queued)+1, DropTail, pool)
+			for _, frame := range test.queued {
+				q.push(append([]byte{}, frame...))
+			}
+
+			batch, frames, carry := fillBatch(appendFrame([]byte{}, first), q, pool, maxSize, time.Now().Add(10*time.Millisecond))
+
+			if frames != test.wantFrames {
+				t.Fatalf("added %v frames, want %v", frames, test.wantFrames)
+			}
+
+			if !bytes.Equal(carry, test.wantCarry) {
+				t.Fatalf("carried over a frame with a length of %v, want %v", len(carry), len(test.wantCarry))
+			}
+
+			if len(batch) > maxSize {
+				t.Fatalf("batch has a length of %v, which exceeds the maximum of %v", len(batch), maxSize)
+			}
+
+			// Frames behind the carried over one stay queued for the next batch
+			wantDepth := len(test.queued) - int(test.wantFrames)
+			if carry != nil {
+				wantDepth--
+			}
+
+			if depth := q.depth(); depth != wantDepth {
+				t.Fatalf("queue has a depth of %v, want %v", depth, wantDepth)
+			}
+
+			got := 0
+			if err := unpackFrames(batch, func(frame []byte) {
+				want := first
+				if got > 0 {
+					want = test.queued[got-1]
+				}
+
+				if !bytes.Equal(frame, want) {
+					t.Fatalf("frame %v has a length of %v, want %v", got, len(frame), len(want))
+				}
+
+				got++
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			if got != int(test.wantFrames)+1 {
+				t.Fatalf("batch contains %v frames, want %v", got, test.wantFrames+1)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package transport
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress"
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pion/webrtc/v3"
+	"github.com/pojntfx/weron/pkg/config"
+)
+
+type Compression string
+
+const (
+	CompressionNone Compression = ""
+	CompressionZstd Compression = "zstd"
+	CompressionS2   Compression = "s2"
+
+	compressionAttribute = "a=x-weron-compression:"
+	compressibility      = 0.1   // Frames which are estimated to be less compressible than this are sent as-is
+	maxFrameSize         = 65535 // Decompressed frames can't be larger than this
+
+	frameUncompressed byte = 0
+	frameCompressed   byte = 1
+)
+
+func ValidateCompressions(compressions []Compression) error {
+	for _, c := range compressions {
+		if c != CompressionZstd && c != CompressionS2 {
+			return config.ErrUnknownCompression
+		}
+	}
+
+	return nil
+}
+
+type compressor struct {
+	// Encoders and decoders aren't safe to use concurrently, so each queue and inbox takes its own from the pools
+	encoders sync.Pool
+	decoders sync.Pool
+}
+
+func newCompressor() *compressor {
+	return &compressor{
+		encoders: sync.Pool{
+			New: func() interface{} {
+				// Can't fail as the options are valid
+				encoder, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithEncoderConcurrency(1))
+
+				return encoder
+			},
+		},
+		decoders: sync.Pool{
+			New: func() interface{} {
+				// Can't fail as the options are valid
+				decoder, _ := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxFrameSize), zstd.WithDecoderConcurrency(1))
+
+				return decoder
+			},
+		},
+	}
+}
+
+func (c *compressor) compress(algorithm Compression, frame []byte) []byte {
+	uncompressed := append([]byte{frameUncompressed}, frame...)
+
+	// Don't waste time on frames which are unlikely to shrink, i.e. because they carry compressed or encrypted payloads
+	if compress.Estimate(frame) < compressibility {
+		return uncompressed
+	}
+
+	compressed := []byte{frameCompressed}
+	switch algorithm {
+	case CompressionZstd:
+		encoder := c.encoders.Get().(*zstd.Encoder)
+		compressed = encoder.EncodeAll(frame, compressed)
+		c.encoders.Put(encoder)
+	case CompressionS2:
+		compressed = append(compressed, s2.Encode(nil, frame)...)
+	}
+
+	if len(compressed) >= len(uncompressed) {
+		return uncompressed
+	}
+
+	return compressed
+}
+
+func (c *compressor) decompress(algorithm Compression, frame []byte) ([]byte, error) {
+	if len(frame) < 1 {
+		return nil, config.ErrInvalidCompressedFrame
+	}
+
+	switch frame[0] {
+	case frameUncompressed:
+		return frame[1:], nil
+	case frameCompressed:
+	default:
+		return nil, config.ErrInvalidCompressedFrame
+	}
+
+	switch algorithm {
+	case CompressionZstd:
+		decoder := c.decoders.Get().(*zstd.Decoder)
+		defer c.decoders.Put(decoder)
+
+		return decoder.DecodeAll(frame[1:], nil)
+	case CompressionS2:
+		if length, err := s2.DecodedLen(frame[1:]); err != nil || length > maxFrameSize {
+			return nil, config.ErrInvalidCompressedFrame
+		}
+
+		return s2.Decode(nil, frame[1:])
+	}
+
+	return nil, config.ErrInvalidCompressedFrame
+}
+
+func withCompressions(description webrtc.SessionDescription, compressions []Compression) webrtc.SessionDescription {
+	if len(compressions) == 0 {
+		return description
+	}
+
+	values := []string{}
+	for _, c := range compressions {
+		values = append(values, string(c))
+	}
+
+	// pion ignores unknown attributes, so the supported algorithms can be announced in the SDP
+	description.SDP += compressionAttribute + strings.Join(values, " ") + "\r\n"
+
+	return description
+}
+
+func getCompressions(description webrtc.SessionDescription) []Compression {
+	compressions := []Compression{}
+	for _, line := range strings.Split(description.SDP, "\n") {
+		line = strings.TrimSpace(line)
+
+		if !strings.HasPrefix(line, compressionAttribute) {
+			continue
+		}
+
+		for _, value := range strings.Fields(strings.TrimPrefix(line, compressionAttribute)) {
+			compressions = append(compressions, Compression(value))
+		}
+	}
+
+	return compressions
+}
+
+func compressionsOf(c Compression) []Compression {
+	if c == CompressionNone {
+		return []Compression{}
+	}
+
+	return []Compression{c}
+}
+
+func chooseCompression(local []Compression, remote []Compression) Compression {
+	// The offering peer's preference wins
+	for _, candidate := range remote {
+		for _, c := range local {
+			if c == candidate {
+				return c
+			}
+		}
+	}
+
+	return CompressionNone
+}
@@ -0,0 +1,104 @@
+package transport
+
+import "encoding/binary"
+
+const (
+	ethernetHeaderLength = 14
+	vlanTagLength        = 4
+
+	etherTypeIPv4 = 0x0800
+	etherTypeIPv6 = 0x86dd
+	etherTypeVLAN = 0x8100
+
+	protocolTCP     = 6
+	protocolUDP     = 17
+	protocolSCTP    = 132
+	protocolUDPLite = 136
+
+	fnvOffset = 2166136261
+	fnvPrime  = 16777619
+)
+
+// GetFrameFlowHash hashes the addresses and ports of the IP packet in an Ethernet frame, or the frame's MAC addresses if it doesn't contain one, so that all frames of a flow get the same hash
+func GetFrameFlowHash(frame []byte) uint32 {
+	if len(frame) < ethernetHeaderLength {
+		return 0
+	}
+
+	offset := ethernetHeaderLength
+	etherType := binary.BigEndian.Uint16(frame[12:14])
+	if etherType == etherTypeVLAN && len(frame) >= ethernetHeaderLength+vlanTagLength {
+		offset += vlanTagLength
+		etherType = binary.BigEndian.Uint16(frame[16:18])
+	}
+
+	if etherType == etherTypeIPv4 || etherType == etherTypeIPv6 {
+		if hash, ok := getPacketFlowHash(frame[offset:]); ok {
+			return hash
+		}
+	}
+
+	return fnv(fnvOffset, frame[:12])
+}
+
+// GetPacketFlowHash hashes the addresses and ports of an IP packet so that all packets of a flow get the same hash
+func GetPacketFlowHash(packet []byte) uint32 {
+	hash, _ := getPacketFlowHash(packet)
+
+	return hash
+}
+
+func getPacketFlowHash(packet []byte) (uint32, bool) {
+	if len(packet) == 0 {
+		return 0, false
+	}
+
+	hash := uint32(fnvOffset)
+	switch packet[0] >> 4 {
+	case 4:
+		if len(packet) < ipv4HeaderLength {
+			return 0, false
+		}
+
+		protocol := packet[9]
+		hash = fnv(hash, packet[12:20])
+		hash = fnv(hash, []byte{protocol})
+
+		// Only unfragmented packets have ports, as fragments other than the first one don't carry them
+		headerLength := int(packet[0]&0x0f) * 4
+		if binary.BigEndian.Uint16(packet[6:8])&0x3fff == 0 && hasPorts(protocol) && len(packet) >= headerLength+4 {
+			hash = fnv(hash, packet[headerLength:headerLength+4])
+		}
+	case 6:
+		if len(packet) < ipv6HeaderLength {
+			return 0, false
+		}
+
+		// Packets with extension headers are hashed by their addresses only
+		next := packet[6]
+		hash = fnv(hash, packet[8:40])
+		hash = fnv(hash, []byte{next})
+
+		if hasPorts(next) && len(packet) >= ipv6HeaderLength+4 {
+			hash = fnv(hash, packet[ipv6HeaderLength:ipv6HeaderLength+4])
+		}
+	default:
+		return 0, false
+	}
+
+	return hash, true
+}
+
+func hasPorts(protocol byte) bool {
+	return protocol == protocolTCP || protocol == protocolUDP || protocol == protocolSCTP || protocol == protocolUDPLite
+}
+
+// fnv continues the FNV-1a hash with the data without allocating
+func fnv(hash uint32, data []byte) uint32 {
+	for _, b := range data {
+		hash ^= uint32(b)
+		hash *= fnvPrime
+	}
+
+	return hash
+}
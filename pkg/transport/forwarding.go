@@ -0,0 +1,80 @@
+package transport
+
+import (
+	"sync"
+	"time"
+)
+
+type forwardingEntry struct {
+	peer     string
+	lastSeen time.Time
+}
+
+type ForwardingTable struct {
+	entries map[string]forwardingEntry
+
+	ttl       time.Duration
+	lastPurge time.Time
+	lock      sync.Mutex
+}
+
+func NewForwardingTable(ttl time.Duration) *ForwardingTable {
+	return &ForwardingTable{
+		entries: map[string]forwardingEntry{},
+
+		ttl:       ttl,
+		lastPurge: time.Now(),
+	}
+}
+
+func (t *ForwardingTable) Learn(mac string, peer string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	now := time.Now()
+
+	t.entries[mac] = forwardingEntry{
+		peer:     peer,
+		lastSeen: now,
+	}
+
+	// Purge expired entries so that MACs which are never looked up again don't pile up
+	if now.Sub(t.lastPurge) > t.ttl {
+		for candidate, entry := range t.entries {
+			if now.Sub(entry.lastSeen) > t.ttl {
+				delete(t.entries, candidate)
+			}
+		}
+
+		t.lastPurge = now
+	}
+}
+
+func (t *ForwardingTable) Lookup(mac string) (string, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	entry, ok := t.entries[mac]
+	if !ok {
+		return "", false
+	}
+
+	if time.Since(entry.lastSeen) > t.ttl {
+		delete(t.entries, mac)
+
+		return "", false
+	}
+
+	return entry.peer, true
+}
+
+func (t *ForwardingTable) Forget(peer string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for candidate, entry := range t.entries {
+		if entry.peer == peer {
+			delete(t.entries, candidate)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package transport
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	testMACA = "02:00:00:00:00:0a"
+	testMACB = "02:00:00:00:00:0b"
+	testMACC = "02:00:00:00:00:0c"
+
+	testBridgedMAC = "02:00:00:00:01:00"
+	testUnknownMAC = "02:00:00:00:02:00"
+	testMulticast  = "33:33:00:00:00:01"
+	testBroadcast  = "ff:ff:ff:ff:ff:ff"
+)
+
+func TestForwardingTableLearnAndLookup(t *testing.T) {
+	fdb := NewForwardingTable(time.Minute)
+
+	if _, ok := fdb.Lookup(testBridgedMAC); ok {
+		t.Fatal("found a MAC which hasn't been learned")
+	}
+
+	fdb.Learn(testBridgedMAC, testMACA)
+	if peer, ok := fdb.Lookup(testBridgedMAC); !ok || peer != testMACA {
+		t.Fatalf("lookup returned %v, %v, want %v, true", peer, ok, testMACA)
+	}
+
+	// A MAC which moved behind another peer is re-learned from its new location
+	fdb.Learn(testBridgedMAC, testMACB)
+	if peer, ok := fdb.Lookup(testBridgedMAC); !ok || peer != testMACB {
+		t.Fatalf("lookup after move returned %v, %v, want %v, true", peer, ok, testMACB)
+	}
+}
+
+func TestForwardingTableExpiry(t *testing.T) {
+	ttl := 50 * time.Millisecond
+	fdb := NewForwardingTable(ttl)
+
+	fdb.Learn(testBridgedMAC, testMACA)
+	time.Sleep(2 * ttl)
+
+	if peer, ok := fdb.Lookup(testBridgedMAC); ok {
+		t.Fatalf("lookup of an expired entry returned %v", peer)
+	}
+
+	if _, ok := fdb.entries[testBridgedMAC]; ok {
+		t.Fatal("expired entry wasn't deleted on lookup")
+	}
+}
+
+func TestForwardingTablePurgesOnLearn(t *testing.T) {
+	ttl := 50 * time.Millisecond
+	fdb := NewForwardingTable(ttl)
+
+	fdb.Learn(testBridgedMAC, testMACA)
+	time.Sleep(2 * ttl)
+
+	// Learning another MAC purges entries which are never looked up again
+	fdb.Learn(testUnknownMAC, testMACB)
+
+	if _, ok := fdb.entries[testBridgedMAC]; ok {
+		t.Fatal("expired entry wasn't purged on learn")
+	}
+
+	if peer, ok := fdb.Lookup(testUnknownMAC); !ok || peer != testMACB {
+		t.Fatalf("lookup of the fresh entry returned %v, %v, want %v, true", peer, ok, testMACB)
+	}
+}
+
+func TestForwardingTableForget(t *testing.T) {
+	fdb := NewForwardingTable(time.Minute)
+
+	fdb.Learn(testBridgedMAC, testMACA)
+	fdb.Learn(testUnknownMAC, testMACA)
+	fdb.Learn(testMACC, testMACB)
+
+	fdb.Forget(testMACA)
+
+	for _, mac := range []string{testBridgedMAC, testUnknownMAC} {
+		if peer, ok := fdb.Lookup(mac); ok {
+			t.Fatalf("lookup of %v returned %v after its peer was forgotten", mac, peer)
+		}
+	}
+
+	if peer, ok := fdb.Lookup(testMACC); !ok || peer != testMACB {
+		t.Fatalf("lookup of an entry of another peer returned %v, %v, want %v, true", peer, ok, testMACB)
+	}
+}
+
+type testMemoryPeer struct {
+	*MemoryManager
+
+	lock     sync.Mutex
+	received []string
+}
+
+func (p *testMemoryPeer) takeReceived() []string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	received := p.received
+	p.received = nil
+
+	return received
+}
+
+func newTestMemoryPeers(t *testing.T, macTTL time.Duration, macs ...string) map[string]*testMemoryPeer {
+	t.Helper()
+
+	network := NewMemoryNetwork()
+	peers := map[string]*testMemoryPeer{}
+
+	for _, mac := range macs {
+		mac := mac
+		p := &testMemoryPeer{}
+
+		p.MemoryManager = NewMemoryManager(
+			mac,
+			network,
+			macTTL,
+
+			func(_ string, frame []byte) {
+				p.lock.Lock()
+				defer p.lock.Unlock()
+
+				p.received = append(p.received, string(frame))
+			},
+			func(remote string, o webrtc.SessionDescription) {
+				if err := peers[remote].HandleOffer(mac, o); err != nil {
+					t.Error(err)
+				}
+			},
+			func(remote string, o webrtc.SessionDescription) {
+				if err := peers[remote].HandleAnswer(mac, o); err != nil {
+					t.Error(err)
+				}
+			},
+			func(string) {},
+			func(string) {},
+		)
+
+		peers[mac] = p
+	}
+
+	t.Cleanup(func() {
+		for _, p := range peers {
+			_ = p.Close()
+		}
+	})
+
+	return peers
+}
+
+func TestMemoryManagerFloodsUnknownDestinations(t *testing.T) {
+	macTTL := 100 * time.Millisecond
+	peers := newTestMemoryPeers(t, macTTL, testMACA, testMACB, testMACC)
+
+	for _, mac := range []string{testMACB, testMACC} {
+		if err := peers[testMACA].HandleIntroduction(mac); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a := peers[testMACA]
+	a.Learn(testMACB, testBridgedMAC)
+
+	for _, test := range []struct {
+		name        string
+		destination string
+		wait        time.Duration
+		want        []string
+	}{
+		{"peer", testMACC, 0, []string{testMACC}},
+		{"learned", testBridgedMAC, 0, []string{testMACB}},
+		{"unknown unicast", testUnknownMAC, 0, []string{testMACB, testMACC}},
+		{"multicast", testMulticast, 0, []string{testMACB, testMACC}},
+		{"broadcast", testBroadcast, 0, []string{testMACB, testMACC}},
+		{"expired", testBridgedMAC, 2 * macTTL, []string{testMACB, testMACC}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			time.Sleep(test.wait)
+
+			if err := a.Write(test.destination, []byte(test.name)); err != nil {
+				t.Fatal(err)
+			}
+
+			got := []string{}
+			for _, mac := range []string{testMACB, testMACC} {
+				for _, frame := range peers[mac].takeReceived() {
+					if frame != test.name {
+						t.Fatalf("%v received %q, want %q", mac, frame, test.name)
+					}
+
+					got = append(got, mac)
+				}
+			}
+			sort.Strings(got)
+
+			if len(got) != len(test.want) {
+				t.Fatalf("frame was delivered to %v, want %v", got, test.want)
+			}
+
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Fatalf("frame was delivered to %v, want %v", got, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryManagerForgetsLearnedMACsOfClosedPeers(t *testing.T) {
+	peers := newTestMemoryPeers(t, time.Minute, testMACA, testMACB)
+
+	if err := peers[testMACA].HandleIntroduction(testMACB); err != nil {
+		t.Fatal(err)
+	}
+
+	a := peers[testMACA]
+	a.Learn(testMACB, testBridgedMAC)
+
+	if err := a.HandleResignation(testMACB); err != nil {
+		t.Fatal(err)
+	}
+
+	if peer, ok := a.fdb.Lookup(testBridgedMAC); ok {
+		t.Fatalf("MAC is still learned from %v after its peer resigned", peer)
+	}
+}
@@ -0,0 +1,291 @@
+package transport
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/pojntfx/weron/pkg/config"
+)
+
+type MemoryNetwork struct {
+	managers map[string]*MemoryManager
+
+	lock sync.Mutex
+}
+
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{
+		managers: map[string]*MemoryManager{},
+	}
+}
+
+func (n *MemoryNetwork) register(m *MemoryManager) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.managers[m.mac] = m
+}
+
+func (n *MemoryNetwork) unregister(m *MemoryManager) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	// Only remove the manager if it hasn't been replaced in the meantime
+	if candidate, ok := n.managers[m.mac]; ok && candidate == m {
+		delete(n.managers, m.mac)
+	}
+}
+
+func (n *MemoryNetwork) get(mac string) (*MemoryManager, bool) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	m, ok := n.managers[mac]
+
+	return m, ok
+}
+
+type MemoryManager struct {
+	mac     string
+	network *MemoryNetwork
+	peers   map[string]bool
+	fdb     *ForwardingTable
+
+	lock sync.Mutex
+
+	onReceive          func(mac string, frame []byte)
+	onOffer            func(mac string, o webrtc.SessionDescription)
+	onAnswer           func(mac string, o webrtc.SessionDescription)
+	onDataChannelOpen  func(mac string)
+	onDataChannelClose func(mac string)
+}
+
+func NewMemoryManager(
+	mac string,
+	network *MemoryNetwork,
+	macTTL time.Duration,
+
+	onReceive func(mac string, frame []byte),
+	onOffer func(mac string, o webrtc.SessionDescription),
+	onAnswer func(mac string, o webrtc.SessionDescription),
+	onDataChannelOpen func(mac string),
+	onDataChannelClose func(mac string),
+) *MemoryManager {
+	m := &MemoryManager{
+		mac:     mac,
+		network: network,
+		peers:   map[string]bool{},
+		fdb:     NewForwardingTable(macTTL),
+
+		onReceive:          onReceive,
+		onOffer:            onOffer,
+		onAnswer:           onAnswer,
+		onDataChannelOpen:  onDataChannelOpen,
+		onDataChannelClose: onDataChannelClose,
+	}
+
+	network.register(m)
+
+	return m
+}
+
+func (m *MemoryManager) HandleIntroduction(mac string) error {
+	m.lock.Lock()
+
+	// Keep existing connections, just like the WebRTC transport does for re-introduced peers
+	if _, ok := m.peers[mac]; ok {
+		m.lock.Unlock()
+
+		return nil
+	}
+
+	m.peers[mac] = false
+	m.lock.Unlock()
+
+	// There is nothing to negotiate in memory, so the descriptions only drive the signaling
+	m.onOffer(mac, webrtc.SessionDescription{Type: webrtc.SDPTypeOffer})
+
+	return nil
+}
+
+func (m *MemoryManager) HandleOffer(mac string, offer webrtc.SessionDescription) error {
+	if _, ok := m.network.get(mac); !ok {
+		return config.ErrConnectionDoesNotExist
+	}
+
+	m.establish(mac)
+
+	m.onAnswer(mac, webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer})
+
+	return nil
+}
+
+func (m *MemoryManager) HandleAnswer(mac string, answer webrtc.SessionDescription) error {
+	m.lock.Lock()
+	_, ok := m.peers[mac]
+	m.lock.Unlock()
+
+	// Ignore answers to offers we haven't sent
+	if !ok {
+		return nil
+	}
+
+	m.establish(mac)
+
+	return nil
+}
+
+func (m *MemoryManager) HandleCandidate(mac string, candidate webrtc.ICECandidateInit) error {
+	// There are no candidates in memory
+	return nil
+}
+
+func (m *MemoryManager) HandleResignation(mac string) error {
+	if !m.removePeer(mac) {
+		return config.ErrConnectionDoesNotExist
+	}
+
+	// Closing a connection closes it on both sides
+	if remote, ok := m.network.get(mac); ok {
+		remote.removePeer(m.mac)
+	}
+
+	return nil
+}
+
+func (m *MemoryManager) IsEstablished(mac string) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.peers[mac]
+}
+
+func (m *MemoryManager) Learn(mac string, source string) {
+	// Group addresses can't be the source of a frame, so don't learn them
+	if hw, err := net.ParseMAC(source); err != nil || isGroupMAC(hw) {
+		return
+	}
+
+	m.fdb.Learn(source, mac)
+}
+
+func (m *MemoryManager) Write(mac string, frame []byte) error {
+	m.lock.Lock()
+
+	destinations := []string{}
+	if established, ok := m.peers[mac]; ok {
+		// Frames for a peer's own MAC address can be sent to it directly
+		if !established {
+			m.lock.Unlock()
+
+			return ErrorConnectionHasNoDataChannel
+		}
+
+		destinations = append(destinations, mac)
+	} else if learned, ok := m.lookup(mac); ok {
+		// Frames for nodes bridged behind a peer can be sent to the peer they were learned from
+		destinations = append(destinations, learned)
+	} else {
+		// Flood broadcast, multicast and unknown unicast frames to all peers
+		for candidate, established := range m.peers {
+			if established {
+				destinations = append(destinations, candidate)
+			}
+		}
+	}
+
+	m.lock.Unlock()
+
+	// Deliver without holding the lock so that receivers can write back synchronously
+	for _, destination := range destinations {
+		remote, ok := m.network.get(destination)
+		if !ok {
+			// The remote manager has been closed; the WebRTC transport removes peers on send errors too
+			m.removePeer(destination)
+
+			continue
+		}
+
+		// The caller may reuse the buffer once Write returns
+		remote.receive(m.mac, append([]byte{}, frame...))
+	}
+
+	return nil
+}
+
+func (m *MemoryManager) Close() []error {
+	m.network.unregister(m)
+
+	m.lock.Lock()
+	peers := []string{}
+	for mac := range m.peers {
+		peers = append(peers, mac)
+	}
+	m.lock.Unlock()
+
+	for _, mac := range peers {
+		// Ignore as the peer might have resigned in the meantime
+		_ = m.HandleResignation(mac)
+	}
+
+	return []error{}
+}
+
+func (m *MemoryManager) lookup(mac string) (string, bool) {
+	hw, err := net.ParseMAC(mac)
+	if err != nil || isGroupMAC(hw) {
+		return "", false
+	}
+
+	learned, ok := m.fdb.Lookup(mac)
+	if !ok {
+		return "", false
+	}
+
+	return learned, m.peers[learned]
+}
+
+func (m *MemoryManager) establish(mac string) {
+	m.lock.Lock()
+	established := m.peers[mac]
+	m.peers[mac] = true
+	m.lock.Unlock()
+
+	if !established {
+		m.onDataChannelOpen(mac)
+	}
+}
+
+func (m *MemoryManager) removePeer(mac string) bool {
+	m.lock.Lock()
+	established, ok := m.peers[mac]
+	delete(m.peers, mac)
+	m.lock.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	m.fdb.Forget(mac)
+
+	if established {
+		m.onDataChannelClose(mac)
+	}
+
+	return true
+}
+
+func (m *MemoryManager) receive(mac string, frame []byte) {
+	m.lock.Lock()
+	established := m.peers[mac]
+	m.lock.Unlock()
+
+	// Frames can only be received over established connections
+	if !established {
+		return
+	}
+
+	m.onReceive(mac, frame)
+}
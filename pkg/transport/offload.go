@@ -0,0 +1,99 @@
+package transport
+
+import (
+	"encoding/binary"
+	"strings"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/pojntfx/weron/pkg/offload"
+)
+
+const (
+	offloadAttribute = "a=x-weron-offload"
+
+	maxOffloadFrameSize = maxBatchPayload - lengthPrefix // Unsegmented frames which are larger than this don't fit into one message
+
+	tcpFlagFIN = 0x01
+	tcpFlagPSH = 0x08
+	tcpFlagCWR = 0x80
+)
+
+func withOffload(description webrtc.SessionDescription, enabled bool) webrtc.SessionDescription {
+	if !enabled {
+		return description
+	}
+
+	// pion ignores unknown attributes, so support for offload can be announced in the SDP
+	description.SDP += offloadAttribute + "\r\n"
+
+	return description
+}
+
+func hasOffload(description webrtc.SessionDescription) bool {
+	for _, line := range strings.Split(description.SDP, "\n") {
+		if strings.TrimSpace(line) == offloadAttribute {
+			return true
+		}
+	}
+
+	return false
+}
+
+// segmentFrame splits a TCP segment which is larger than the maximum frame size into segments which fit, like the device would
+// have; it returns false if the frame isn't a TCP segment
+func segmentFrame(frame []byte, maxFrameSize int, onSegment func(segment []byte)) bool {
+	network, transport, ok := offload.GetTCPOffsets(frame)
+	if !ok {
+		return false
+	}
+
+	headerLength := transport + int(frame[transport+12]>>4)*4
+	if len(frame) < headerLength || maxFrameSize <= headerLength {
+		return false
+	}
+
+	ipv6 := frame[network]>>4 == 6
+	id := binary.BigEndian.Uint16(frame[network+4:])
+	sequence := binary.BigEndian.Uint32(frame[transport+4:])
+
+	payload := frame[headerLength:]
+	mss := maxFrameSize - headerLength
+	segment := make([]byte, maxFrameSize)
+	for offset := 0; offset < len(payload); offset += mss {
+		end := offset + mss
+		if end > len(payload) {
+			end = len(payload)
+		}
+
+		n := copy(segment, frame[:headerLength])
+		n += copy(segment[n:], payload[offset:end])
+		s := segment[:n]
+
+		if ipv6 {
+			binary.BigEndian.PutUint16(s[network+4:], uint16(n-network-ipv6HeaderLength))
+		} else {
+			binary.BigEndian.PutUint16(s[network+2:], uint16(n-network))
+			binary.BigEndian.PutUint16(s[network+4:], id+uint16(offset/mss))
+			binary.BigEndian.PutUint16(s[network+10:], 0)
+			binary.BigEndian.PutUint16(s[network+10:], ^offload.FoldChecksum(offload.SumChecksum(0, s[network:transport])))
+		}
+
+		binary.BigEndian.PutUint32(s[transport+4:], sequence+uint32(offset))
+
+		// Only the last segment finishes or pushes the data, and only the first one reduces the congestion window
+		if end < len(payload) {
+			s[transport+13] &^= tcpFlagFIN | tcpFlagPSH
+		}
+
+		if offset > 0 {
+			s[transport+13] &^= tcpFlagCWR
+		}
+
+		binary.BigEndian.PutUint16(s[transport+16:], 0)
+		binary.BigEndian.PutUint16(s[transport+16:], ^offload.FoldChecksum(offload.SumChecksum(offload.GetPseudoHeaderSum(s[network:], n-transport), s[transport:])))
+
+		onSegment(s)
+	}
+
+	return true
+}
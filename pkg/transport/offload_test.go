@@ -0,0 +1,261 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/mdlayher/ethernet"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/checksum"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+const (
+	testSequenceNumber = 1000
+	testIPv4ID         = 0x1234
+)
+
+// getTestTCPChecksum calculates the checksum of a TCP segment with gVisor instead of this package
+func getTestTCPChecksum(src, dst tcpip.Address, segment header.TCP) uint16 {
+	tcp := header.TCP(append([]byte{}, segment...))
+	tcp.SetChecksum(0)
+
+	return ^tcp.CalculateChecksum(checksum.Checksum(tcp.Payload(), header.PseudoHeaderChecksum(header.TCPProtocolNumber, src, dst, uint16(len(tcp)))))
+}
+
+// getTestIPv4Checksum calculates the checksum of an IPv4 header with gVisor instead of this package
+func getTestIPv4Checksum(packet header.IPv4) uint16 {
+	ipv4 := header.IPv4(append([]byte{}, packet[:packet.HeaderLength()]...))
+	ipv4.SetChecksum(0)
+
+	return ^ipv4.CalculateChecksum()
+}
+
+// newTestTCPFrame creates a TCP segment with valid checksums and the TCP options padded with NOPs
+func newTestTCPFrame(ipv6, tagged bool, tcpOptionsLength int, flags header.TCPFlags, payloadLength int) []byte {
+	payload := make([]byte, payloadLength)
+	for i := range payload {
+		payload[i] = byte(i % 251)
+	}
+
+	tcp := header.TCP(make([]byte, header.TCPMinimumSize+tcpOptionsLength))
+	tcp.Encode(&header.TCPFields{
+		SrcPort:    1234,
+		DstPort:    80,
+		SeqNum:     testSequenceNumber,
+		AckNum:     2000,
+		DataOffset: uint8(len(tcp)),
+		Flags:      flags,
+		WindowSize: 65535,
+	})
+	for i := header.TCPMinimumSize; i < len(tcp); i++ {
+		tcp[i] = header.TCPOptionNOP
+	}
+	tcp = append(tcp, payload...)
+
+	var ip []byte
+	var src, dst tcpip.Address
+	etherType := ethernet.EtherTypeIPv4
+	if ipv6 {
+		src, dst = tcpip.AddrFrom16Slice(net.ParseIP("fd00::1")), tcpip.AddrFrom16Slice(net.ParseIP("fd00::2"))
+		etherType = ethernet.EtherTypeIPv6
+
+		ipv6 := header.IPv6(make([]byte, header.IPv6MinimumSize))
+		ipv6.Encode(&header.IPv6Fields{
+			PayloadLength:     uint16(len(tcp)),
+			TransportProtocol: header.TCPProtocolNumber,
+			HopLimit:          64,
+			SrcAddr:           src,
+			DstAddr:           dst,
+		})
+
+		ip = ipv6
+	} else {
+		src, dst = tcpip.AddrFrom4Slice(net.ParseIP("10.0.0.1").To4()), tcpip.AddrFrom4Slice(net.ParseIP("10.0.0.2").To4())
+
+		ipv4 := header.IPv4(make([]byte, header.IPv4MinimumSize))
+		ipv4.Encode(&header.IPv4Fields{
+			TotalLength: uint16(len(ipv4) + len(tcp)),
+			ID:          testIPv4ID,
+			TTL:         64,
+			Protocol:    uint8(header.TCPProtocolNumber),
+			SrcAddr:     src,
+			DstAddr:     dst,
+		})
+		ipv4.SetChecksum(getTestIPv4Checksum(ipv4))
+
+		ip = ipv4
+	}
+
+	tcp.SetChecksum(getTestTCPChecksum(src, dst, tcp))
+
+	frame := make([]byte, 12)
+	if tagged {
+		frame = binary.BigEndian.AppendUint16(frame, uint16(ethernet.EtherTypeVLAN))
+		frame = binary.BigEndian.AppendUint16(frame, 10)
+	}
+	frame = binary.BigEndian.AppendUint16(frame, uint16(etherType))
+
+	frame = append(frame, ip...)
+
+	return append(frame, tcp...)
+}
+
+func TestSegmentFrame(t *testing.T) {
+	allFlags := header.TCPFlagAck | header.TCPFlagPsh | header.TCPFlagFin | header.TCPFlagCwr
+
+	for _, test := range []struct {
+		name             string
+		ipv6             bool
+		tagged           bool
+		tcpOptionsLength int
+		flags            header.TCPFlags
+		payloadLength    int
+		maxFrameSize     int
+		wantSizes        []int // Payload sizes of the segments
+	}{
+		{"ipv4", false, false, 0, allFlags, 4000, 1514, []int{1460, 1460, 1080}},
+		{"ipv6", true, false, 0, allFlags, 4000, 1514, []int{1440, 1440, 1120}},
+		{"tagged ipv4", false, true, 0, allFlags, 4000, 1518, []int{1460, 1460, 1080}},
+		{"tagged ipv6", true, true, 0, allFlags, 4000, 1518, []int{1440, 1440, 1120}},
+		{"ipv4 with tcp options", false, false, 12, allFlags, 4000, 1514, []int{1448, 1448, 1104}},
+		{"ipv6 with tcp options", true, false, 12, allFlags, 4000, 1514, []int{1428, 1428, 1144}},
+		{"multiple of the mss", false, false, 0, allFlags, 2920, 1514, []int{1460, 1460}},
+		{"smaller than the mss", false, false, 0, allFlags, 1000, 1514, []int{1000}},
+		{"without psh and fin", true, false, 0, header.TCPFlagAck, 3000, 1514, []int{1440, 1440, 120}},
+		{"maximum ipv4 length", false, false, 0, header.TCPFlagAck | header.TCPFlagPsh, 65495, 9014, []int{8960, 8960, 8960, 8960, 8960, 8960, 8960, 2775}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			frame := newTestTCPFrame(test.ipv6, test.tagged, test.tcpOptionsLength, test.flags, test.payloadLength)
+			original := append([]byte{}, frame...)
+
+			network, transport := 14, 0
+			if test.tagged {
+				network += 4
+			}
+			if test.ipv6 {
+				transport = network + header.IPv6MinimumSize
+			} else {
+				transport = network + header.IPv4MinimumSize
+			}
+			headerLength := transport + header.TCPMinimumSize + test.tcpOptionsLength
+
+			segments := [][]byte{}
+			if !segmentFrame(frame, test.maxFrameSize, func(segment []byte) {
+				// The segment is reused for the next one
+				segments = append(segments, append([]byte{}, segment...))
+			}) {
+				t.Fatal("frame wasn't segmented")
+			}
+
+			if !bytes.Equal(frame, original) {
+				t.Fatal("segmenting modified the frame")
+			}
+
+			if len(segments) != len(test.wantSizes) {
+				t.Fatalf("got %v segments, want %v", len(segments), len(test.wantSizes))
+			}
+
+			payload := []byte{}
+			for i, segment := range segments {
+				last := i == len(segments)-1
+
+				if len(segment) > test.maxFrameSize {
+					t.Fatalf("segment %v has a length of %v, which is larger than the maximum frame size %v", i, len(segment), test.maxFrameSize)
+				}
+
+				if size := len(segment) - headerLength; size != test.wantSizes[i] {
+					t.Fatalf("segment %v has a payload of %v bytes, want %v", i, size, test.wantSizes[i])
+				}
+
+				if !bytes.Equal(segment[:network], frame[:network]) {
+					t.Fatalf("segment %v has the Ethernet header %x, want %x", i, segment[:network], frame[:network])
+				}
+
+				var src, dst tcpip.Address
+				if test.ipv6 {
+					ipv6 := header.IPv6(segment[network:])
+					src, dst = ipv6.SourceAddress(), ipv6.DestinationAddress()
+
+					if length := int(ipv6.PayloadLength()); length != len(segment)-transport {
+						t.Fatalf("segment %v has a payload length of %v, want %v", i, length, len(segment)-transport)
+					}
+				} else {
+					ipv4 := header.IPv4(segment[network:])
+					src, dst = ipv4.SourceAddress(), ipv4.DestinationAddress()
+
+					if length := int(ipv4.TotalLength()); length != len(segment)-network {
+						t.Fatalf("segment %v has a total length of %v, want %v", i, length, len(segment)-network)
+					}
+
+					// Segments get their own IDs, like they would have if they had been sent without offload
+					if id := ipv4.ID(); id != testIPv4ID+uint16(i) {
+						t.Fatalf("segment %v has the ID %#04x, want %#04x", i, id, testIPv4ID+uint16(i))
+					}
+
+					if got, want := ipv4.Checksum(), getTestIPv4Checksum(ipv4); got != want {
+						t.Fatalf("segment %v has the IPv4 checksum %#04x, want %#04x", i, got, want)
+					}
+				}
+
+				tcp := header.TCP(segment[transport:])
+
+				if sequence := tcp.SequenceNumber(); sequence != testSequenceNumber+uint32(len(payload)) {
+					t.Fatalf("segment %v has the sequence number %v, want %v", i, sequence, testSequenceNumber+len(payload))
+				}
+
+				wantFlags := test.flags
+				if !last {
+					wantFlags &^= header.TCPFlagPsh | header.TCPFlagFin
+				}
+				if i > 0 {
+					wantFlags &^= header.TCPFlagCwr
+				}
+
+				if flags := tcp.Flags(); flags != wantFlags {
+					t.Fatalf("segment %v has the flags %v, want %v", i, flags, wantFlags)
+				}
+
+				if !bytes.Equal(tcp.Options(), header.TCP(frame[transport:]).Options()) {
+					t.Fatalf("segment %v has the options %x, want %x", i, tcp.Options(), header.TCP(frame[transport:]).Options())
+				}
+
+				if got, want := tcp.Checksum(), getTestTCPChecksum(src, dst, tcp); got != want {
+					t.Fatalf("segment %v has the TCP checksum %#04x, want %#04x", i, got, want)
+				}
+
+				payload = append(payload, tcp.Payload()...)
+			}
+
+			if !bytes.Equal(payload, frame[headerLength:]) {
+				t.Fatal("payloads of the segments don't add up to the payload of the frame")
+			}
+		})
+	}
+}
+
+func TestSegmentFrameRejectsFrames(t *testing.T) {
+	udp := newTestTCPFrame(false, false, 0, header.TCPFlagAck, 4000)
+	udp[14+9] = uint8(header.UDPProtocolNumber)
+
+	for _, test := range []struct {
+		name         string
+		frame        []byte
+		maxFrameSize int
+	}{
+		{"udp", udp, 1514},
+		{"truncated", newTestTCPFrame(false, false, 0, header.TCPFlagAck, 4000)[:40], 1514},
+		{"truncated tcp options", newTestTCPFrame(false, false, 12, header.TCPFlagAck, 0)[:60], 1514},
+		{"no room for the payload", newTestTCPFrame(false, false, 0, header.TCPFlagAck, 4000), 54},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if segmentFrame(test.frame, test.maxFrameSize, func(segment []byte) {
+				t.Fatal("got a segment")
+			}) {
+				t.Fatal("frame was segmented")
+			}
+		})
+	}
+}
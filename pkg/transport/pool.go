@@ -0,0 +1,52 @@
+package transport
+
+import (
+	"sync"
+)
+
+type BufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+func NewBufferPool(size int) *BufferPool {
+	p := &BufferPool{
+		size: size,
+	}
+
+	p.pool.New = func() interface{} {
+		b := make([]byte, size)
+
+		return &b
+	}
+
+	return p
+}
+
+func (p *BufferPool) Get() []byte {
+	b := p.pool.Get().(*[]byte)
+
+	return (*b)[:p.size]
+}
+
+func (p *BufferPool) Copy(data []byte) []byte {
+	// Data which doesn't fit into a buffer from the pool can't be returned to it later either
+	if len(data) > p.size {
+		return append([]byte{}, data...)
+	}
+
+	b := p.Get()
+
+	return b[:copy(b, data)]
+}
+
+func (p *BufferPool) Put(b []byte) {
+	// Buffers which weren't taken from the pool are too small to be re-used
+	if cap(b) < p.size {
+		return
+	}
+
+	b = b[:p.size]
+
+	p.pool.Put(&b)
+}
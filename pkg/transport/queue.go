@@ -0,0 +1,246 @@
+package transport
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pojntfx/weron/pkg/config"
+)
+
+type DropPolicy string
+
+const (
+	DropTail DropPolicy = "tail" // Drop the frame which is being queued
+	DropHead DropPolicy = "head" // Drop the oldest queued frame
+)
+
+type Queueing struct {
+	Length        int
+	ReceiveLength int // Frames received from a peer are held back by the peer once this many are queued
+	Policy        DropPolicy
+	HighWatermark uint64 // Stop sending to a peer once this many bytes are buffered by its data channel
+	LowWatermark  uint64 // Resume sending to a peer once its buffered bytes have fallen to this amount
+	Queues        int    // Number of queues for each peer, which are drained in parallel; 0 uses one queue
+}
+
+func (q Queueing) Validate() error {
+	if q.Length <= 0 || q.ReceiveLength <= 0 {
+		return config.ErrInvalidQueueLength
+	}
+
+	if q.Policy != DropTail && q.Policy != DropHead {
+		return config.ErrInvalidDropPolicy
+	}
+
+	if q.LowWatermark > q.HighWatermark {
+		return config.ErrInvalidWatermarks
+	}
+
+	if q.Queues < 0 {
+		return config.ErrInvalidQueueCount
+	}
+
+	return nil
+}
+
+func (q Queueing) getQueues() int {
+	if q.Queues < 1 {
+		return 1
+	}
+
+	return q.Queues
+}
+
+type sendQueue struct {
+	frames [][]byte
+	length int
+	policy DropPolicy
+	pool   *BufferPool
+	closed bool
+	lock   sync.Mutex
+
+	notify chan struct{}
+	low    chan struct{}
+	done   chan struct{}
+}
+
+func newSendQueue(length int, policy DropPolicy, pool *BufferPool) *sendQueue {
+	return &sendQueue{
+		frames: [][]byte{},
+		length: length,
+		policy: policy,
+		pool:   pool,
+
+		notify: make(chan struct{}, 1),
+		low:    make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+}
+
+func (q *sendQueue) push(frame []byte) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.closed {
+		q.pool.Put(frame)
+
+		return false
+	}
+
+	dropped := false
+	if len(q.frames) >= q.length {
+		if q.policy == DropTail {
+			q.pool.Put(frame)
+
+			return false
+		}
+
+		q.pool.Put(q.frames[0])
+		q.frames = q.frames[1:]
+
+		dropped = true
+	}
+
+	q.frames = append(q.frames, frame)
+
+	wake(q.notify)
+
+	return !dropped
+}
+
+func (q *sendQueue) depth() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return len(q.frames)
+}
+
+func (q *sendQueue) pop() ([]byte, bool) {
+	for {
+		q.lock.Lock()
+		if len(q.frames) > 0 {
+			frame := q.frames[0]
+			q.frames = q.frames[1:]
+			q.lock.Unlock()
+
+			return frame, true
+		}
+		q.lock.Unlock()
+
+		select {
+		case <-q.notify:
+		case <-q.done:
+			return nil, false
+		}
+	}
+}
+
+func (q *sendQueue) popUntil(deadline time.Time) ([]byte, bool) {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	for {
+		q.lock.Lock()
+		if len(q.frames) > 0 {
+			frame := q.frames[0]
+			q.frames = q.frames[1:]
+			q.lock.Unlock()
+
+			return frame, true
+		}
+		q.lock.Unlock()
+
+		select {
+		case <-q.notify:
+		case <-timer.C:
+			return nil, false
+		case <-q.done:
+			return nil, false
+		}
+	}
+}
+
+func (q *sendQueue) waitForLow() bool {
+	select {
+	case <-q.low:
+		return true
+	case <-q.done:
+		return false
+	}
+}
+
+func (q *sendQueue) close() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.closed {
+		return
+	}
+
+	q.closed = true
+
+	for _, frame := range q.frames {
+		q.pool.Put(frame)
+	}
+	q.frames = nil
+
+	close(q.done)
+}
+
+type receiveQueue struct {
+	bytes  uint64
+	frames chan []byte
+	done   chan struct{}
+	once   sync.Once
+}
+
+func newReceiveQueue(length int) *receiveQueue {
+	return &receiveQueue{
+		frames: make(chan []byte, length),
+		done:   make(chan struct{}),
+	}
+}
+
+func (q *receiveQueue) push(frame []byte) {
+	// Block while the queue is full so that the data channel stops reading and SCTP flow control slows down the peer
+	select {
+	case q.frames <- frame:
+	case <-q.done:
+	}
+}
+
+func (q *receiveQueue) run(onReceive func(frame []byte)) {
+	// Frames are handled one after the other so that they reach the TAP device in the order they were received
+	for {
+		select {
+		case frame := <-q.frames:
+			onReceive(frame)
+		case <-q.done:
+			return
+		}
+	}
+}
+
+func (q *receiveQueue) receivedBytes() uint64 {
+	// Peers don't have a queue before their data channel has opened
+	if q == nil {
+		return 0
+	}
+
+	return atomic.LoadUint64(&q.bytes)
+}
+
+func (q *receiveQueue) close() {
+	q.once.Do(func() {
+		close(q.done)
+	})
+}
+
+func wake(c chan struct{}) {
+	// The channels are buffered, so a pending signal is enough to wake up the receiver
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
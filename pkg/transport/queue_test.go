@@ -0,0 +1,104 @@
+package transport
+
+import (
+	"testing"
+	"time"
+)
+
+const testQueueLength = 3
+
+func popAll(t *testing.T, q *sendQueue) []string {
+	t.Helper()
+
+	frames := []string{}
+	for {
+		frame, ok := q.popUntil(time.Now())
+		if !ok {
+			return frames
+		}
+
+		frames = append(frames, string(frame))
+	}
+}
+
+func TestSendQueueDropsWhenFull(t *testing.T) {
+	for _, test := range []struct {
+		policy      DropPolicy
+		wantQueued  []string
+		wantDropped int
+	}{
+		{DropTail, []string{"0", "1", "2"}, 2}, // The frames which are queued while the queue is full are dropped
+		{DropHead, []string{"2", "3", "4"}, 2}, // The oldest frames are dropped to make room for the new ones
+	} {
+		t.Run(string(test.policy), func(t *testing.T) {
+			m := &WebRTCManager{pool: NewBufferPool(16)}
+			p := &peer{}
+			q := newSendQueue(testQueueLength, test.policy, m.pool)
+
+			for _, frame := range []string{"0", "1", "2", "3", "4"} {
+				m.push(p, q, []byte(frame))
+			}
+
+			if depth := q.depth(); depth != testQueueLength {
+				t.Fatalf("queue has a depth of %v, want %v", depth, testQueueLength)
+			}
+
+			if p.counters.dropped != uint64(test.wantDropped) {
+				t.Fatalf("peer counted %v dropped frames, want %v", p.counters.dropped, test.wantDropped)
+			}
+
+			if m.counters.droppedQueueFull != uint64(test.wantDropped) {
+				t.Fatalf("manager counted %v frames dropped because of a full queue, want %v", m.counters.droppedQueueFull, test.wantDropped)
+			}
+
+			got := popAll(t, q)
+			if len(got) != len(test.wantQueued) {
+				t.Fatalf("queue contained %v, want %v", got, test.wantQueued)
+			}
+
+			for i := range got {
+				if got[i] != test.wantQueued[i] {
+					t.Fatalf("queue contained %v, want %v", got, test.wantQueued)
+				}
+			}
+		})
+	}
+}
+
+func TestSendQueuePushReportsDrops(t *testing.T) {
+	for _, policy := range []DropPolicy{DropTail, DropHead} {
+		t.Run(string(policy), func(t *testing.T) {
+			q := newSendQueue(1, policy, NewBufferPool(16))
+
+			if !q.push([]byte("0")) {
+				t.Fatal("push into an empty queue reported a drop")
+			}
+
+			if q.push([]byte("1")) {
+				t.Fatal("push into a full queue didn't report a drop")
+			}
+		})
+	}
+}
+
+func TestSendQueueDropsAfterClose(t *testing.T) {
+	m := &WebRTCManager{pool: NewBufferPool(16)}
+	p := &peer{}
+	q := newSendQueue(testQueueLength, DropTail, m.pool)
+
+	m.push(p, q, []byte("0"))
+	q.close()
+	m.push(p, q, []byte("1"))
+
+	if depth := q.depth(); depth != 0 {
+		t.Fatalf("closed queue has a depth of %v, want 0", depth)
+	}
+
+	if p.counters.dropped != 1 {
+		t.Fatalf("peer counted %v dropped frames, want 1", p.counters.dropped)
+	}
+
+	if _, ok := q.pop(); ok {
+		t.Fatal("pop from a closed queue returned a frame")
+	}
+}
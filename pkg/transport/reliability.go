@@ -0,0 +1,31 @@
+package transport
+
+import (
+	"github.com/pion/webrtc/v3"
+	"github.com/pojntfx/weron/pkg/config"
+)
+
+type Reliability struct {
+	Unordered         bool
+	MaxRetransmits    *uint16
+	MaxPacketLifeTime *uint16 // In milliseconds
+}
+
+func (r Reliability) Validate() error {
+	// SCTP can only limit either the number of retransmits or the lifetime of a message, not both
+	if r.MaxRetransmits != nil && r.MaxPacketLifeTime != nil {
+		return config.ErrConflictingReliability
+	}
+
+	return nil
+}
+
+func (r Reliability) dataChannelInit() *webrtc.DataChannelInit {
+	ordered := !r.Unordered
+
+	return &webrtc.DataChannelInit{
+		Ordered:           &ordered,
+		MaxRetransmits:    r.MaxRetransmits,
+		MaxPacketLifeTime: r.MaxPacketLifeTime,
+	}
+}
@@ -0,0 +1,146 @@
+package transport
+
+import (
+	"encoding/json"
+	"net"
+	"sync"
+
+	"github.com/pojntfx/weron/pkg/config"
+)
+
+const (
+	advertisementType byte = 0 // IP packets start with the IP version, so this can't be confused with a packet
+
+	ipv4HeaderLength = 20
+	ipv6HeaderLength = 40
+)
+
+type advertisement struct {
+	Addresses []string `json:"addresses"`
+}
+
+func EncodeAdvertisement(addresses []net.IP) ([]byte, error) {
+	a := advertisement{
+		Addresses: []string{},
+	}
+	for _, address := range addresses {
+		a.Addresses = append(a.Addresses, address.String())
+	}
+
+	data, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte{advertisementType}, data...), nil
+}
+
+func IsAdvertisement(packet []byte) bool {
+	return len(packet) > 0 && packet[0] == advertisementType
+}
+
+func DecodeAdvertisement(packet []byte) ([]net.IP, error) {
+	if !IsAdvertisement(packet) {
+		return nil, config.ErrInvalidAdvertisement
+	}
+
+	var a advertisement
+	if err := json.Unmarshal(packet[1:], &a); err != nil {
+		return nil, config.ErrInvalidAdvertisement
+	}
+
+	addresses := []net.IP{}
+	for _, address := range a.Addresses {
+		ip := net.ParseIP(address)
+		if ip == nil {
+			return nil, config.ErrInvalidAdvertisement
+		}
+
+		addresses = append(addresses, ip)
+	}
+
+	return addresses, nil
+}
+
+type RoutingTable struct {
+	routes map[string]string
+
+	lock sync.Mutex
+}
+
+func NewRoutingTable() *RoutingTable {
+	return &RoutingTable{
+		routes: map[string]string{},
+	}
+}
+
+func (t *RoutingTable) Advertise(peer string, addresses []net.IP) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	// An advertisement replaces all addresses the peer has advertised before
+	for address, candidate := range t.routes {
+		if candidate == peer {
+			delete(t.routes, address)
+		}
+	}
+
+	for _, address := range addresses {
+		t.routes[address.String()] = peer
+	}
+}
+
+func (t *RoutingTable) Lookup(address net.IP) (string, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	peer, ok := t.routes[address.String()]
+
+	return peer, ok
+}
+
+func (t *RoutingTable) Forget(peer string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for address, candidate := range t.routes {
+		if candidate == peer {
+			delete(t.routes, address)
+		}
+	}
+}
+
+func (t *RoutingTable) Routes() map[string]string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	routes := map[string]string{}
+	for address, peer := range t.routes {
+		routes[address] = peer
+	}
+
+	return routes
+}
+
+func GetDestinationAddress(packet []byte) (net.IP, error) {
+	if len(packet) < 1 {
+		return nil, config.ErrInvalidPacket
+	}
+
+	switch packet[0] >> 4 {
+	case 4:
+		if len(packet) < ipv4HeaderLength {
+			return nil, config.ErrInvalidPacket
+		}
+
+		return net.IP(packet[16:20]), nil
+	case 6:
+		if len(packet) < ipv6HeaderLength {
+			return nil, config.ErrInvalidPacket
+		}
+
+		return net.IP(packet[24:40]), nil
+	}
+
+	return nil, config.ErrInvalidPacket
+}
@@ -0,0 +1,189 @@
+package transport
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/pojntfx/weron/pkg/config"
+)
+
+func newTestPacket(destination net.IP) []byte {
+	if ipv4 := destination.To4(); ipv4 != nil {
+		packet := make([]byte, ipv4HeaderLength)
+		packet[0] = 0x45
+		copy(packet[16:20], ipv4)
+
+		return packet
+	}
+
+	packet := make([]byte, ipv6HeaderLength)
+	packet[0] = 0x60
+	copy(packet[24:40], destination.To16())
+
+	return packet
+}
+
+func TestAdvertisementRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		addresses []net.IP
+	}{
+		{"none", []net.IP{}},
+		{"ipv4", []net.IP{net.ParseIP("10.0.0.1")}},
+		{"ipv6", []net.IP{net.ParseIP("fd00::1")}},
+		{"both", []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("fe80::1"), net.ParseIP("fd00::1")}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			packet, err := EncodeAdvertisement(test.addresses)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !IsAdvertisement(packet) {
+				t.Fatal("encoded advertisement isn't recognized as one")
+			}
+
+			// Advertisements share the data channel with packets, so they must never be routed as one
+			if _, err := GetDestinationAddress(packet); err == nil {
+				t.Fatal("encoded advertisement has a destination address")
+			}
+
+			addresses, err := DecodeAdvertisement(packet)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(addresses) != len(test.addresses) {
+				t.Fatalf("decoded addresses %v, want %v", addresses, test.addresses)
+			}
+
+			for i := range addresses {
+				if !addresses[i].Equal(test.addresses[i]) {
+					t.Fatalf("decoded addresses %v, want %v", addresses, test.addresses)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeAdvertisementRejectsMalformedAdvertisements(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		packet []byte
+	}{
+		{"empty", []byte{}},
+		{"packet", newTestPacket(net.ParseIP("10.0.0.1"))},
+		{"no body", []byte{advertisementType}},
+		{"invalid json", append([]byte{advertisementType}, `{"addresses":`...)},
+		{"invalid address", append([]byte{advertisementType}, `{"addresses":["10.0.0.300"]}`...)},
+		{"invalid type", append([]byte{advertisementType}, `{"addresses":"10.0.0.1"}`...)},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := DecodeAdvertisement(test.packet); !errors.Is(err, config.ErrInvalidAdvertisement) {
+				t.Fatalf("decoding returned %v, want %v", err, config.ErrInvalidAdvertisement)
+			}
+		})
+	}
+}
+
+func TestGetDestinationAddress(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		packet  []byte
+		want    net.IP
+		wantErr error
+	}{
+		{"ipv4", newTestPacket(net.ParseIP("10.0.0.2")), net.ParseIP("10.0.0.2"), nil},
+		{"ipv6", newTestPacket(net.ParseIP("fd00::2")), net.ParseIP("fd00::2"), nil},
+		{"empty", []byte{}, nil, config.ErrInvalidPacket},
+		{"truncated ipv4", newTestPacket(net.ParseIP("10.0.0.2"))[:ipv4HeaderLength-1], nil, config.ErrInvalidPacket},
+		{"truncated ipv6", newTestPacket(net.ParseIP("fd00::2"))[:ipv6HeaderLength-1], nil, config.ErrInvalidPacket},
+		{"unknown version", []byte{0x50, 0, 0, 0}, nil, config.ErrInvalidPacket},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			address, err := GetDestinationAddress(test.packet)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("getting the destination returned %v, want %v", err, test.wantErr)
+			}
+
+			if !address.Equal(test.want) {
+				t.Fatalf("got destination %v, want %v", address, test.want)
+			}
+		})
+	}
+}
+
+func TestRoutingTableLookup(t *testing.T) {
+	routes := NewRoutingTable()
+
+	routes.Advertise(testMACA, []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("fd00::1")})
+	routes.Advertise(testMACB, []net.IP{net.ParseIP("10.0.0.2"), net.ParseIP("fd00::2")})
+
+	for _, test := range []struct {
+		destination string
+		want        string
+	}{
+		{"10.0.0.1", testMACA},
+		{"fd00::1", testMACA},
+		{"10.0.0.2", testMACB},
+		{"fd00::2", testMACB},
+		{"10.0.0.3", ""},
+		{"fd00::3", ""},
+		{"255.255.255.255", ""},
+	} {
+		t.Run(test.destination, func(t *testing.T) {
+			// Destinations are parsed from packets, where IPv4 addresses are 4 bytes long instead of 16
+			address, err := GetDestinationAddress(newTestPacket(net.ParseIP(test.destination)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			peer, ok := routes.Lookup(address)
+			if ok != (test.want != "") || peer != test.want {
+				t.Fatalf("lookup returned %v, %v, want %v", peer, ok, test.want)
+			}
+		})
+	}
+}
+
+func TestRoutingTableAdvertiseReplacesAddresses(t *testing.T) {
+	routes := NewRoutingTable()
+
+	routes.Advertise(testMACA, []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("fd00::1")})
+	routes.Advertise(testMACA, []net.IP{net.ParseIP("10.0.0.10")})
+
+	if peer, ok := routes.Lookup(net.ParseIP("10.0.0.1")); ok {
+		t.Fatalf("address which is no longer advertised is still routed to %v", peer)
+	}
+
+	if peer, ok := routes.Lookup(net.ParseIP("10.0.0.10")); !ok || peer != testMACA {
+		t.Fatalf("lookup returned %v, %v, want %v, true", peer, ok, testMACA)
+	}
+
+	if got := len(routes.Routes()); got != 1 {
+		t.Fatalf("routing table has %v routes, want 1", got)
+	}
+}
+
+func TestRoutingTableForget(t *testing.T) {
+	routes := NewRoutingTable()
+
+	routes.Advertise(testMACA, []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("fd00::1")})
+	routes.Advertise(testMACB, []net.IP{net.ParseIP("10.0.0.2")})
+
+	// Peers are forgotten once their data channel has closed
+	routes.Forget(testMACA)
+
+	for _, address := range []string{"10.0.0.1", "fd00::1"} {
+		if peer, ok := routes.Lookup(net.ParseIP(address)); ok {
+			t.Fatalf("address %v is still routed to %v after its peer was forgotten", address, peer)
+		}
+	}
+
+	want := map[string]string{"10.0.0.2": testMACB}
+	got := routes.Routes()
+	if len(got) != len(want) || got["10.0.0.2"] != testMACB {
+		t.Fatalf("routing table has routes %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,151 @@
+package transport
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+type CandidateStats struct {
+	Type     string `json:"type"`
+	Protocol string `json:"protocol"`
+	Address  string `json:"address"`
+	Port     uint16 `json:"port"`
+}
+
+type PeerStats struct {
+	MAC                string          `json:"mac"`
+	ConnectionState    string          `json:"connectionState"`
+	ICEConnectionState string          `json:"iceConnectionState"`
+	LocalCandidate     *CandidateStats `json:"localCandidate"`  // nil if no candidate pair has been selected yet
+	RemoteCandidate    *CandidateStats `json:"remoteCandidate"` // nil if no candidate pair has been selected yet
+	RTT                time.Duration   `json:"rtt"`             // 0 if it hasn't been measured yet
+	BytesSent          uint64          `json:"bytesSent"`
+	BytesReceived      uint64          `json:"bytesReceived"`
+	FramesSent         uint64          `json:"framesSent"`
+	FramesReceived     uint64          `json:"framesReceived"`
+	QueueDepth         int             `json:"queueDepth"`
+	DroppedFrames      uint64          `json:"droppedFrames"`
+	DecryptionFailures uint64          `json:"decryptionFailures"`
+	Compression        Compression     `json:"compression"`
+	CompressionRatio   float64         `json:"compressionRatio"`
+}
+
+type Stats struct {
+	Peers                []PeerStats `json:"peers"`
+	DroppedNoPeer        uint64      `json:"droppedNoPeer"`
+	DroppedNoDataChannel uint64      `json:"droppedNoDataChannel"`
+	DroppedQueueFull     uint64      `json:"droppedQueueFull"`
+	DroppedSendError     uint64      `json:"droppedSendError"`
+	DroppedTooLarge      uint64      `json:"droppedTooLarge"`
+	DecryptionFailures   uint64      `json:"decryptionFailures"`
+}
+
+type managerCounters struct {
+	droppedNoPeer        uint64
+	droppedNoDataChannel uint64
+	droppedQueueFull     uint64
+	droppedSendError     uint64
+	droppedTooLarge      uint64
+	decryptionFailures   uint64
+}
+
+type counters struct {
+	bytesSent          uint64
+	framesSent         uint64
+	framesReceived     uint64
+	dropped            uint64
+	decryptionFailures uint64
+	uncompressedBytes  uint64
+	compressedBytes    uint64
+}
+
+func (c *counters) compressionRatio() float64 {
+	uncompressed := atomic.LoadUint64(&c.uncompressedBytes)
+	compressed := atomic.LoadUint64(&c.compressedBytes)
+
+	// Nothing has been compressed yet
+	if compressed == 0 {
+		return 1
+	}
+
+	return float64(uncompressed) / float64(compressed)
+}
+
+func (m *WebRTCManager) Stats() Stats {
+	type snapshot struct {
+		*peer
+
+		queues      []*sendQueue
+		inbox       *receiveQueue
+		compression Compression
+	}
+
+	m.lock.Lock()
+	peers := map[string]snapshot{}
+	for mac, p := range m.peers {
+		peers[mac] = snapshot{p, p.queues, p.inbox, p.compression}
+	}
+	m.lock.Unlock()
+
+	stats := Stats{
+		Peers:                []PeerStats{},
+		DroppedNoPeer:        atomic.LoadUint64(&m.counters.droppedNoPeer),
+		DroppedNoDataChannel: atomic.LoadUint64(&m.counters.droppedNoDataChannel),
+		DroppedQueueFull:     atomic.LoadUint64(&m.counters.droppedQueueFull),
+		DroppedSendError:     atomic.LoadUint64(&m.counters.droppedSendError),
+		DroppedTooLarge:      atomic.LoadUint64(&m.counters.droppedTooLarge),
+		DecryptionFailures:   atomic.LoadUint64(&m.counters.decryptionFailures),
+	}
+
+	// Collecting the stats from pion can take a while, so don't hold the lock while doing so
+	for mac, p := range peers {
+		s := PeerStats{
+			MAC:                mac,
+			ConnectionState:    p.connection.ConnectionState().String(),
+			ICEConnectionState: p.connection.ICEConnectionState().String(),
+			BytesSent:          atomic.LoadUint64(&p.counters.bytesSent),
+			BytesReceived:      p.inbox.receivedBytes(),
+			FramesSent:         atomic.LoadUint64(&p.counters.framesSent),
+			FramesReceived:     atomic.LoadUint64(&p.counters.framesReceived),
+			DroppedFrames:      atomic.LoadUint64(&p.counters.dropped),
+			DecryptionFailures: atomic.LoadUint64(&p.counters.decryptionFailures),
+			Compression:        p.compression,
+			CompressionRatio:   p.counters.compressionRatio(),
+		}
+
+		// Peers don't have queues before their data channel has opened
+		for _, queue := range p.queues {
+			s.QueueDepth += queue.depth()
+		}
+
+		if pair, err := p.connection.SCTP().Transport().ICETransport().GetSelectedCandidatePair(); err == nil && pair != nil {
+			s.LocalCandidate = getCandidateStats(pair.Local)
+			s.RemoteCandidate = getCandidateStats(pair.Remote)
+		}
+
+		for _, report := range p.connection.GetStats() {
+			if pair, ok := report.(webrtc.ICECandidatePairStats); ok && pair.Nominated && pair.State == webrtc.StatsICECandidatePairStateSucceeded {
+				s.RTT = time.Duration(pair.CurrentRoundTripTime * float64(time.Second))
+			}
+		}
+
+		stats.Peers = append(stats.Peers, s)
+	}
+
+	return stats
+}
+
+func getCandidateStats(c *webrtc.ICECandidate) *CandidateStats {
+	if c == nil {
+		return nil
+	}
+
+	return &CandidateStats{
+		Type:     c.Typ.String(),
+		Protocol: c.Protocol.String(),
+		Address:  c.Address,
+		Port:     c.Port,
+	}
+}
@@ -0,0 +1,24 @@
+package transport
+
+import (
+	"github.com/pion/webrtc/v3"
+)
+
+type Transport interface {
+	HandleIntroduction(mac string) error
+	HandleOffer(mac string, offer webrtc.SessionDescription) error
+	HandleAnswer(mac string, answer webrtc.SessionDescription) error
+	HandleCandidate(mac string, candidate webrtc.ICECandidateInit) error
+	HandleResignation(mac string) error
+
+	IsEstablished(mac string) bool
+	Learn(mac string, source string)
+
+	Write(mac string, frame []byte) error
+	Close() []error
+}
+
+var (
+	_ Transport = (*WebRTCManager)(nil)
+	_ Transport = (*MemoryManager)(nil)
+)
@@ -4,29 +4,53 @@ import (
 	"errors"
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/pion/ice/v2"
 	"github.com/pion/webrtc/v3"
 	"github.com/pojntfx/weron/pkg/config"
+	"github.com/pojntfx/weron/pkg/encryption"
 )
 
 const (
-	dataChannelName = "data"
+	dataChannelName    = "data"
+	negotiationTimeout = time.Second * 30
+	restartInterval    = time.Second * 5
 )
 
 var (
-	broadcastMAC = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}.String()
-
 	ErrorConnectionHasNoDataChannel = errors.New("connection has no data channel")
 )
 
 type WebRTCManager struct {
-	peers map[string]*peer
+	counters managerCounters // Accessed atomically, so it must stay 64-bit aligned on 32-bit platforms
 
-	ice  []webrtc.ICEServer
-	lock sync.Mutex
+	mac   string
+	peers map[string]*peer
+	fdb   *ForwardingTable
+
+	ice            []webrtc.ICEServer
+	api            *webrtc.API
+	key            []byte
+	compressions   []Compression
+	compressor     *compressor
+	reliability    Reliability
+	queueing       Queueing
+	batching       Batching
+	restartTimeout time.Duration
+	pool           *BufferPool
+	getFlowHash    func(frame []byte) uint32
+	offloadSize    int // Frames larger than this are TCP segments which the device hasn't segmented yet; 0 disables offload
+	lock           sync.Mutex
+
+	pending     []func() // Callbacks are run in the order they were queued, but without holding the lock
+	flushing    bool
+	pendingLock sync.Mutex
 
 	onCandidate        func(mac string, i webrtc.ICECandidate)
 	onReceive          func(mac string, frame []byte)
+	onReceiveError     func(mac string, err error)
 	onOffer            func(mac string, o webrtc.SessionDescription)
 	onAnswer           func(mac string, o webrtc.SessionDescription)
 	onDataChannelOpen  func(mac string)
@@ -34,22 +58,55 @@ type WebRTCManager struct {
 }
 
 func NewWebRTCManager(
+	mac string,
 	ice []webrtc.ICEServer,
+	udpMux ice.UDPMux,
+	key []byte,
+	compressions []Compression,
+	macTTL time.Duration,
+	reliability Reliability,
+	queueing Queueing,
+	batching Batching,
+	restartTimeout time.Duration,
+	pool *BufferPool,
+	getFlowHash func(frame []byte) uint32,
+	offloadSize int,
 
 	onCandidate func(mac string, i webrtc.ICECandidate),
 	onReceive func(mac string, frame []byte),
+	onReceiveError func(mac string, err error),
 	onOffer func(mac string, o webrtc.SessionDescription),
 	onAnswer func(mac string, o webrtc.SessionDescription),
 	onDataChannelOpen func(mac string),
 	onDataChannelClose func(mac string),
 ) *WebRTCManager {
+	// Host candidates of all peers are gathered on the mux's port if one is given, which allows multiple managers to share one port
+	settingEngine := webrtc.SettingEngine{}
+	if udpMux != nil {
+		settingEngine.SetICEUDPMux(udpMux)
+	}
+
 	return &WebRTCManager{
+		mac:   mac,
 		peers: map[string]*peer{},
-
-		ice: ice,
+		fdb:   NewForwardingTable(macTTL),
+
+		ice:            ice,
+		api:            webrtc.NewAPI(webrtc.WithSettingEngine(settingEngine)),
+		key:            key,
+		compressions:   compressions,
+		compressor:     newCompressor(),
+		reliability:    reliability,
+		queueing:       queueing,
+		batching:       batching,
+		restartTimeout: restartTimeout,
+		pool:           pool,
+		getFlowHash:    getFlowHash,
+		offloadSize:    offloadSize,
 
 		onCandidate:        onCandidate,
 		onReceive:          onReceive,
+		onReceiveError:     onReceiveError,
 		onOffer:            onOffer,
 		onAnswer:           onAnswer,
 		onDataChannelOpen:  onDataChannelOpen,
@@ -58,78 +115,94 @@ func NewWebRTCManager(
 }
 
 type peer struct {
-	connection *webrtc.PeerConnection
-	channel    *webrtc.DataChannel
-	candidates []webrtc.ICECandidateInit
+	counters counters // Accessed atomically, so it must stay 64-bit aligned on 32-bit platforms
+
+	connection  *webrtc.PeerConnection
+	channel     *webrtc.DataChannel
+	queues      []*sendQueue // Frames of one flow are always sent through the same queue so that they stay in order
+	inbox       *receiveQueue
+	candidates  []webrtc.ICECandidateInit
+	createdAt   time.Time
+	ignoreOffer bool
+	recovering  bool
+
+	compression Compression
+	offload     bool
 }
 
 func (m *WebRTCManager) HandleIntroduction(mac string) error {
 	m.lock.Lock()
-	defer m.lock.Unlock()
+	defer m.unlock()
 
-	c, err := m.createPeer(mac)
-	if err != nil {
-		return err
+	// Peers are re-introduced after they have reconnected to the signaler; keep the connection if it still works
+	// or if we are still negotiating with the peer, i.e. because it has sent us an offer in the meantime
+	if p, ok := m.peers[mac]; ok && !m.isStale(p) {
+		return nil
 	}
 
-	if err := m.createDataChannel(mac, c); err != nil {
-		return err
-	}
-
-	offer, err := c.CreateOffer(nil)
+	c, err := m.createPeer(mac)
 	if err != nil {
-		if err := m.HandleResignation(mac); err != nil {
-			return err
-		}
-
 		return err
 	}
 
-	if err := c.SetLocalDescription(offer); err != nil {
+	if err := m.createDataChannel(mac, c); err != nil {
 		return err
 	}
 
-	m.onOffer(mac, offer)
-
-	return nil
+	return m.offer(mac, c, nil)
 }
 
 func (m *WebRTCManager) HandleOffer(mac string, offer webrtc.SessionDescription) error {
 	m.lock.Lock()
-	defer m.lock.Unlock()
+	defer m.unlock()
 
-	c, err := m.createPeer(mac)
-	if err != nil {
-		return err
-	}
+	if p, ok := m.peers[mac]; ok && !m.isStale(p) {
+		// Both peers have sent an offer at the same time
+		if p.connection.SignalingState() != webrtc.SignalingStateStable {
+			// The impolite peer ignores the offer and waits for the polite peer to answer its own offer instead
+			if !m.isPolite(mac) {
+				p.ignoreOffer = true
 
-	if err := m.subscribeToDataChannels(mac, c); err != nil {
-		return err
-	}
+				return nil
+			}
 
-	if err := c.SetRemoteDescription(offer); err != nil {
-		return err
-	}
+			// pion can't roll back the local offer, so the polite peer replaces its connection instead. The polite peer
+			// never renegotiates established connections, so the connection can't have been used yet.
+			return m.answer(mac, offer)
+		}
 
-	// No need to loop over queued candidates here, as the peer
-	// has just been created above so there can't be any
+		// The peer is renegotiating an established connection, i.e. to restart ICE
+		p.ignoreOffer = false
 
-	answer, err := c.CreateAnswer(nil)
-	if err != nil {
-		if err := m.HandleResignation(mac); err != nil {
+		if err := p.connection.SetRemoteDescription(offer); err != nil {
+			// The offer doesn't belong to the existing connection, i.e. because the peer has restarted
+			return m.answer(mac, offer)
+		}
+
+		if err := m.addQueuedCandidates(p); err != nil {
 			return err
 		}
 
-		return err
-	}
+		answer, err := p.connection.CreateAnswer(nil)
+		if err != nil {
+			return err
+		}
 
-	if err := c.SetLocalDescription(answer); err != nil {
-		return err
-	}
+		if err := p.connection.SetLocalDescription(answer); err != nil {
+			return err
+		}
 
-	m.onAnswer(mac, answer)
+		// Frames might still be in flight, so the compression and offload can't change while the connection is in use
+		answer = withOffload(withCompressions(answer, compressionsOf(p.compression)), p.offload)
 
-	return nil
+		m.later(func() {
+			m.onAnswer(mac, answer)
+		})
+
+		return nil
+	}
+
+	return m.answer(mac, offer)
 }
 
 func (m *WebRTCManager) HandleCandidate(mac string, candidate webrtc.ICECandidateInit) error {
@@ -141,9 +214,19 @@ func (m *WebRTCManager) HandleCandidate(mac string, candidate webrtc.ICECandidat
 		return err
 	}
 
-	// If remote description has been set, continue
-	if c.connection.RemoteDescription() != nil {
-		return c.connection.AddICECandidate(candidate)
+	// Candidates which arrive while we ignore a colliding offer were gathered for the peer's connection which it replaces
+	// when it answers our offer, so drop them instead of queueing them for ours
+	if c.ignoreOffer {
+		return nil
+	}
+
+	// If remote description has been set and we aren't waiting for an answer, continue
+	if c.connection.RemoteDescription() != nil && c.connection.SignalingState() != webrtc.SignalingStateHaveLocalOffer {
+		if err := c.connection.AddICECandidate(candidate); err != nil {
+			return err
+		}
+
+		return nil
 	}
 
 	// If remote description has not been set, queue it
@@ -161,71 +244,119 @@ func (m *WebRTCManager) HandleAnswer(mac string, answer webrtc.SessionDescriptio
 		return err
 	}
 
+	// Ignore answers to offers which have been superseded, i.e. by a replaced connection
+	if c.connection.SignalingState() != webrtc.SignalingStateHaveLocalOffer {
+		return nil
+	}
+
 	if err := c.connection.SetRemoteDescription(answer); err != nil {
 		return err
 	}
 
-	// Add queued candidates if there are any
-	if len(c.candidates) > 0 {
-		for _, candidate := range c.candidates {
-			if err := c.connection.AddICECandidate(candidate); err != nil {
-				return err
-			}
-		}
+	c.ignoreOffer = false
 
-		// Clear now-added candidates
-		c.candidates = []webrtc.ICECandidateInit{}
+	// The answer contains the compression the answering peer has chosen from our offer and whether it supports offload
+	if c.channel == nil {
+		c.compression = chooseCompression(m.compressions, getCompressions(answer))
+		c.offload = m.offloadSize > 0 && hasOffload(answer)
 	}
 
-	return nil
+	return m.addQueuedCandidates(c)
 }
 
-func (m *WebRTCManager) HandleResignation(mac string) error {
+func (m *WebRTCManager) Renegotiate(mac string, iceRestart bool) error {
 	m.lock.Lock()
-	defer m.lock.Unlock()
+	defer m.unlock()
 
 	c, err := m.getConnection(mac)
 	if err != nil {
 		return err
 	}
 
-	delete(m.peers, mac)
+	// pion can't roll back local offers, so a polite peer could never apply an offer which collides with its own;
+	// to prevent this, established connections are only ever renegotiated by the impolite peer
+	if m.isPolite(mac) {
+		return nil
+	}
 
-	return c.connection.Close()
+	// Negotiation is already in progress
+	if c.connection.SignalingState() != webrtc.SignalingStateStable {
+		return nil
+	}
+
+	return m.offer(mac, c.connection, &webrtc.OfferOptions{ICERestart: iceRestart})
+}
+
+func (m *WebRTCManager) HandleResignation(mac string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.removePeer(mac)
+}
+
+func (m *WebRTCManager) CompressionRatio(mac string) (Compression, float64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	p, err := m.getConnection(mac)
+	if err != nil {
+		return CompressionNone, 0, err
+	}
+
+	return p.compression, p.counters.compressionRatio(), nil
+}
+
+func (m *WebRTCManager) IsEstablished(mac string) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	p, ok := m.peers[mac]
+
+	return ok && p.channel != nil
+}
+
+func (m *WebRTCManager) Learn(mac string, source string) {
+	// Group addresses can't be the source of a frame, so don't learn them
+	if hw, err := net.ParseMAC(source); err != nil || isGroupMAC(hw) {
+		return
+	}
+
+	m.fdb.Learn(source, mac)
 }
 
 func (m *WebRTCManager) Write(mac string, frame []byte) error {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	peers := []*peer{}
-	if mac == broadcastMAC {
-		for candidate, p := range m.peers {
-			if candidate != mac {
-				peers = append(peers, p)
+	// Frames for a peer's own MAC address can be sent to it directly
+	if p, ok := m.peers[mac]; ok {
+		return m.send(p, frame)
+	}
+
+	// Frames for nodes bridged behind a peer can be sent to the peer they were learned from
+	if hw, err := net.ParseMAC(mac); err == nil && !isGroupMAC(hw) {
+		if learned, ok := m.fdb.Lookup(mac); ok {
+			if p, ok := m.peers[learned]; ok {
+				return m.send(p, frame)
 			}
 		}
-	} else {
-		p, err := m.getConnection(mac)
-		if err != nil {
-			return err
-		}
-
-		peers = append(peers, p)
 	}
 
-	for _, p := range peers {
+	// Flood broadcast, multicast and unknown unicast frames to all peers
+	flooded := false
+	for _, p := range m.peers {
 		if p.channel == nil {
-			return ErrorConnectionHasNoDataChannel
+			continue
 		}
 
-		if err := p.channel.Send(frame); err != nil {
-			if err := m.HandleResignation(mac); err != nil {
-				return err
-			}
+		// Can't fail as the peer has a data channel
+		_ = m.send(p, frame)
 
-			return nil
-		}
+		flooded = true
+	}
+
+	if !flooded {
+		atomic.AddUint64(&m.counters.droppedNoPeer, 1)
 	}
 
 	return nil
@@ -238,7 +369,7 @@ func (m *WebRTCManager) Close() []error {
 	errors := []error{}
 
 	for mac := range m.peers {
-		if err := m.HandleResignation(mac); err != nil {
+		if err := m.removePeer(mac); err != nil {
 			errors = append(errors, err)
 		}
 	}
@@ -246,8 +377,302 @@ func (m *WebRTCManager) Close() []error {
 	return errors
 }
 
+func (m *WebRTCManager) send(p *peer, frame []byte) error {
+	if p.channel == nil {
+		atomic.AddUint64(&m.counters.droppedNoDataChannel, 1)
+
+		return ErrorConnectionHasNoDataChannel
+	}
+
+	queue := p.queues[0]
+	if len(p.queues) > 1 {
+		queue = p.queues[m.getFlowHash(frame)%uint32(len(p.queues))]
+	}
+
+	// TCP segments which are larger than the MTU are only sent as-is to peers which support offload, and only if they fit into a message
+	if m.offloadSize > 0 && len(frame) > m.offloadSize {
+		maxFrameSize := m.offloadSize
+		if p.offload {
+			maxFrameSize = maxOffloadFrameSize
+		}
+
+		if len(frame) > maxFrameSize {
+			if !segmentFrame(frame, maxFrameSize, func(segment []byte) {
+				m.push(p, queue, segment)
+			}) {
+				atomic.AddUint64(&p.counters.dropped, 1)
+			}
+
+			return nil
+		}
+	}
+
+	m.push(p, queue, frame)
+
+	return nil
+}
+
+func (m *WebRTCManager) push(p *peer, queue *sendQueue, frame []byte) {
+	// Frames are sent by the peer's own goroutines so that a slow peer can't stall the others; the frame is
+	// copied as it is sent asynchronously, so the caller must be able to reuse its buffer
+	if !queue.push(m.pool.Copy(frame)) {
+		atomic.AddUint64(&p.counters.dropped, 1)
+		atomic.AddUint64(&m.counters.droppedQueueFull, 1)
+	}
+}
+
+func (m *WebRTCManager) drain(mac string, c *webrtc.PeerConnection, dc *webrtc.DataChannel, p *peer, queue *sendQueue) {
+	// The answering peer batches frames if the offering peer does, even if it has disabled batching itself
+	batched := dc.Protocol() == batchProtocol
+
+	// Batches are compressed and encrypted as a whole, so they have to fit into a message once they are encoded
+	maxSize := m.batching.MaxSize
+	if maxSize <= 0 || maxSize > maxBatchPayload {
+		maxSize = maxBatchPayload
+	}
+
+	batch := []byte{}
+	var carry []byte
+	for {
+		frame := carry
+		carry = nil
+
+		if frame == nil {
+			var ok bool
+			if frame, ok = queue.pop(); !ok {
+				return
+			}
+		}
+
+		payload := frame
+		frames := uint64(1)
+
+		if batched {
+			// Frames which don't fit into a batch can't be sent at all
+			if lengthPrefix+len(frame) > maxBatchPayload {
+				m.pool.Put(frame)
+				m.dropTooLarge(p, 1)
+
+				continue
+			}
+
+			batch = appendFrame(batch[:0], frame)
+			m.pool.Put(frame)
+
+			var added uint64
+			batch, added, carry = fillBatch(batch, queue, m.pool, maxSize, time.Now().Add(m.batching.MaxDelay))
+			frames += added
+
+			payload = batch
+		}
+
+		message, err := m.encode(p, payload)
+		if !batched {
+			m.pool.Put(frame)
+		}
+		if err != nil {
+			// Frames which can't be encrypted are dropped
+			continue
+		}
+
+		if len(message) > MaxBatchSize {
+			m.dropTooLarge(p, frames)
+
+			continue
+		}
+
+		// Wait for the data channel's buffer to drain; frames queued in the meantime are subject to the drop policy
+		for dc.BufferedAmount() > m.queueing.HighWatermark {
+			if !queue.waitForLow() {
+				return
+			}
+		}
+
+		if err := dc.Send(message); err != nil {
+			atomic.AddUint64(&m.counters.droppedSendError, frames)
+
+			m.removeConnection(mac, c)
+
+			return
+		}
+
+		atomic.AddUint64(&p.counters.bytesSent, uint64(len(message)))
+		atomic.AddUint64(&p.counters.framesSent, frames)
+	}
+}
+
+func (m *WebRTCManager) dropTooLarge(p *peer, frames uint64) {
+	atomic.AddUint64(&p.counters.dropped, frames)
+	atomic.AddUint64(&m.counters.droppedTooLarge, frames)
+}
+
+func (m *WebRTCManager) encode(p *peer, payload []byte) ([]byte, error) {
+	// The payload is copied by both compression and encryption, so the caller can reuse it right away
+	if p.compression != CompressionNone {
+		compressed := m.compressor.compress(p.compression, payload)
+
+		atomic.AddUint64(&p.counters.uncompressedBytes, uint64(len(payload)))
+		atomic.AddUint64(&p.counters.compressedBytes, uint64(len(compressed)))
+
+		payload = compressed
+	}
+
+	return encryption.Encrypt(payload, m.key)
+}
+
+func (m *WebRTCManager) decode(p *peer, message []byte) ([]byte, error) {
+	frame, err := encryption.Decrypt(message, m.key)
+	if err != nil {
+		atomic.AddUint64(&p.counters.decryptionFailures, 1)
+		atomic.AddUint64(&m.counters.decryptionFailures, 1)
+
+		return nil, err
+	}
+
+	if p.compression == CompressionNone {
+		return frame, nil
+	}
+
+	return m.compressor.decompress(p.compression, frame)
+}
+
+func (m *WebRTCManager) offer(mac string, c *webrtc.PeerConnection, options *webrtc.OfferOptions) error {
+	offer, err := c.CreateOffer(options)
+	if err != nil {
+		if err := m.removePeer(mac); err != nil {
+			return err
+		}
+
+		return err
+	}
+
+	if err := c.SetLocalDescription(offer); err != nil {
+		return err
+	}
+
+	offer = withOffload(withCompressions(offer, m.compressions), m.offloadSize > 0)
+
+	m.later(func() {
+		m.onOffer(mac, offer)
+	})
+
+	return nil
+}
+
+func (m *WebRTCManager) answer(mac string, offer webrtc.SessionDescription) error {
+	c, err := m.createPeer(mac)
+	if err != nil {
+		return err
+	}
+
+	if err := m.subscribeToDataChannels(mac, c); err != nil {
+		return err
+	}
+
+	if err := c.SetRemoteDescription(offer); err != nil {
+		return err
+	}
+
+	// No need to loop over queued candidates here, as the peer
+	// has just been created above so there can't be any
+
+	answer, err := c.CreateAnswer(nil)
+	if err != nil {
+		if err := m.removePeer(mac); err != nil {
+			return err
+		}
+
+		return err
+	}
+
+	if err := c.SetLocalDescription(answer); err != nil {
+		return err
+	}
+
+	compression := chooseCompression(m.compressions, getCompressions(offer))
+
+	offload := m.offloadSize > 0 && hasOffload(offer)
+
+	m.peers[mac].compression = compression
+	m.peers[mac].offload = offload
+
+	answer = withOffload(withCompressions(answer, compressionsOf(compression)), offload)
+
+	m.later(func() {
+		m.onAnswer(mac, answer)
+	})
+
+	return nil
+}
+
+func (m *WebRTCManager) addQueuedCandidates(p *peer) error {
+	// Add queued candidates if there are any
+	if len(p.candidates) > 0 {
+		for _, candidate := range p.candidates {
+			if err := p.connection.AddICECandidate(candidate); err != nil {
+				return err
+			}
+		}
+
+		// Clear now-added candidates
+		p.candidates = []webrtc.ICECandidateInit{}
+	}
+
+	return nil
+}
+
+func (m *WebRTCManager) isPolite(mac string) bool {
+	// Both peers need to agree on their roles without talking to each other, so derive them from the MAC addresses
+	return m.mac < mac
+}
+
+func (m *WebRTCManager) isStale(p *peer) bool {
+	// Established connections are recovered with ICE restarts, and are only given up on once the restart timeout is exceeded
+	if p.channel != nil {
+		return false
+	}
+
+	switch p.connection.ConnectionState() {
+	case webrtc.PeerConnectionStateConnected:
+		return false
+	case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
+		return true
+	}
+
+	// Negotiation has been going on for too long, i.e. because the offer or answer was lost while the signaler was down
+	return time.Since(p.createdAt) > negotiationTimeout
+}
+
+func (m *WebRTCManager) removePeer(mac string) error {
+	c, err := m.getConnection(mac)
+	if err != nil {
+		return err
+	}
+
+	delete(m.peers, mac)
+
+	for _, queue := range c.queues {
+		queue.close()
+	}
+
+	if c.inbox != nil {
+		c.inbox.close()
+	}
+
+	m.fdb.Forget(mac)
+
+	return c.connection.Close()
+}
+
 func (m *WebRTCManager) createPeer(mac string) (*webrtc.PeerConnection, error) {
-	c, err := webrtc.NewPeerConnection(webrtc.Configuration{
+	// Replace stale connections instead of leaking them
+	if _, ok := m.peers[mac]; ok {
+		if err := m.removePeer(mac); err != nil {
+			return nil, err
+		}
+	}
+
+	c, err := m.api.NewPeerConnection(webrtc.Configuration{
 		ICEServers: m.ice,
 	})
 	if err != nil {
@@ -257,11 +682,34 @@ func (m *WebRTCManager) createPeer(mac string) (*webrtc.PeerConnection, error) {
 	m.peers[mac] = &peer{
 		connection: c,
 		candidates: []webrtc.ICECandidateInit{},
+		createdAt:  time.Now(),
 	}
 
 	c.OnICECandidate(func(i *webrtc.ICECandidate) {
-		if i != nil {
-			m.onCandidate(mac, *i)
+		if i == nil {
+			return
+		}
+
+		// pion's ICE agent waits for this to return, so it can't take the lock, which is held while calling into pion
+		candidate := *i
+		m.later(func() {
+			m.onCandidate(mac, candidate)
+		})
+
+		m.flush()
+	})
+
+	c.OnConnectionStateChange(func(s webrtc.PeerConnectionState) {
+		// Connections which have never been established can't be recovered
+		if s == webrtc.PeerConnectionStateFailed && !m.isEstablished(mac, c) {
+			m.removeConnection(mac, c)
+		}
+	})
+
+	c.OnICEConnectionStateChange(func(s webrtc.ICEConnectionState) {
+		// Established connections can be recovered, i.e. if the peer has moved to a different network
+		if (s == webrtc.ICEConnectionStateDisconnected || s == webrtc.ICEConnectionStateFailed) && m.isEstablished(mac, c) {
+			m.recover(mac, c)
 		}
 	})
 
@@ -269,66 +717,268 @@ func (m *WebRTCManager) createPeer(mac string) (*webrtc.PeerConnection, error) {
 }
 
 func (m *WebRTCManager) createDataChannel(mac string, c *webrtc.PeerConnection) error {
-	dc, err := c.CreateDataChannel(dataChannelName, nil)
+	// The reliability is announced in-band when the channel is opened,
+	// so the answering peer uses the same mode as the offering peer
+	options := m.reliability.dataChannelInit()
+
+	// Whether frames are batched is announced in-band too
+	if m.batching.MaxSize > 0 {
+		protocol := batchProtocol
+
+		options.Protocol = &protocol
+	}
+
+	dc, err := c.CreateDataChannel(dataChannelName, options)
 	if err != nil {
-		if err := m.HandleResignation(mac); err != nil {
+		if err := m.removePeer(mac); err != nil {
 			return err
 		}
 
 		return err
 	}
 
+	m.handleDataChannel(mac, c, dc)
+
+	return nil
+}
+
+func (m *WebRTCManager) subscribeToDataChannels(mac string, c *webrtc.PeerConnection) error {
+	c.OnDataChannel(func(dc *webrtc.DataChannel) {
+		m.handleDataChannel(mac, c, dc)
+	})
+
+	return nil
+}
+
+func (m *WebRTCManager) handleDataChannel(mac string, c *webrtc.PeerConnection, dc *webrtc.DataChannel) {
+	inbox := newReceiveQueue(m.queueing.ReceiveLength)
+
 	dc.OnOpen(func() {
 		m.lock.Lock()
-		defer m.lock.Unlock()
+		defer m.unlock()
+
+		// The connection might have been replaced in the meantime
+		peer, ok := m.peers[mac]
+		if !ok || peer.connection != c {
+			inbox.close()
+
+			return
+		}
 
-		peer := m.peers[mac]
 		peer.channel = dc
+		peer.inbox = inbox
+
+		batched := dc.Protocol() == batchProtocol
+
+		go inbox.run(func(message []byte) {
+			payload, err := m.decode(peer, message)
+			if err != nil {
+				m.onReceiveError(mac, err)
+
+				return
+			}
 
-		m.peers[mac] = peer
+			if !batched {
+				atomic.AddUint64(&peer.counters.framesReceived, 1)
+
+				m.onReceive(mac, payload)
+
+				return
+			}
+
+			// Ignore as the frames in front of a malformed part have been received already
+			_ = unpackFrames(payload, func(frame []byte) {
+				atomic.AddUint64(&peer.counters.framesReceived, 1)
+
+				m.onReceive(mac, frame)
+			})
+		})
+
+		// Each queue is drained by its own goroutine, which compresses and encrypts its frames in parallel to the others
+		queues := []*sendQueue{}
+		for i := 0; i < m.queueing.getQueues(); i++ {
+			queues = append(queues, newSendQueue(m.queueing.Length, m.queueing.Policy, m.pool))
+		}
+		peer.queues = queues
+
+		dc.SetBufferedAmountLowThreshold(m.queueing.LowWatermark)
+
+		dc.OnBufferedAmountLow(func() {
+			for _, queue := range queues {
+				wake(queue.low)
+			}
+		})
 
-		m.onDataChannelOpen(mac)
+		for _, queue := range queues {
+			go m.drain(mac, c, dc, peer, queue)
+		}
+
+		// Hooks may query the manager, i.e. for its stats
+		m.later(func() {
+			m.onDataChannelOpen(mac)
+		})
 	})
 
 	dc.OnClose(func() {
-		m.onDataChannelClose(mac)
+		inbox.close()
+
+		// The peer must not be reported as closed before it has been reported as opened
+		m.later(func() {
+			m.onDataChannelClose(mac)
+		})
+		m.flush()
 
-		_ = m.HandleResignation(mac)
+		m.removeConnection(mac, c)
 	})
 
 	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
-		go m.onReceive(mac, msg.Data)
+		// The handler can run before the peer knows about the queue, so the queue counts the received bytes
+		atomic.AddUint64(&inbox.bytes, uint64(len(msg.Data)))
+
+		// Batches are decoded as a whole before they are unpacked
+		inbox.push(msg.Data)
 	})
+}
 
-	return nil
+func (m *WebRTCManager) isEstablished(mac string, c *webrtc.PeerConnection) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	p, ok := m.peers[mac]
+
+	return ok && p.connection == c && p.channel != nil
 }
 
-func (m *WebRTCManager) subscribeToDataChannels(mac string, c *webrtc.PeerConnection) error {
-	c.OnDataChannel(func(dc *webrtc.DataChannel) {
-		dc.OnOpen(func() {
-			m.lock.Lock()
-			defer m.lock.Unlock()
+func (m *WebRTCManager) recover(mac string, c *webrtc.PeerConnection) {
+	m.lock.Lock()
+	p, ok := m.peers[mac]
+	if !ok || p.connection != c || p.recovering {
+		m.lock.Unlock()
 
-			peer := m.peers[mac]
-			peer.channel = dc
+		return
+	}
 
-			m.peers[mac] = peer
+	p.recovering = true
+	m.lock.Unlock()
 
-			m.onDataChannelOpen(mac)
-		})
+	deadline := time.Now().Add(m.restartTimeout)
 
-		dc.OnClose(func() {
-			m.onDataChannelClose(mac)
+	ticker := time.NewTicker(restartInterval)
+	defer ticker.Stop()
 
-			_ = m.HandleResignation(mac)
-		})
+	for !m.restartICE(mac, c, deadline) {
+		<-ticker.C
+	}
+}
 
-		dc.OnMessage(func(msg webrtc.DataChannelMessage) {
-			go m.onReceive(mac, msg.Data)
-		})
-	})
+func (m *WebRTCManager) restartICE(mac string, c *webrtc.PeerConnection, deadline time.Time) bool {
+	m.lock.Lock()
+	defer m.unlock()
 
-	return nil
+	// The connection has been replaced or removed in the meantime
+	p, ok := m.peers[mac]
+	if !ok || p.connection != c {
+		return true
+	}
+
+	switch c.ICEConnectionState() {
+	case webrtc.ICEConnectionStateConnected, webrtc.ICEConnectionStateCompleted:
+		p.recovering = false
+
+		return true
+	}
+
+	if time.Now().After(deadline) {
+		// Ignore as this can be a no-op
+		_ = m.removePeer(mac)
+
+		return true
+	}
+
+	// The impolite peer drives renegotiation, so the polite peer waits for its offer
+	if m.isPolite(mac) {
+		return false
+	}
+
+	switch c.SignalingState() {
+	case webrtc.SignalingStateStable:
+		// The previous restart is still checking candidate pairs; restarting again would discard them
+		if c.ICEConnectionState() == webrtc.ICEConnectionStateChecking {
+			return false
+		}
+
+		if err := m.offer(mac, c, &webrtc.OfferOptions{ICERestart: true}); err != nil {
+			return true
+		}
+	case webrtc.SignalingStateHaveLocalOffer:
+		// pion can't create a new offer before the pending one has been answered, so send the pending one again
+		// in case it was lost, i.e. because we were disconnected from the signaler at the time
+		if offer := c.LocalDescription(); offer != nil {
+			pending := withOffload(withCompressions(*offer, m.compressions), m.offloadSize > 0)
+
+			m.later(func() {
+				m.onOffer(mac, pending)
+			})
+		}
+	}
+
+	return false
+}
+
+func (m *WebRTCManager) removeConnection(mac string, c *webrtc.PeerConnection) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	// Only remove the peer if the connection hasn't been replaced in the meantime
+	if peer, ok := m.peers[mac]; ok && peer.connection == c {
+		_ = m.removePeer(mac)
+
+		return
+	}
+
+	_ = c.Close()
+}
+
+func (m *WebRTCManager) later(f func()) {
+	m.pendingLock.Lock()
+	defer m.pendingLock.Unlock()
+
+	m.pending = append(m.pending, f)
+}
+
+func (m *WebRTCManager) unlock() {
+	m.lock.Unlock()
+
+	m.flush()
+}
+
+func (m *WebRTCManager) flush() {
+	m.pendingLock.Lock()
+
+	// Another call is running the queued callbacks already and will run ours too, in order
+	if m.flushing {
+		m.pendingLock.Unlock()
+
+		return
+	}
+
+	// Callbacks are run without holding any lock so that they can call back into the manager and block without stalling it
+	m.flushing = true
+	for len(m.pending) > 0 {
+		pending := m.pending
+		m.pending = nil
+
+		m.pendingLock.Unlock()
+
+		for _, f := range pending {
+			f()
+		}
+
+		m.pendingLock.Lock()
+	}
+	m.flushing = false
+
+	m.pendingLock.Unlock()
 }
 
 func (m *WebRTCManager) getConnection(mac string) (*peer, error) {
@@ -339,3 +989,8 @@ func (m *WebRTCManager) getConnection(mac string) (*peer, error) {
 
 	return peers, nil
 }
+
+func isGroupMAC(mac net.HardwareAddr) bool {
+	// The least significant bit of the first octet is set for broadcast and multicast addresses
+	return len(mac) > 0 && mac[0]&0x01 == 0x01
+}